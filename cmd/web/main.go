@@ -2,20 +2,26 @@ package main
 
 import (
 	"log"
+	"os"
 
 	"github.com/mlvieira/store/internal/application"
 	"github.com/mlvieira/store/internal/handlers"
 	"github.com/mlvieira/store/internal/router"
 )
 
-const version = "1.0.0"
+// Build information, overridden at link time with
+// -ldflags "-X main.commit=... -X main.buildTime=...".
+var (
+	version   = "1.0.0"
+	commit    = "unknown"
+	buildTime = "unknown"
+)
 
 func main() {
-	baseApp, cleanup, err := application.NewBaseApplication(version)
+	baseApp, err := application.NewBaseApplication(version, commit, buildTime)
 	if err != nil {
 		log.Fatalf("Error initializing application: %v", err)
 	}
-	defer cleanup()
 
 	baseHandlers := handlers.NewHandlers(baseApp)
 
@@ -25,6 +31,7 @@ func main() {
 	}
 
 	if err := router.Serve(baseApp, webRouter); err != nil {
-		baseApp.ErrorLog.Fatalf("Server error: %v", err)
+		baseApp.Logger.Error("server stopped with an error", "error", err)
+		os.Exit(1)
 	}
 }
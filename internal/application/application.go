@@ -1,23 +1,40 @@
 package application
 
 import (
-	"log"
+	"database/sql"
+	"log/slog"
 
 	"github.com/alexedwards/scs/v2"
+	"github.com/mlvieira/store/internal/cards"
 	"github.com/mlvieira/store/internal/config"
+	"github.com/mlvieira/store/internal/mailer"
 	"github.com/mlvieira/store/internal/render"
 	"github.com/mlvieira/store/internal/repository"
 	"github.com/mlvieira/store/internal/services"
+	"github.com/mlvieira/store/internal/shared"
 )
 
 // Application holds the core application context and dependencies.
 type Application struct {
-	Config       *config.Config
-	InfoLog      *log.Logger
-	ErrorLog     *log.Logger
-	Version      string
+	Config *config.Config
+	// Logger writes JSON lines. Handlers log through a request-scoped child
+	// of it that carries the request ID.
+	Logger  *slog.Logger
+	Version string
+	// Commit and BuildTime are injected at link time, see Makefile.example.
+	Commit       string
+	BuildTime    string
+	DB           *sql.DB
 	Repositories *repository.Repositories
 	Renderer     *render.Renderer
 	Session      *scs.SessionManager
 	Services     *services.Services
+	Gateway      cards.PaymentGateway
+	Mailer       *mailer.Mailer
+	// FakeGateway is the gateway behind Gateway when running with
+	// -gateway=fake, and nil otherwise. The API serves its confirmation
+	// step, which Stripe.js performs against Stripe itself.
+	FakeGateway *cards.FakeGateway
+	// ShutdownHooks run in order once the server has stopped.
+	ShutdownHooks []shared.ShutdownHook
 }
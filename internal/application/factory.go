@@ -1,29 +1,49 @@
 package application
 
 import (
+	"context"
+	"crypto/rand"
 	"encoding/gob"
+	"fmt"
+	"log/slog"
 	"time"
 
 	"github.com/alexedwards/scs/v2"
+	"github.com/mlvieira/store/internal/cards"
 	"github.com/mlvieira/store/internal/config"
 	"github.com/mlvieira/store/internal/driver"
+	"github.com/mlvieira/store/internal/mailer"
+	"github.com/mlvieira/store/internal/metrics"
 	"github.com/mlvieira/store/internal/models"
 	"github.com/mlvieira/store/internal/render"
 	"github.com/mlvieira/store/internal/repository"
 	"github.com/mlvieira/store/internal/services"
+	"github.com/mlvieira/store/internal/shared"
+	"github.com/mlvieira/store/internal/urlsigner"
 )
 
-// NewBaseApplication initializes the application with configuration, logging, and resources.
-func NewBaseApplication(version string) (*Application, func(), error) {
+// NewBaseApplication initializes the application with configuration, logging,
+// and resources. The resources are released by the application's shutdown
+// hooks, which flush the mail queue before closing the database.
+func NewBaseApplication(version, commit, buildTime string) (*Application, error) {
 	cfg := config.NewConfig()
 
-	infoLog, errorLog := config.NewLoggers()
+	logger := cfg.NewLogger()
+	// Route the standard library logger, used by some dependencies, through it.
+	slog.SetDefault(logger)
+
+	gateway, fakeGateway, err := newGateway(cfg)
+	if err != nil {
+		return nil, err
+	}
 
 	conn, err := driver.OpenDB(cfg.DB.DSN)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
+	metrics.RegisterDB(conn)
+
 	cleanup := func() {
 		conn.Close()
 	}
@@ -33,22 +53,101 @@ func NewBaseApplication(version string) (*Application, func(), error) {
 	sessionManager.Cookie.Persist = true
 	sessionManager.Cookie.Secure = cfg.Env == "production"
 
+	secretKey := []byte(cfg.SecretKey)
+	if len(secretKey) == 0 {
+		// Links signed with a random key stop working when the process restarts.
+		logger.Warn("SIGNING_SECRET is not set, using a random key")
+		secretKey = make([]byte, 32)
+		if _, err := rand.Read(secretKey); err != nil {
+			cleanup()
+			return nil, err
+		}
+	}
+
+	signer := urlsigner.New(secretKey)
+
+	transport, err := newMailTransport(cfg, logger)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+
+	mail, err := mailer.New(transport, cfg.Mail.From, mailWorkers, mailQueueSize, logger)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+
 	repositories := repository.NewRepositories(conn)
-	services := services.NewServices(repositories)
-	renderer := render.NewRenderer(cfg.Env, cfg.Stripe.Key, cfg.API, errorLog)
+	services := services.NewServices(repositories, gateway, mail, signer, cfg.FrontEnd)
+	renderer := render.NewRenderer(cfg.Env, cfg.Stripe.Key, cfg.API, cfg.Gateway, sessionManager, logger)
 
 	baseApp := &Application{
 		Config:       cfg,
-		InfoLog:      infoLog,
-		ErrorLog:     errorLog,
+		Logger:       logger,
 		Version:      version,
+		Commit:       commit,
+		BuildTime:    buildTime,
+		DB:           conn,
 		Repositories: repositories,
 		Renderer:     renderer,
 		Session:      sessionManager,
 		Services:     services,
+		Gateway:      gateway,
+		FakeGateway:  fakeGateway,
+		Mailer:       mail,
+		ShutdownHooks: []shared.ShutdownHook{
+			{Name: "mailer", Run: func(ctx context.Context) error {
+				if err := mail.Close(ctx); err != nil {
+					return fmt.Errorf("unsent mail dropped: %w", err)
+				}
+				return nil
+			}},
+			{Name: "database", Run: func(context.Context) error {
+				return conn.Close()
+			}},
+		},
 	}
 
 	gob.Register(models.TransactionData{})
 
-	return baseApp, cleanup, nil
+	return baseApp, nil
+}
+
+// Sizing of the mail worker pool. Checkout drops receipts rather than wait
+// once mailQueueSize messages are pending.
+const (
+	mailWorkers   = 4
+	mailQueueSize = 100
+)
+
+// newMailTransport selects the mail transport implementation from configuration.
+func newMailTransport(cfg *config.Config, logger *slog.Logger) (mailer.Transport, error) {
+	switch cfg.Mail.Transport {
+	case "smtp":
+		return mailer.NewSMTPTransport(cfg.Mail.SMTP.Host, cfg.Mail.SMTP.Port, cfg.Mail.SMTP.Username, cfg.Mail.SMTP.Password), nil
+	case "file":
+		return mailer.NewFileTransport(cfg.Mail.Dir)
+	case "log":
+		return mailer.NewLogTransport(logger), nil
+	default:
+		return nil, fmt.Errorf("invalid mail transport: %s", cfg.Mail.Transport)
+	}
+}
+
+// newGateway selects the payment gateway implementation from configuration
+// and wraps it with metrics. The fake gateway is also returned unwrapped.
+func newGateway(cfg *config.Config) (cards.PaymentGateway, *cards.FakeGateway, error) {
+	switch cfg.Gateway {
+	case "stripe":
+		return cards.NewInstrumentedGateway(cards.NewCard(cfg.Stripe.Secret, cfg.Stripe.Key)), nil, nil
+	case "fake":
+		fake, err := cards.NewFakeGatewayFile(cfg.FakeGatewayState)
+		if err != nil {
+			return nil, nil, err
+		}
+		return cards.NewInstrumentedGateway(fake), fake, nil
+	default:
+		return nil, nil, fmt.Errorf("invalid payment gateway: %s", cfg.Gateway)
+	}
 }
@@ -2,21 +2,36 @@ package cards
 
 import (
 	"errors"
+	"maps"
+	"time"
 
 	"github.com/stripe/stripe-go/v81"
-	"github.com/stripe/stripe-go/v81/charge"
-	"github.com/stripe/stripe-go/v81/customer"
-	"github.com/stripe/stripe-go/v81/paymentintent"
-	"github.com/stripe/stripe-go/v81/paymentmethod"
-	"github.com/stripe/stripe-go/v81/setupintent"
-	"github.com/stripe/stripe-go/v81/subscription"
+	"github.com/stripe/stripe-go/v81/client"
 )
 
-// Card represents payment card details for transactions.
+// Card is the Stripe implementation of PaymentGateway.
+// Each Card owns its own Stripe client, so cards built with different
+// secrets can be used concurrently without touching the global stripe.Key.
 type Card struct {
 	Secret   string
 	Key      string
 	Currency string
+	sc       *client.API
+}
+
+// NewCard creates a Stripe backed PaymentGateway.
+func NewCard(secret, key string) *Card {
+	return NewCardWithBackends(secret, key, nil)
+}
+
+// NewCardWithBackends creates a Stripe backed PaymentGateway using the given
+// backends, falling back to the default Stripe backends when nil.
+func NewCardWithBackends(secret, key string, backends *stripe.Backends) *Card {
+	return &Card{
+		Secret: secret,
+		Key:    key,
+		sc:     client.New(secret, backends),
+	}
 }
 
 // Transaction represents a financial transaction record.
@@ -29,20 +44,23 @@ type Transaction struct {
 }
 
 // Charge creates a payment intent for a specified currency and amount.
-func (c *Card) Charge(currency string, amount int64) (*stripe.PaymentIntent, string, error) {
-	return c.CreatePaymentIntent(currency, amount)
+func (c *Card) Charge(currency string, amount int64) (*PaymentIntent, string, error) {
+	return c.CreatePaymentIntent(currency, amount, nil)
 }
 
-// CreatePaymentIntent generates a Stripe payment intent for a given currency and amount.
-func (c *Card) CreatePaymentIntent(currency string, amount int64) (*stripe.PaymentIntent, string, error) {
-	stripe.Key = c.Secret
-
+// CreatePaymentIntent generates a Stripe payment intent for a given currency
+// and amount. The metadata is stored on the payment intent and sent back in
+// its webhook events.
+func (c *Card) CreatePaymentIntent(currency string, amount int64, metadata map[string]string) (*PaymentIntent, string, error) {
 	params := &stripe.PaymentIntentParams{
 		Amount:   stripe.Int64(amount),
 		Currency: stripe.String(currency),
 	}
+	for k, v := range metadata {
+		params.AddMetadata(k, v)
+	}
 
-	pi, err := paymentintent.New(params)
+	pi, err := c.sc.PaymentIntents.New(params)
 	if err != nil {
 		msg := ""
 		if stripeErr, ok := err.(*stripe.Error); ok {
@@ -52,13 +70,11 @@ func (c *Card) CreatePaymentIntent(currency string, amount int64) (*stripe.Payme
 		return nil, msg, err
 	}
 
-	return pi, "", nil
+	return PaymentIntentFromStripe(pi), "", nil
 }
 
 // CreateSetupIntent generates a Stripe SetupIntent to save payment details for future use.
-func (c *Card) CreateSetupIntent(customerID string, paymentMethodID string) (*stripe.SetupIntent, string, error) {
-	stripe.Key = c.Secret
-
+func (c *Card) CreateSetupIntent(customerID string, paymentMethodID string) (*SetupIntent, string, error) {
 	params := &stripe.SetupIntentParams{
 		Customer: stripe.String(customerID),
 		PaymentMethodTypes: stripe.StringSlice([]string{
@@ -71,7 +87,7 @@ func (c *Card) CreateSetupIntent(customerID string, paymentMethodID string) (*st
 		params.PaymentMethod = stripe.String(paymentMethodID)
 	}
 
-	si, err := setupintent.New(params)
+	si, err := c.sc.SetupIntents.New(params)
 	if err != nil {
 		msg := ""
 		if stripeErr, ok := err.(*stripe.Error); ok {
@@ -80,43 +96,66 @@ func (c *Card) CreateSetupIntent(customerID string, paymentMethodID string) (*st
 		return nil, msg, err
 	}
 
-	return si, "", nil
+	return setupIntentFromStripe(si), "", nil
 }
 
 // GetPaymentMethod gets the payment method by payment intent id
-func (c *Card) GetPaymentMethod(s string) (*stripe.PaymentMethod, error) {
-	stripe.Key = c.Secret
-
-	pm, err := paymentmethod.Get(s, nil)
+func (c *Card) GetPaymentMethod(s string) (*PaymentMethod, error) {
+	pm, err := c.sc.PaymentMethods.Get(s, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	return pm, nil
+	return paymentMethodFromStripe(pm), nil
 }
 
 // RetrievePaymentIntent gets an existing payment intent by id
-func (c *Card) RetrievePaymentIntent(id string) (*stripe.PaymentIntent, error) {
-	stripe.Key = c.Secret
-
-	pi, err := paymentintent.Get(id, nil)
+func (c *Card) RetrievePaymentIntent(id string) (*PaymentIntent, error) {
+	pi, err := c.sc.PaymentIntents.Get(id, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	return pi, nil
+	return PaymentIntentFromStripe(pi), nil
+}
+
+// ChargeSavedMethod charges a payment method saved for a customer without the
+// customer being present. When the bank asks for authentication it returns
+// the payment intent together with ErrAuthenticationRequired.
+func (c *Card) ChargeSavedMethod(customerID, pm, currency string, amount int64) (*PaymentIntent, string, error) {
+	params := &stripe.PaymentIntentParams{
+		Amount:        stripe.Int64(amount),
+		Currency:      stripe.String(currency),
+		Customer:      stripe.String(customerID),
+		PaymentMethod: stripe.String(pm),
+		Confirm:       stripe.Bool(true),
+		OffSession:    stripe.Bool(true),
+	}
+
+	pi, err := c.sc.PaymentIntents.New(params)
+	if err != nil {
+		msg := ""
+		if stripeErr, ok := err.(*stripe.Error); ok {
+			if stripeErr.Code == stripe.ErrorCodeAuthenticationRequired && stripeErr.PaymentIntent != nil {
+				return PaymentIntentFromStripe(stripeErr.PaymentIntent), "", ErrAuthenticationRequired
+			}
+			msg = cardErrorMessage(stripeErr.Code)
+		}
+
+		return nil, msg, err
+	}
+
+	return PaymentIntentFromStripe(pi), "", nil
 }
 
 // RetrieveChargeID retrieves the charge ID associated with a PaymentIntent
 func (c *Card) RetrieveChargeID(paymentIntentID string) (string, error) {
-	stripe.Key = c.Secret
-
 	params := &stripe.ChargeListParams{
 		PaymentIntent: stripe.String(paymentIntentID),
 	}
 	params.Filters.AddFilter("limit", "", "1")
 
-	iter := charge.List(params)
+	iter := c.sc.Charges.List(params)
 	if iter.Next() {
 		ch := iter.Charge()
 		return ch.ID, nil
@@ -130,9 +169,7 @@ func (c *Card) RetrieveChargeID(paymentIntentID string) (string, error) {
 }
 
 // CreateCustomer creates a customer in Stripe
-func (c *Card) CreateCustomer(pm, email string) (*stripe.Customer, string, error) {
-	stripe.Key = c.Secret
-
+func (c *Card) CreateCustomer(pm, email string) (*Customer, string, error) {
 	params := &stripe.CustomerParams{
 		PaymentMethod: stripe.String(pm),
 		Email:         stripe.String(email),
@@ -141,7 +178,7 @@ func (c *Card) CreateCustomer(pm, email string) (*stripe.Customer, string, error
 		},
 	}
 
-	cust, err := customer.New(params)
+	cust, err := c.sc.Customers.New(params)
 	if err != nil {
 		msg := ""
 		if stripeErr, ok := err.(*stripe.Error); ok {
@@ -151,18 +188,47 @@ func (c *Card) CreateCustomer(pm, email string) (*stripe.Customer, string, error
 		return nil, msg, err
 	}
 
-	return cust, "", nil
+	return customerFromStripe(cust), "", nil
+}
+
+// UpdateCustomerPaymentMethod attaches a payment method to an existing
+// customer and makes it the default for the customer's invoices.
+func (c *Card) UpdateCustomerPaymentMethod(customerID, pm string) (*Customer, string, error) {
+	attachParams := &stripe.PaymentMethodAttachParams{
+		Customer: stripe.String(customerID),
+	}
+
+	if _, err := c.sc.PaymentMethods.Attach(pm, attachParams); err != nil {
+		msg := ""
+		if stripeErr, ok := err.(*stripe.Error); ok {
+			msg = cardErrorMessage(stripeErr.Code)
+		}
+
+		return nil, msg, err
+	}
+
+	params := &stripe.CustomerParams{
+		InvoiceSettings: &stripe.CustomerInvoiceSettingsParams{
+			DefaultPaymentMethod: stripe.String(pm),
+		},
+	}
+
+	cust, err := c.sc.Customers.Update(customerID, params)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return customerFromStripe(cust), "", nil
 }
 
 // SubscribeToPlan subscribes a customer to a Stripe plan
-func (c *Card) SubscribeToPlan(cust *stripe.Customer, plan, email, last4, cardType string) (*stripe.Subscription, error) {
-	stripeCustomerID := cust.ID
+func (c *Card) SubscribeToPlan(customerID, plan, email, last4, cardType string) (*Subscription, error) {
 	items := []*stripe.SubscriptionItemsParams{
 		{Plan: stripe.String(plan)},
 	}
 
 	params := &stripe.SubscriptionParams{
-		Customer: stripe.String(stripeCustomerID),
+		Customer: stripe.String(customerID),
 		Items:    items,
 	}
 
@@ -170,11 +236,104 @@ func (c *Card) SubscribeToPlan(cust *stripe.Customer, plan, email, last4, cardTy
 	params.AddMetadata("card_type", cardType)
 	params.AddExpand("latest_invoice.payment_intent")
 
-	sub, err := subscription.New(params)
+	return subscriptionFromStripe(c.sc.Subscriptions.New(params))
+}
+
+// CancelSubscription cancels a subscription, either right away or at the end of the current period.
+func (c *Card) CancelSubscription(subscriptionID string, atPeriodEnd bool) (*Subscription, error) {
+	if atPeriodEnd {
+		params := &stripe.SubscriptionParams{
+			CancelAtPeriodEnd: stripe.Bool(true),
+		}
+		return subscriptionFromStripe(c.sc.Subscriptions.Update(subscriptionID, params))
+	}
+
+	return subscriptionFromStripe(c.sc.Subscriptions.Cancel(subscriptionID, nil))
+}
+
+// PauseSubscription pauses payment collection, voiding invoices while paused.
+func (c *Card) PauseSubscription(subscriptionID string) (*Subscription, error) {
+	params := &stripe.SubscriptionParams{
+		PauseCollection: &stripe.SubscriptionPauseCollectionParams{
+			Behavior: stripe.String("void"),
+		},
+	}
+
+	return subscriptionFromStripe(c.sc.Subscriptions.Update(subscriptionID, params))
+}
+
+// ResumeSubscription resumes payment collection on a paused subscription.
+func (c *Card) ResumeSubscription(subscriptionID string) (*Subscription, error) {
+	params := &stripe.SubscriptionParams{}
+	// An empty value unsets pause_collection.
+	params.AddExtra("pause_collection", "")
+
+	return subscriptionFromStripe(c.sc.Subscriptions.Update(subscriptionID, params))
+}
+
+// ChangeSubscriptionPlan moves a subscription to another plan, prorating the difference.
+func (c *Card) ChangeSubscriptionPlan(subscriptionID, plan string) (*Subscription, error) {
+	sub, err := c.sc.Subscriptions.Get(subscriptionID, nil)
 	if err != nil {
 		return nil, err
 	}
-	return sub, nil
+
+	if sub.Items == nil || len(sub.Items.Data) == 0 {
+		return nil, errors.New("subscription has no items")
+	}
+
+	params := &stripe.SubscriptionParams{
+		Items: []*stripe.SubscriptionItemsParams{
+			{
+				ID:   stripe.String(sub.Items.Data[0].ID),
+				Plan: stripe.String(plan),
+			},
+		},
+		ProrationBehavior: stripe.String("create_prorations"),
+	}
+
+	return subscriptionFromStripe(c.sc.Subscriptions.Update(subscriptionID, params))
+}
+
+// Refund refunds a payment intent. An amount of zero refunds whatever is left
+// of the charge, and doing so twice returns the first refund, so the webhook
+// and the customer's browser can both refund a purchase that was not recorded.
+func (c *Card) Refund(paymentIntentID string, amount int64) (*Refund, string, error) {
+	params := &stripe.RefundParams{
+		PaymentIntent: stripe.String(paymentIntentID),
+	}
+
+	if amount > 0 {
+		params.Amount = stripe.Int64(amount)
+	} else {
+		params.SetIdempotencyKey("refund-rest-" + paymentIntentID)
+	}
+
+	ref, err := c.sc.Refunds.New(params)
+	if err != nil {
+		msg := ""
+		if stripeErr, ok := err.(*stripe.Error); ok {
+			msg = cardErrorMessage(stripeErr.Code)
+		}
+
+		return nil, msg, err
+	}
+
+	return refundFromStripe(ref), "", nil
+}
+
+// IsDeclined reports whether err is the gateway rejecting a card, as
+// opposed to the call itself failing.
+func IsDeclined(err error) bool {
+	var stripeErr *stripe.Error
+	return errors.As(err, &stripeErr) && stripeErr.Type == stripe.ErrorTypeCard
+}
+
+// IsNotFound reports whether err is the gateway not knowing an object the
+// call refers to, such as a deleted customer.
+func IsNotFound(err error) bool {
+	var stripeErr *stripe.Error
+	return errors.As(err, &stripeErr) && stripeErr.Code == stripe.ErrorCodeResourceMissing
 }
 
 // cardErrorMessage maps Stripe error codes to user-friendly error messages.
@@ -196,9 +355,128 @@ func cardErrorMessage(code stripe.ErrorCode) string {
 	case stripe.ErrorCodePostalCodeInvalid:
 		msg = "Your postal code is invalid"
 	case stripe.ErrorCodeCardDeclined:
+		fallthrough
 	default:
 		msg = "Your card was declined"
 	}
 
 	return msg
 }
+
+// PaymentIntentFromStripe copies a Stripe payment intent, such as one
+// received in a webhook event.
+func PaymentIntentFromStripe(pi *stripe.PaymentIntent) *PaymentIntent {
+	intent := &PaymentIntent{
+		ID:           pi.ID,
+		Status:       PaymentIntentStatus(pi.Status),
+		Amount:       pi.Amount,
+		Currency:     string(pi.Currency),
+		ClientSecret: pi.ClientSecret,
+		Metadata:     maps.Clone(pi.Metadata),
+	}
+	if pi.Customer != nil {
+		intent.CustomerID = pi.Customer.ID
+	}
+	if pi.PaymentMethod != nil {
+		intent.PaymentMethodID = pi.PaymentMethod.ID
+	}
+	if pi.LatestCharge != nil {
+		intent.LatestChargeID = pi.LatestCharge.ID
+	}
+	if e := pi.LastPaymentError; e != nil {
+		intent.LastPaymentError = &PaymentError{Code: string(e.Code)}
+		if e.PaymentMethod != nil {
+			intent.LastPaymentError.PaymentMethodID = e.PaymentMethod.ID
+		}
+	}
+
+	return intent
+}
+
+// SubscriptionFromStripe copies a Stripe subscription, such as one received
+// in a webhook event.
+func SubscriptionFromStripe(sub *stripe.Subscription) *Subscription {
+	subscription := &Subscription{
+		ID:                sub.ID,
+		Status:            SubscriptionStatus(sub.Status),
+		Paused:            sub.PauseCollection != nil,
+		CancelAtPeriodEnd: sub.CancelAtPeriodEnd,
+		CurrentPeriodEnd:  time.Unix(sub.CurrentPeriodEnd, 0),
+	}
+	if sub.Customer != nil {
+		subscription.CustomerID = sub.Customer.ID
+	}
+	if sub.Items != nil && len(sub.Items.Data) > 0 && sub.Items.Data[0].Plan != nil {
+		subscription.PlanID = sub.Items.Data[0].Plan.ID
+	}
+	if sub.LatestInvoice != nil && sub.LatestInvoice.PaymentIntent != nil {
+		subscription.LatestPaymentIntent = PaymentIntentFromStripe(sub.LatestInvoice.PaymentIntent)
+	}
+
+	return subscription
+}
+
+// subscriptionFromStripe copies the subscription returned by a Stripe call.
+func subscriptionFromStripe(sub *stripe.Subscription, err error) (*Subscription, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	return SubscriptionFromStripe(sub), nil
+}
+
+// setupIntentFromStripe copies a Stripe setup intent.
+func setupIntentFromStripe(si *stripe.SetupIntent) *SetupIntent {
+	intent := &SetupIntent{
+		ID:           si.ID,
+		ClientSecret: si.ClientSecret,
+	}
+	if si.Customer != nil {
+		intent.CustomerID = si.Customer.ID
+	}
+	if si.PaymentMethod != nil {
+		intent.PaymentMethodID = si.PaymentMethod.ID
+	}
+
+	return intent
+}
+
+// paymentMethodFromStripe copies a Stripe payment method and its card details.
+func paymentMethodFromStripe(pm *stripe.PaymentMethod) *PaymentMethod {
+	method := &PaymentMethod{ID: pm.ID}
+	if pm.Card != nil {
+		method.Brand = string(pm.Card.Brand)
+		method.LastFour = pm.Card.Last4
+		method.ExpiryMonth = int(pm.Card.ExpMonth)
+		method.ExpiryYear = int(pm.Card.ExpYear)
+	}
+
+	return method
+}
+
+// customerFromStripe copies a Stripe customer.
+func customerFromStripe(cust *stripe.Customer) *Customer {
+	customer := &Customer{
+		ID:    cust.ID,
+		Email: cust.Email,
+	}
+	if cust.InvoiceSettings != nil && cust.InvoiceSettings.DefaultPaymentMethod != nil {
+		customer.DefaultPaymentMethodID = cust.InvoiceSettings.DefaultPaymentMethod.ID
+	}
+
+	return customer
+}
+
+// refundFromStripe copies a Stripe refund.
+func refundFromStripe(ref *stripe.Refund) *Refund {
+	refund := &Refund{
+		ID:       ref.ID,
+		Amount:   ref.Amount,
+		Currency: string(ref.Currency),
+	}
+	if ref.PaymentIntent != nil {
+		refund.PaymentIntentID = ref.PaymentIntent.ID
+	}
+
+	return refund
+}
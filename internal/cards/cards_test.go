@@ -0,0 +1,153 @@
+package cards
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stripe/stripe-go/v81"
+	"github.com/stripe/stripe-go/v81/form"
+)
+
+// stubBackend answers every Stripe call with an object whose ID is the key
+// the call was made with, and records the keys it saw.
+type stubBackend struct {
+	mu   sync.Mutex
+	keys map[string]int
+}
+
+func newStubBackend() *stubBackend {
+	return &stubBackend{keys: make(map[string]int)}
+}
+
+func (b *stubBackend) Call(method, path, key string, params stripe.ParamsContainer, v stripe.LastResponseSetter) error {
+	b.mu.Lock()
+	b.keys[key]++
+	b.mu.Unlock()
+
+	return json.Unmarshal([]byte(fmt.Sprintf(`{"id":%q}`, key)), v)
+}
+
+func (b *stubBackend) CallStreaming(method, path, key string, params stripe.ParamsContainer, v stripe.StreamingLastResponseSetter) error {
+	return fmt.Errorf("unexpected streaming call to %s", path)
+}
+
+func (b *stubBackend) CallRaw(method, path, key string, body *form.Values, params *stripe.Params, v stripe.LastResponseSetter) error {
+	return b.Call(method, path, key, nil, v)
+}
+
+func (b *stubBackend) CallMultipart(method, path, key, boundary string, body *bytes.Buffer, params *stripe.Params, v stripe.LastResponseSetter) error {
+	return b.Call(method, path, key, nil, v)
+}
+
+func (b *stubBackend) SetMaxNetworkRetries(int64) {}
+
+// seen returns a copy of the keys the backend was called with.
+func (b *stubBackend) seen() map[string]int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	keys := make(map[string]int, len(b.keys))
+	for k, n := range b.keys {
+		keys[k] = n
+	}
+	return keys
+}
+
+func TestCardsWithDifferentSecretsDoNotShareState(t *testing.T) {
+	const calls = 50
+
+	secrets := []string{"sk_test_first", "sk_test_second"}
+	backends := make([]*stubBackend, len(secrets))
+
+	var wg sync.WaitGroup
+	for i, secret := range secrets {
+		backend := newStubBackend()
+		backends[i] = backend
+
+		card := NewCardWithBackends(secret, "pk_test", &stripe.Backends{
+			API:     backend,
+			Connect: backend,
+			Uploads: backend,
+		})
+
+		for range calls {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				pi, _, err := card.CreatePaymentIntent("brl", 1000, nil)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if pi.ID != secret {
+					t.Errorf("payment intent created with key %q, want %q", pi.ID, secret)
+				}
+
+				pm, err := card.GetPaymentMethod("pm_card_visa")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if pm.ID != secret {
+					t.Errorf("payment method fetched with key %q, want %q", pm.ID, secret)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	for i, backend := range backends {
+		seen := backend.seen()
+		if len(seen) != 1 || seen[secrets[i]] != 2*calls {
+			t.Errorf("backend of %s saw keys %v, want only %d calls with its own", secrets[i], seen, 2*calls)
+		}
+	}
+
+	if stripe.Key != "" {
+		t.Errorf("global stripe.Key = %q, want it untouched", stripe.Key)
+	}
+}
+
+func TestSubscriptionFromStripe(t *testing.T) {
+	var sub stripe.Subscription
+	if err := json.Unmarshal([]byte(`{
+		"id": "sub_1",
+		"customer": "cus_1",
+		"status": "active",
+		"pause_collection": {"behavior": "void"},
+		"current_period_end": 1767225600,
+		"items": {"data": [{"id": "si_1", "plan": {"id": "price_bronze"}}]},
+		"latest_invoice": {
+			"id": "in_1",
+			"payment_intent": {
+				"id": "pi_1",
+				"status": "requires_payment_method",
+				"amount": 2000,
+				"currency": "brl",
+				"last_payment_error": {"code": "card_declined", "payment_method": {"id": "pm_1"}}
+			}
+		}
+	}`), &sub); err != nil {
+		t.Fatal(err)
+	}
+
+	got := SubscriptionFromStripe(&sub)
+	if got.ID != "sub_1" || got.CustomerID != "cus_1" || got.PlanID != "price_bronze" {
+		t.Errorf("subscription = %s of %s on %s, want sub_1 of cus_1 on price_bronze", got.ID, got.CustomerID, got.PlanID)
+	}
+	if got.Status != SubscriptionActive || !got.Paused || got.CurrentPeriodEnd.Unix() != 1767225600 {
+		t.Errorf("subscription is %s, paused %v, until %v", got.Status, got.Paused, got.CurrentPeriodEnd)
+	}
+
+	pi := got.LatestPaymentIntent
+	if pi == nil || pi.ID != "pi_1" || pi.Amount != 2000 || pi.Currency != "brl" {
+		t.Fatalf("latest payment intent = %+v, want pi_1 for 2000 brl", pi)
+	}
+	if pi.PaymentMethodID != "" || pi.LastPaymentError == nil || pi.LastPaymentError.PaymentMethodID != "pm_1" {
+		t.Errorf("declined card = %q, last error %+v, want pm_1 only in the error", pi.PaymentMethodID, pi.LastPaymentError)
+	}
+}
@@ -0,0 +1,694 @@
+package cards
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stripe/stripe-go/v81"
+)
+
+// Magic card numbers understood by FakeGateway. A payment method ID is
+// resolved to a card number by stripping its "pm_" prefix, so the browser
+// (or a test) can pass e.g. "pm_4000000000000002" to trigger a decline when
+// the payment is confirmed.
+const (
+	FakeCardSuccess             = "4242424242424242"
+	FakeCardDeclined            = "4000000000000002"
+	FakeCardExpired             = "4000000000000069"
+	FakeCardIncorrectCVC        = "4000000000000127"
+	FakeCardIncorrectZip        = "4000000000000036"
+	FakeCardPostalCodeInvalid   = "4000000000000044"
+	FakeCardBalanceInsufficient = "4000000000009995"
+	// FakeCardAuthenticationRequired succeeds on session but asks for
+	// authentication when charged off session.
+	FakeCardAuthenticationRequired = "4000002760003184"
+)
+
+// Amount limits enforced by FakeGateway when creating payment intents.
+const (
+	fakeMinAmount = 50
+	fakeMaxAmount = 99999999
+)
+
+// fakeDeclines maps magic card numbers to the Stripe error code they trigger.
+var fakeDeclines = map[string]stripe.ErrorCode{
+	FakeCardDeclined:            stripe.ErrorCodeCardDeclined,
+	FakeCardExpired:             stripe.ErrorCodeExpiredCard,
+	FakeCardIncorrectCVC:        stripe.ErrorCodeIncorrectCVC,
+	FakeCardIncorrectZip:        stripe.ErrorCodeIncorrectZip,
+	FakeCardPostalCodeInvalid:   stripe.ErrorCodePostalCodeInvalid,
+	FakeCardBalanceInsufficient: stripe.ErrorCodeBalanceInsufficient,
+}
+
+// FakeGateway is a PaymentGateway for offline development and tests. IDs
+// are minted from a counter so runs are deterministic.
+//
+// A gateway made by NewFakeGateway keeps its state in memory. One made by
+// NewFakeGatewayFile keeps it in a file that every process opening the same
+// path shares, so payment intents and customers created by the API server
+// are known to the web server too.
+type FakeGateway struct {
+	mu    sync.Mutex
+	path  string
+	lock  *os.File
+	state fakeState
+}
+
+// fakeState holds everything FakeGateway knows. Objects refer to each other
+// by ID only so the state can be written as JSON.
+type fakeState struct {
+	Seq            int                              `json:"seq"`
+	PaymentIntents map[string]*stripe.PaymentIntent `json:"payment_intents"`
+	SetupIntents   map[string]*stripe.SetupIntent   `json:"setup_intents"`
+	Customers      map[string]*stripe.Customer      `json:"customers"`
+	// Charges are keyed by the ID of their payment intent.
+	Charges       map[string]*stripe.Charge       `json:"charges"`
+	Subscriptions map[string]*stripe.Subscription `json:"subscriptions"`
+	Refunds       map[string][]*stripe.Refund     `json:"refunds"`
+	// RestRefunds are the refunds of the rest of a charge, keyed by the ID
+	// of their payment intent, replayed like Stripe's idempotent requests.
+	RestRefunds map[string]*stripe.Refund `json:"rest_refunds"`
+}
+
+// NewFakeGateway creates an empty FakeGateway that keeps its state in memory.
+func NewFakeGateway() *FakeGateway {
+	return &FakeGateway{state: newFakeState()}
+}
+
+// NewFakeGatewayFile creates a FakeGateway that keeps its state in the file
+// at path, creating the file's directory if needed. The state is reloaded
+// before and saved after every call, under an exclusive lock on path+".lock",
+// so several processes can share it.
+func NewFakeGatewayFile(path string) (*FakeGateway, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	f := NewFakeGateway()
+	f.path = path
+
+	return f, nil
+}
+
+// newFakeState returns an empty state.
+func newFakeState() fakeState {
+	return fakeState{
+		PaymentIntents: make(map[string]*stripe.PaymentIntent),
+		SetupIntents:   make(map[string]*stripe.SetupIntent),
+		Customers:      make(map[string]*stripe.Customer),
+		Charges:        make(map[string]*stripe.Charge),
+		Subscriptions:  make(map[string]*stripe.Subscription),
+		Refunds:        make(map[string][]*stripe.Refund),
+		RestRefunds:    make(map[string]*stripe.Refund),
+	}
+}
+
+// Ensure FakeGateway satisfies PaymentGateway.
+var _ PaymentGateway = (*FakeGateway)(nil)
+
+// CreatePaymentIntent mints a payment intent, rejecting amounts outside Stripe's limits.
+func (f *FakeGateway) CreatePaymentIntent(currency string, amount int64, metadata map[string]string) (_ *PaymentIntent, msg string, err error) {
+	if err := f.begin(); err != nil {
+		return nil, "", err
+	}
+	defer f.end(&err)
+
+	switch {
+	case amount < fakeMinAmount:
+		return nil, cardErrorMessage(stripe.ErrorCodeAmountTooSmall), fakeError(stripe.ErrorCodeAmountTooSmall)
+	case amount > fakeMaxAmount:
+		return nil, cardErrorMessage(stripe.ErrorCodeAmountTooLarge), fakeError(stripe.ErrorCodeAmountTooLarge)
+	}
+
+	id := f.nextID("pi")
+	pi := &stripe.PaymentIntent{
+		ID:           id,
+		Object:       "payment_intent",
+		Amount:       amount,
+		Currency:     stripe.Currency(currency),
+		ClientSecret: id + "_secret_fake",
+		Status:       stripe.PaymentIntentStatusRequiresPaymentMethod,
+		Created:      time.Now().Unix(),
+		Metadata:     maps.Clone(metadata),
+	}
+	f.state.PaymentIntents[id] = pi
+
+	return PaymentIntentFromStripe(pi), "", nil
+}
+
+// ConfirmPaymentIntent confirms a payment intent with a payment method, as
+// Stripe.js does in the browser. An empty pm confirms with the payment method
+// already attached. Magic decline cards leave the intent waiting for another
+// payment method with the decline as its last payment error; any other card
+// settles it.
+func (f *FakeGateway) ConfirmPaymentIntent(id, pm string) (_ *PaymentIntent, msg string, err error) {
+	if err := f.begin(); err != nil {
+		return nil, "", err
+	}
+	defer f.end(&err)
+
+	pi, ok := f.state.PaymentIntents[id]
+	if !ok {
+		return nil, "", fmt.Errorf("no such payment intent: %s", id)
+	}
+
+	if pi.Status == stripe.PaymentIntentStatusSucceeded {
+		return nil, "", fmt.Errorf("payment intent %s has already succeeded", id)
+	}
+
+	if pm == "" && pi.PaymentMethod != nil {
+		pm = pi.PaymentMethod.ID
+	}
+	if pm == "" {
+		return nil, "", fmt.Errorf("payment intent %s has no payment method", id)
+	}
+	pi.PaymentMethod = &stripe.PaymentMethod{ID: pm}
+
+	if code, declined := fakeDecline(pm); declined {
+		pi.Status = stripe.PaymentIntentStatusRequiresPaymentMethod
+		pi.LastPaymentError = fakeError(code)
+		return PaymentIntentFromStripe(pi), cardErrorMessage(code), pi.LastPaymentError
+	}
+
+	pi.LastPaymentError = nil
+	f.settle(pi)
+
+	return PaymentIntentFromStripe(pi), "", nil
+}
+
+// CreateSetupIntent mints a succeeded setup intent for a known customer.
+func (f *FakeGateway) CreateSetupIntent(customerID string, paymentMethodID string) (_ *SetupIntent, msg string, err error) {
+	if err := f.begin(); err != nil {
+		return nil, "", err
+	}
+	defer f.end(&err)
+
+	if _, ok := f.state.Customers[customerID]; !ok {
+		return nil, "", fmt.Errorf("no such customer: %s", customerID)
+	}
+
+	if code, declined := fakeDecline(paymentMethodID); declined {
+		return nil, cardErrorMessage(code), fakeError(code)
+	}
+
+	id := f.nextID("seti")
+	si := &stripe.SetupIntent{
+		ID:           id,
+		Object:       "setup_intent",
+		ClientSecret: id + "_secret_fake",
+		Customer:     &stripe.Customer{ID: customerID},
+		Status:       stripe.SetupIntentStatusSucceeded,
+		Usage:        stripe.SetupIntentUsageOffSession,
+	}
+	if paymentMethodID != "" {
+		si.PaymentMethod = &stripe.PaymentMethod{ID: paymentMethodID}
+	}
+	f.state.SetupIntents[id] = si
+
+	return setupIntentFromStripe(si), "", nil
+}
+
+// GetPaymentMethod resolves a payment method from the card number encoded in
+// its ID. Like Stripe, it returns decline cards too: they only fail when a
+// payment is confirmed or a card is attached.
+func (f *FakeGateway) GetPaymentMethod(s string) (*PaymentMethod, error) {
+	number := fakeCardNumber(s)
+
+	return paymentMethodFromStripe(&stripe.PaymentMethod{
+		ID:     s,
+		Object: "payment_method",
+		Type:   stripe.PaymentMethodTypeCard,
+		Card: &stripe.PaymentMethodCard{
+			Brand:    stripe.PaymentMethodCardBrandVisa,
+			Last4:    number[len(number)-4:],
+			ExpMonth: 12,
+			ExpYear:  int64(time.Now().Year() + 5),
+		},
+	}), nil
+}
+
+// RetrievePaymentIntent returns a known payment intent.
+func (f *FakeGateway) RetrievePaymentIntent(id string) (_ *PaymentIntent, err error) {
+	if err := f.begin(); err != nil {
+		return nil, err
+	}
+	defer f.end(&err)
+
+	pi, ok := f.state.PaymentIntents[id]
+	if !ok {
+		return nil, fmt.Errorf("no such payment intent: %s", id)
+	}
+
+	return PaymentIntentFromStripe(pi), nil
+}
+
+// ChargeSavedMethod charges a known customer off session. Magic decline
+// cards are declined and FakeCardAuthenticationRequired leaves the payment
+// intent waiting for the customer to confirm it.
+func (f *FakeGateway) ChargeSavedMethod(customerID, pm, currency string, amount int64) (_ *PaymentIntent, msg string, err error) {
+	if err := f.begin(); err != nil {
+		return nil, "", err
+	}
+	defer f.end(&err)
+
+	if _, ok := f.state.Customers[customerID]; !ok {
+		return nil, "", fakeMissingCustomer(customerID)
+	}
+
+	switch {
+	case amount < fakeMinAmount:
+		return nil, cardErrorMessage(stripe.ErrorCodeAmountTooSmall), fakeError(stripe.ErrorCodeAmountTooSmall)
+	case amount > fakeMaxAmount:
+		return nil, cardErrorMessage(stripe.ErrorCodeAmountTooLarge), fakeError(stripe.ErrorCodeAmountTooLarge)
+	}
+
+	if code, declined := fakeDecline(pm); declined {
+		return nil, cardErrorMessage(code), fakeError(code)
+	}
+
+	id := f.nextID("pi")
+	pi := &stripe.PaymentIntent{
+		ID:            id,
+		Object:        "payment_intent",
+		Amount:        amount,
+		Currency:      stripe.Currency(currency),
+		ClientSecret:  id + "_secret_fake",
+		Customer:      &stripe.Customer{ID: customerID},
+		PaymentMethod: &stripe.PaymentMethod{ID: pm},
+		Status:        stripe.PaymentIntentStatusRequiresPaymentMethod,
+		Created:       time.Now().Unix(),
+	}
+	f.state.PaymentIntents[id] = pi
+
+	if fakeCardNumber(pm) == FakeCardAuthenticationRequired {
+		pi.Status = stripe.PaymentIntentStatusRequiresAction
+		return PaymentIntentFromStripe(pi), "", ErrAuthenticationRequired
+	}
+
+	f.settle(pi)
+
+	return PaymentIntentFromStripe(pi), "", nil
+}
+
+// RetrieveChargeID returns the charge ID of a settled payment intent.
+func (f *FakeGateway) RetrieveChargeID(paymentIntentID string) (chargeID string, err error) {
+	if err := f.begin(); err != nil {
+		return "", err
+	}
+	defer f.end(&err)
+
+	ch, ok := f.state.Charges[paymentIntentID]
+	if !ok {
+		return "", errors.New("no charges found for this PaymentIntent")
+	}
+
+	return ch.ID, nil
+}
+
+// settle charges the full amount of a payment intent.
+// Callers must hold the gateway, see begin.
+func (f *FakeGateway) settle(pi *stripe.PaymentIntent) {
+	ch := &stripe.Charge{
+		ID:            f.nextID("ch"),
+		Object:        "charge",
+		Amount:        pi.Amount,
+		Currency:      pi.Currency,
+		Paid:          true,
+		PaymentIntent: &stripe.PaymentIntent{ID: pi.ID},
+		Status:        stripe.ChargeStatusSucceeded,
+	}
+	f.state.Charges[pi.ID] = ch
+	pi.Status = stripe.PaymentIntentStatusSucceeded
+	pi.LatestCharge = &stripe.Charge{ID: ch.ID}
+}
+
+// CreateCustomer mints a customer, declining magic card numbers.
+func (f *FakeGateway) CreateCustomer(pm, email string) (_ *Customer, msg string, err error) {
+	if err := f.begin(); err != nil {
+		return nil, "", err
+	}
+	defer f.end(&err)
+
+	if code, declined := fakeDecline(pm); declined {
+		return nil, cardErrorMessage(code), fakeError(code)
+	}
+
+	cust := &stripe.Customer{
+		ID:     f.nextID("cus"),
+		Object: "customer",
+		Email:  email,
+		InvoiceSettings: &stripe.CustomerInvoiceSettings{
+			DefaultPaymentMethod: &stripe.PaymentMethod{ID: pm},
+		},
+	}
+	f.state.Customers[cust.ID] = cust
+
+	return customerFromStripe(cust), "", nil
+}
+
+// UpdateCustomerPaymentMethod sets the default payment method of a known
+// customer, declining magic card numbers.
+func (f *FakeGateway) UpdateCustomerPaymentMethod(customerID, pm string) (_ *Customer, msg string, err error) {
+	if err := f.begin(); err != nil {
+		return nil, "", err
+	}
+	defer f.end(&err)
+
+	cust, ok := f.state.Customers[customerID]
+	if !ok {
+		return nil, "", fakeMissingCustomer(customerID)
+	}
+
+	if code, declined := fakeDecline(pm); declined {
+		return nil, cardErrorMessage(code), fakeError(code)
+	}
+
+	cust.InvoiceSettings = &stripe.CustomerInvoiceSettings{
+		DefaultPaymentMethod: &stripe.PaymentMethod{ID: pm},
+	}
+
+	return customerFromStripe(cust), "", nil
+}
+
+// SubscribeToPlan mints an active subscription for a known customer.
+func (f *FakeGateway) SubscribeToPlan(customerID, plan, email, last4, cardType string) (_ *Subscription, err error) {
+	if err := f.begin(); err != nil {
+		return nil, err
+	}
+	defer f.end(&err)
+
+	if _, ok := f.state.Customers[customerID]; !ok {
+		return nil, fmt.Errorf("no such customer: %s", customerID)
+	}
+
+	now := time.Now()
+	sub := &stripe.Subscription{
+		ID:                 f.nextID("sub"),
+		Object:             "subscription",
+		Customer:           &stripe.Customer{ID: customerID},
+		Status:             stripe.SubscriptionStatusActive,
+		CurrentPeriodStart: now.Unix(),
+		CurrentPeriodEnd:   now.AddDate(0, 1, 0).Unix(),
+		Items: &stripe.SubscriptionItemList{
+			Data: []*stripe.SubscriptionItem{
+				{ID: f.nextID("si"), Plan: &stripe.Plan{ID: plan}},
+			},
+		},
+		Metadata: map[string]string{
+			"last_four": last4,
+			"card_type": cardType,
+		},
+	}
+	f.state.Subscriptions[sub.ID] = sub
+
+	return SubscriptionFromStripe(sub), nil
+}
+
+// CancelSubscription cancels a subscription now or flags it to end with the current period.
+func (f *FakeGateway) CancelSubscription(subscriptionID string, atPeriodEnd bool) (_ *Subscription, err error) {
+	if err := f.begin(); err != nil {
+		return nil, err
+	}
+	defer f.end(&err)
+
+	sub, err := f.subscription(subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if atPeriodEnd {
+		sub.CancelAtPeriodEnd = true
+		sub.CancelAt = sub.CurrentPeriodEnd
+		return SubscriptionFromStripe(sub), nil
+	}
+
+	sub.Status = stripe.SubscriptionStatusCanceled
+	sub.CanceledAt = time.Now().Unix()
+	sub.EndedAt = sub.CanceledAt
+
+	return SubscriptionFromStripe(sub), nil
+}
+
+// PauseSubscription pauses payment collection on an active subscription.
+func (f *FakeGateway) PauseSubscription(subscriptionID string) (_ *Subscription, err error) {
+	if err := f.begin(); err != nil {
+		return nil, err
+	}
+	defer f.end(&err)
+
+	sub, err := f.subscription(subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+
+	sub.PauseCollection = &stripe.SubscriptionPauseCollection{
+		Behavior: stripe.SubscriptionPauseCollectionBehaviorVoid,
+	}
+
+	return SubscriptionFromStripe(sub), nil
+}
+
+// ResumeSubscription resumes payment collection on a paused subscription.
+func (f *FakeGateway) ResumeSubscription(subscriptionID string) (_ *Subscription, err error) {
+	if err := f.begin(); err != nil {
+		return nil, err
+	}
+	defer f.end(&err)
+
+	sub, err := f.subscription(subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+
+	sub.PauseCollection = nil
+
+	return SubscriptionFromStripe(sub), nil
+}
+
+// ChangeSubscriptionPlan swaps the plan of a subscription's only item.
+func (f *FakeGateway) ChangeSubscriptionPlan(subscriptionID, plan string) (_ *Subscription, err error) {
+	if err := f.begin(); err != nil {
+		return nil, err
+	}
+	defer f.end(&err)
+
+	sub, err := f.subscription(subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+
+	sub.Items.Data[0].Plan = &stripe.Plan{ID: plan}
+
+	return SubscriptionFromStripe(sub), nil
+}
+
+// subscription looks up a subscription that has not been canceled.
+// Callers must hold the gateway, see begin.
+func (f *FakeGateway) subscription(id string) (*stripe.Subscription, error) {
+	sub, ok := f.state.Subscriptions[id]
+	if !ok {
+		return nil, fmt.Errorf("no such subscription: %s", id)
+	}
+
+	if sub.Status == stripe.SubscriptionStatusCanceled {
+		return nil, fmt.Errorf("subscription %s is canceled", id)
+	}
+
+	return sub, nil
+}
+
+// Refund refunds a settled payment intent, rejecting refunds above what is
+// left. Like Card, refunding the rest of a charge twice returns the first
+// refund.
+func (f *FakeGateway) Refund(paymentIntentID string, amount int64) (_ *Refund, msg string, err error) {
+	if err := f.begin(); err != nil {
+		return nil, "", err
+	}
+	defer f.end(&err)
+
+	ch, ok := f.state.Charges[paymentIntentID]
+	if !ok {
+		return nil, "", fmt.Errorf("no charge to refund for payment intent: %s", paymentIntentID)
+	}
+
+	rest := amount == 0
+	if ref, ok := f.state.RestRefunds[paymentIntentID]; rest && ok {
+		return refundFromStripe(ref), "", nil
+	}
+
+	remaining := ch.Amount - ch.AmountRefunded
+	if rest {
+		amount = remaining
+	}
+
+	if amount <= 0 || amount > remaining {
+		return nil, "", fmt.Errorf("refund amount %d exceeds remaining balance %d", amount, remaining)
+	}
+
+	ref := &stripe.Refund{
+		ID:            f.nextID("re"),
+		Object:        "refund",
+		Amount:        amount,
+		Currency:      ch.Currency,
+		Charge:        &stripe.Charge{ID: ch.ID},
+		PaymentIntent: &stripe.PaymentIntent{ID: paymentIntentID},
+		Status:        stripe.RefundStatusSucceeded,
+	}
+	f.state.Refunds[paymentIntentID] = append(f.state.Refunds[paymentIntentID], ref)
+	if rest {
+		f.state.RestRefunds[paymentIntentID] = ref
+	}
+	ch.AmountRefunded += amount
+	ch.Refunded = ch.AmountRefunded == ch.Amount
+
+	return refundFromStripe(ref), "", nil
+}
+
+// nextID returns a sequential ID with the given Stripe object prefix.
+// Callers must hold the gateway, see begin.
+func (f *FakeGateway) nextID(prefix string) string {
+	f.state.Seq++
+	return fmt.Sprintf("%s_fake_%06d", prefix, f.state.Seq)
+}
+
+// begin takes the gateway for one call. With a state file it also locks the
+// file against other processes and reloads the state they may have changed.
+// Every call that succeeds in beginning must defer end.
+func (f *FakeGateway) begin() error {
+	f.mu.Lock()
+	if f.path == "" {
+		return nil
+	}
+
+	lock, err := os.OpenFile(f.path+".lock", os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		f.mu.Unlock()
+		return err
+	}
+
+	if err := lockFile(lock); err != nil {
+		lock.Close()
+		f.mu.Unlock()
+		return err
+	}
+	f.lock = lock
+
+	if err := f.load(); err != nil {
+		f.release()
+		return err
+	}
+
+	return nil
+}
+
+// end saves the state, when there is a state file, and releases the gateway.
+// A failure to save is reported through err unless the call already failed.
+func (f *FakeGateway) end(err *error) {
+	if f.path == "" {
+		f.mu.Unlock()
+		return
+	}
+
+	if saveErr := f.save(); saveErr != nil && *err == nil {
+		*err = saveErr
+	}
+	f.release()
+}
+
+// release unlocks the state file and the gateway.
+func (f *FakeGateway) release() {
+	unlockFile(f.lock)
+	f.lock.Close()
+	f.lock = nil
+	f.mu.Unlock()
+}
+
+// load replaces the state with the contents of the state file. A missing
+// file is an empty state.
+func (f *FakeGateway) load() error {
+	state := newFakeState()
+
+	data, err := os.ReadFile(f.path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal(data, &state); err != nil {
+			return fmt.Errorf("reading fake gateway state %s: %w", f.path, err)
+		}
+	}
+
+	f.state = state
+
+	return nil
+}
+
+// save writes the state to the state file, replacing it atomically so a
+// process that crashes midway leaves the previous state behind.
+func (f *FakeGateway) save() error {
+	data, err := json.Marshal(f.state)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), f.path)
+}
+
+// fakeCardNumber extracts the card number encoded in a payment method ID,
+// falling back to the generic success card.
+func fakeCardNumber(pm string) string {
+	number := strings.TrimPrefix(pm, "pm_")
+	if len(number) < 4 || strings.Trim(number, "0123456789") != "" {
+		return FakeCardSuccess
+	}
+	return number
+}
+
+// fakeDecline reports whether the payment method maps to a magic decline card.
+func fakeDecline(pm string) (stripe.ErrorCode, bool) {
+	code, ok := fakeDeclines[fakeCardNumber(pm)]
+	return code, ok
+}
+
+// fakeError builds a Stripe card error for the given code.
+func fakeError(code stripe.ErrorCode) *stripe.Error {
+	return &stripe.Error{
+		Code:           code,
+		HTTPStatusCode: 402,
+		Msg:            cardErrorMessage(code),
+		Type:           stripe.ErrorTypeCard,
+	}
+}
+
+// fakeMissingCustomer builds the Stripe error for an unknown customer.
+func fakeMissingCustomer(customerID string) *stripe.Error {
+	return &stripe.Error{
+		Code:           stripe.ErrorCodeResourceMissing,
+		HTTPStatusCode: 404,
+		Msg:            fmt.Sprintf("No such customer: '%s'", customerID),
+		Type:           stripe.ErrorTypeInvalidRequest,
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package cards
+
+import "os"
+
+// lockFile is a no-op where flock is not available. The state file is then
+// only safe to share between processes that do not call the gateway at the
+// same time.
+func lockFile(*os.File) error {
+	return nil
+}
+
+// unlockFile releases the lock taken by lockFile.
+func unlockFile(*os.File) error {
+	return nil
+}
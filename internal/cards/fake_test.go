@@ -0,0 +1,294 @@
+package cards
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stripe/stripe-go/v81"
+)
+
+// newSharedFakes returns two gateways sharing one state file, standing in
+// for the web and API servers.
+func newSharedFakes(t *testing.T) (web, api *FakeGateway) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "fake-gateway.json")
+
+	web, err := NewFakeGatewayFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api, err = NewFakeGatewayFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return web, api
+}
+
+func TestFakeGatewaySharesPaymentIntents(t *testing.T) {
+	web, api := newSharedFakes(t)
+
+	pi, _, err := api.CreatePaymentIntent("brl", 1000, nil)
+	if err != nil {
+		t.Fatalf("CreatePaymentIntent: %v", err)
+	}
+
+	if _, err := web.RetrieveChargeID(pi.ID); err == nil {
+		t.Fatal("RetrieveChargeID succeeded before the payment intent was confirmed")
+	}
+
+	if _, _, err := api.ConfirmPaymentIntent(pi.ID, "pm_"+FakeCardSuccess); err != nil {
+		t.Fatalf("ConfirmPaymentIntent: %v", err)
+	}
+
+	chargeID, err := web.RetrieveChargeID(pi.ID)
+	if err != nil {
+		t.Fatalf("RetrieveChargeID: %v", err)
+	}
+	if chargeID == "" {
+		t.Fatal("RetrieveChargeID returned an empty charge ID")
+	}
+
+	got, err := web.RetrievePaymentIntent(pi.ID)
+	if err != nil {
+		t.Fatalf("RetrievePaymentIntent: %v", err)
+	}
+	if got.Status != PaymentIntentSucceeded || got.Amount != 1000 {
+		t.Errorf("payment intent = %s %d, want succeeded 1000", got.Status, got.Amount)
+	}
+
+	if _, _, err := api.Refund(pi.ID, 400); err != nil {
+		t.Fatalf("Refund: %v", err)
+	}
+	if _, _, err := web.Refund(pi.ID, 700); err == nil {
+		t.Error("Refund above the remaining balance succeeded")
+	}
+}
+
+func TestFakeGatewaySharesCustomers(t *testing.T) {
+	web, api := newSharedFakes(t)
+
+	cust, _, err := api.CreateCustomer("pm_"+FakeCardSuccess, "ana@example.com")
+	if err != nil {
+		t.Fatalf("CreateCustomer: %v", err)
+	}
+
+	pi, _, err := web.ChargeSavedMethod(cust.ID, "pm_"+FakeCardSuccess, "brl", 2500)
+	if err != nil {
+		t.Fatalf("ChargeSavedMethod: %v", err)
+	}
+	if pi.Status != PaymentIntentSucceeded {
+		t.Errorf("status = %s, want succeeded", pi.Status)
+	}
+
+	if _, _, err := web.UpdateCustomerPaymentMethod(cust.ID, "pm_5555555555554444"); err != nil {
+		t.Fatalf("UpdateCustomerPaymentMethod: %v", err)
+	}
+
+	if _, _, err := web.CreateSetupIntent(cust.ID, "pm_5555555555554444"); err != nil {
+		t.Fatalf("CreateSetupIntent: %v", err)
+	}
+}
+
+func TestFakeGatewayConfirmDeclines(t *testing.T) {
+	for number, code := range fakeDeclines {
+		t.Run(string(code), func(t *testing.T) {
+			f := NewFakeGateway()
+
+			pi, _, err := f.CreatePaymentIntent("brl", 1000, nil)
+			if err != nil {
+				t.Fatalf("CreatePaymentIntent: %v", err)
+			}
+
+			declined, msg, err := f.ConfirmPaymentIntent(pi.ID, "pm_"+number)
+			var stripeErr *stripe.Error
+			if !errors.As(err, &stripeErr) || stripeErr.Code != code {
+				t.Fatalf("ConfirmPaymentIntent error = %v, want code %s", err, code)
+			}
+			if msg != cardErrorMessage(code) {
+				t.Errorf("message = %q, want %q", msg, cardErrorMessage(code))
+			}
+			if declined.Status != PaymentIntentRequiresPaymentMethod {
+				t.Errorf("status = %s, want requires_payment_method", declined.Status)
+			}
+			if declined.LastPaymentError == nil || declined.LastPaymentError.Code != string(code) {
+				t.Errorf("last payment error = %v, want code %s", declined.LastPaymentError, code)
+			}
+
+			if _, err := f.RetrieveChargeID(pi.ID); err == nil {
+				t.Error("declined payment intent has a charge")
+			}
+
+			// The customer can retry with another card.
+			if _, _, err := f.ConfirmPaymentIntent(pi.ID, "pm_"+FakeCardSuccess); err != nil {
+				t.Fatalf("retry ConfirmPaymentIntent: %v", err)
+			}
+			if _, err := f.RetrieveChargeID(pi.ID); err != nil {
+				t.Errorf("RetrieveChargeID after retry: %v", err)
+			}
+		})
+	}
+}
+
+func TestFakeGatewayAuthenticationRequired(t *testing.T) {
+	f := NewFakeGateway()
+
+	cust, _, err := f.CreateCustomer("pm_"+FakeCardAuthenticationRequired, "ana@example.com")
+	if err != nil {
+		t.Fatalf("CreateCustomer: %v", err)
+	}
+
+	pi, _, err := f.ChargeSavedMethod(cust.ID, "pm_"+FakeCardAuthenticationRequired, "brl", 1000)
+	if !errors.Is(err, ErrAuthenticationRequired) {
+		t.Fatalf("ChargeSavedMethod error = %v, want ErrAuthenticationRequired", err)
+	}
+	if pi.Status != PaymentIntentRequiresAction {
+		t.Errorf("status = %s, want requires_action", pi.Status)
+	}
+
+	if _, err := f.RetrieveChargeID(pi.ID); err == nil {
+		t.Error("payment intent waiting for authentication has a charge")
+	}
+
+	// The customer authenticates on session with the saved card.
+	confirmed, _, err := f.ConfirmPaymentIntent(pi.ID, "")
+	if err != nil {
+		t.Fatalf("ConfirmPaymentIntent: %v", err)
+	}
+	if confirmed.Status != PaymentIntentSucceeded {
+		t.Errorf("status = %s, want succeeded", confirmed.Status)
+	}
+}
+
+func TestFakeGatewayFileConcurrentProcesses(t *testing.T) {
+	web, api := newSharedFakes(t)
+
+	const perGateway = 20
+
+	var (
+		mu  sync.Mutex
+		ids = make(map[string]bool)
+		wg  sync.WaitGroup
+	)
+
+	for _, f := range []*FakeGateway{web, api, web, api} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range perGateway {
+				pi, _, err := f.CreatePaymentIntent("brl", 1000, nil)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				ids[pi.ID] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(ids) != 4*perGateway {
+		t.Errorf("minted %d distinct IDs, want %d", len(ids), 4*perGateway)
+	}
+
+	for id := range ids {
+		if _, err := web.RetrievePaymentIntent(id); err != nil {
+			t.Errorf("RetrievePaymentIntent(%s): %v", id, err)
+		}
+	}
+}
+
+func TestFakeGatewayRefundRestIsIdempotent(t *testing.T) {
+	f := NewFakeGateway()
+
+	pi, _, err := f.CreatePaymentIntent("brl", 1000, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := f.ConfirmPaymentIntent(pi.ID, "pm_"+FakeCardSuccess); err != nil {
+		t.Fatal(err)
+	}
+
+	// The webhook and the customer's browser both refund a sold out purchase.
+	first, _, err := f.Refund(pi.ID, 0)
+	if err != nil {
+		t.Fatalf("Refund: %v", err)
+	}
+	second, _, err := f.Refund(pi.ID, 0)
+	if err != nil {
+		t.Fatalf("second Refund: %v", err)
+	}
+	if second.ID != first.ID || first.Amount != 1000 {
+		t.Errorf("refunds = %s %d and %s, want one refund of 1000", first.ID, first.Amount, second.ID)
+	}
+
+	if _, _, err := f.Refund(pi.ID, 1); err == nil {
+		t.Error("Refund above the remaining balance succeeded")
+	}
+}
+
+func TestFakeGatewayReturnsCopies(t *testing.T) {
+	f := NewFakeGateway()
+
+	pi, _, err := f.CreatePaymentIntent("brl", 1000, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Confirming must not change the object the caller already holds, and
+	// the caller's changes must not reach the gateway.
+	if _, _, err := f.ConfirmPaymentIntent(pi.ID, "pm_"+FakeCardSuccess); err != nil {
+		t.Fatal(err)
+	}
+	if pi.Status != PaymentIntentRequiresPaymentMethod {
+		t.Errorf("held payment intent changed to %s", pi.Status)
+	}
+
+	pi.Amount = 1
+	got, err := f.RetrievePaymentIntent(pi.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Amount != 1000 || got.Status != PaymentIntentSucceeded {
+		t.Errorf("stored payment intent = %d %s, want 1000 succeeded", got.Amount, got.Status)
+	}
+}
+
+func TestFakeGatewayConcurrentReads(t *testing.T) {
+	f := NewFakeGateway()
+
+	cust, _, err := f.CreateCustomer("pm_"+FakeCardSuccess, "ana@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := f.SubscribeToPlan(cust.ID, "price_bronze", "ana@example.com", "4242", "visa")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Run with -race: readers of returned subscriptions must not race with
+	// the calls that change the stored one.
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				got, err := f.ChangeSubscriptionPlan(sub.ID, "price_silver")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				_ = got.PlanID
+				_ = sub.PlanID
+			}
+		}()
+	}
+	wg.Wait()
+}
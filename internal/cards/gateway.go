@@ -0,0 +1,122 @@
+package cards
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrAuthenticationRequired is returned by ChargeSavedMethod when the bank
+// wants the customer to authenticate the payment. The customer can complete
+// it on session by confirming the returned payment intent.
+var ErrAuthenticationRequired = errors.New("payment requires customer authentication")
+
+// PaymentGateway defines the operations the application needs from a payment processor.
+type PaymentGateway interface {
+	CreatePaymentIntent(currency string, amount int64, metadata map[string]string) (*PaymentIntent, string, error)
+	CreateSetupIntent(customerID string, paymentMethodID string) (*SetupIntent, string, error)
+	GetPaymentMethod(s string) (*PaymentMethod, error)
+	RetrievePaymentIntent(id string) (*PaymentIntent, error)
+	ChargeSavedMethod(customerID, pm, currency string, amount int64) (*PaymentIntent, string, error)
+	RetrieveChargeID(paymentIntentID string) (string, error)
+	CreateCustomer(pm, email string) (*Customer, string, error)
+	UpdateCustomerPaymentMethod(customerID, pm string) (*Customer, string, error)
+	SubscribeToPlan(customerID, plan, email, last4, cardType string) (*Subscription, error)
+	Refund(paymentIntentID string, amount int64) (*Refund, string, error)
+	CancelSubscription(subscriptionID string, atPeriodEnd bool) (*Subscription, error)
+	PauseSubscription(subscriptionID string) (*Subscription, error)
+	ResumeSubscription(subscriptionID string) (*Subscription, error)
+	ChangeSubscriptionPlan(subscriptionID, plan string) (*Subscription, error)
+}
+
+// Ensure Card satisfies PaymentGateway.
+var _ PaymentGateway = (*Card)(nil)
+
+// PaymentIntentStatus is the state of a payment intent.
+type PaymentIntentStatus string
+
+// Payment intent states the application acts on.
+const (
+	PaymentIntentRequiresPaymentMethod PaymentIntentStatus = "requires_payment_method"
+	PaymentIntentRequiresAction        PaymentIntentStatus = "requires_action"
+	PaymentIntentSucceeded             PaymentIntentStatus = "succeeded"
+)
+
+// PaymentIntent is a payment collected through the gateway. It is written as
+// JSON to the browser, which confirms it with the client secret.
+type PaymentIntent struct {
+	ID           string              `json:"id"`
+	Status       PaymentIntentStatus `json:"status"`
+	Amount       int64               `json:"amount"`
+	Currency     string              `json:"currency"`
+	ClientSecret string              `json:"client_secret"`
+	CustomerID   string              `json:"customer,omitempty"`
+	// PaymentMethodID is the card paying the intent. A failed off-session
+	// confirmation detaches the card, which is then only reported in
+	// LastPaymentError.
+	PaymentMethodID  string            `json:"payment_method,omitempty"`
+	LatestChargeID   string            `json:"latest_charge,omitempty"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+	LastPaymentError *PaymentError     `json:"last_payment_error,omitempty"`
+}
+
+// PaymentError is why the last attempt to pay a payment intent failed.
+type PaymentError struct {
+	Code            string `json:"code"`
+	PaymentMethodID string `json:"payment_method,omitempty"`
+}
+
+// SetupIntent collects a card to charge later without the customer present.
+type SetupIntent struct {
+	ID              string
+	ClientSecret    string
+	CustomerID      string
+	PaymentMethodID string
+}
+
+// PaymentMethod is a card known to the gateway.
+type PaymentMethod struct {
+	ID          string
+	Brand       string
+	LastFour    string
+	ExpiryMonth int
+	ExpiryYear  int
+}
+
+// Customer is a customer of the gateway, billed with their default payment method.
+type Customer struct {
+	ID                     string
+	Email                  string
+	DefaultPaymentMethodID string
+}
+
+// SubscriptionStatus is the state of a subscription.
+type SubscriptionStatus string
+
+// Subscription states the application acts on.
+const (
+	SubscriptionActive   SubscriptionStatus = "active"
+	SubscriptionCanceled SubscriptionStatus = "canceled"
+)
+
+// Subscription bills a customer for a plan every period.
+type Subscription struct {
+	ID         string
+	CustomerID string
+	Status     SubscriptionStatus
+	// Paused reports whether payment collection is paused.
+	Paused            bool
+	PlanID            string
+	CancelAtPeriodEnd bool
+	CurrentPeriodEnd  time.Time
+	// LatestPaymentIntent pays the latest invoice, when it was created with
+	// the subscription.
+	LatestPaymentIntent *PaymentIntent
+}
+
+// Refund gives back all or part of a payment.
+type Refund struct {
+	ID              string
+	PaymentIntentID string
+	Amount          int64
+	Currency        string
+}
@@ -0,0 +1,128 @@
+package cards
+
+import (
+	"errors"
+	"time"
+
+	"github.com/mlvieira/store/internal/metrics"
+	"github.com/stripe/stripe-go/v81"
+)
+
+// InstrumentedGateway wraps a PaymentGateway and records the count and
+// latency of every call, labelled by outcome and Stripe error code.
+type InstrumentedGateway struct {
+	next PaymentGateway
+}
+
+// NewInstrumentedGateway wraps gateway with metrics.
+func NewInstrumentedGateway(gateway PaymentGateway) *InstrumentedGateway {
+	return &InstrumentedGateway{next: gateway}
+}
+
+// Ensure InstrumentedGateway satisfies PaymentGateway.
+var _ PaymentGateway = (*InstrumentedGateway)(nil)
+
+// observe records a call that started at start and returned err.
+func observe(operation string, start time.Time, err error) {
+	outcome, code := "success", ""
+
+	var stripeErr *stripe.Error
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrAuthenticationRequired):
+		outcome, code = "authentication_required", string(stripe.ErrorCodeAuthenticationRequired)
+	case errors.As(err, &stripeErr):
+		outcome, code = "error", string(stripeErr.Code)
+		if stripeErr.Type == stripe.ErrorTypeCard {
+			outcome = "declined"
+		}
+	default:
+		outcome = "error"
+	}
+
+	metrics.ObserveGatewayCall(operation, outcome, code, time.Since(start))
+}
+
+// CreatePaymentIntent records and forwards the call.
+func (g *InstrumentedGateway) CreatePaymentIntent(currency string, amount int64, metadata map[string]string) (pi *PaymentIntent, msg string, err error) {
+	defer func(start time.Time) { observe("create_payment_intent", start, err) }(time.Now())
+	return g.next.CreatePaymentIntent(currency, amount, metadata)
+}
+
+// CreateSetupIntent records and forwards the call.
+func (g *InstrumentedGateway) CreateSetupIntent(customerID string, paymentMethodID string) (si *SetupIntent, msg string, err error) {
+	defer func(start time.Time) { observe("create_setup_intent", start, err) }(time.Now())
+	return g.next.CreateSetupIntent(customerID, paymentMethodID)
+}
+
+// GetPaymentMethod records and forwards the call.
+func (g *InstrumentedGateway) GetPaymentMethod(s string) (pm *PaymentMethod, err error) {
+	defer func(start time.Time) { observe("get_payment_method", start, err) }(time.Now())
+	return g.next.GetPaymentMethod(s)
+}
+
+// RetrievePaymentIntent records and forwards the call.
+func (g *InstrumentedGateway) RetrievePaymentIntent(id string) (pi *PaymentIntent, err error) {
+	defer func(start time.Time) { observe("retrieve_payment_intent", start, err) }(time.Now())
+	return g.next.RetrievePaymentIntent(id)
+}
+
+// ChargeSavedMethod records and forwards the call.
+func (g *InstrumentedGateway) ChargeSavedMethod(customerID, pm, currency string, amount int64) (pi *PaymentIntent, msg string, err error) {
+	defer func(start time.Time) { observe("charge_saved_method", start, err) }(time.Now())
+	return g.next.ChargeSavedMethod(customerID, pm, currency, amount)
+}
+
+// RetrieveChargeID records and forwards the call.
+func (g *InstrumentedGateway) RetrieveChargeID(paymentIntentID string) (id string, err error) {
+	defer func(start time.Time) { observe("retrieve_charge_id", start, err) }(time.Now())
+	return g.next.RetrieveChargeID(paymentIntentID)
+}
+
+// CreateCustomer records and forwards the call.
+func (g *InstrumentedGateway) CreateCustomer(pm, email string) (cust *Customer, msg string, err error) {
+	defer func(start time.Time) { observe("create_customer", start, err) }(time.Now())
+	return g.next.CreateCustomer(pm, email)
+}
+
+// UpdateCustomerPaymentMethod records and forwards the call.
+func (g *InstrumentedGateway) UpdateCustomerPaymentMethod(customerID, pm string) (cust *Customer, msg string, err error) {
+	defer func(start time.Time) { observe("update_customer_payment_method", start, err) }(time.Now())
+	return g.next.UpdateCustomerPaymentMethod(customerID, pm)
+}
+
+// SubscribeToPlan records and forwards the call.
+func (g *InstrumentedGateway) SubscribeToPlan(customerID, plan, email, last4, cardType string) (sub *Subscription, err error) {
+	defer func(start time.Time) { observe("subscribe_to_plan", start, err) }(time.Now())
+	return g.next.SubscribeToPlan(customerID, plan, email, last4, cardType)
+}
+
+// Refund records and forwards the call.
+func (g *InstrumentedGateway) Refund(paymentIntentID string, amount int64) (ref *Refund, msg string, err error) {
+	defer func(start time.Time) { observe("refund", start, err) }(time.Now())
+	return g.next.Refund(paymentIntentID, amount)
+}
+
+// CancelSubscription records and forwards the call.
+func (g *InstrumentedGateway) CancelSubscription(subscriptionID string, atPeriodEnd bool) (sub *Subscription, err error) {
+	defer func(start time.Time) { observe("cancel_subscription", start, err) }(time.Now())
+	return g.next.CancelSubscription(subscriptionID, atPeriodEnd)
+}
+
+// PauseSubscription records and forwards the call.
+func (g *InstrumentedGateway) PauseSubscription(subscriptionID string) (sub *Subscription, err error) {
+	defer func(start time.Time) { observe("pause_subscription", start, err) }(time.Now())
+	return g.next.PauseSubscription(subscriptionID)
+}
+
+// ResumeSubscription records and forwards the call.
+func (g *InstrumentedGateway) ResumeSubscription(subscriptionID string) (sub *Subscription, err error) {
+	defer func(start time.Time) { observe("resume_subscription", start, err) }(time.Now())
+	return g.next.ResumeSubscription(subscriptionID)
+}
+
+// ChangeSubscriptionPlan records and forwards the call.
+func (g *InstrumentedGateway) ChangeSubscriptionPlan(subscriptionID, plan string) (sub *Subscription, err error) {
+	defer func(start time.Time) { observe("change_subscription_plan", start, err) }(time.Now())
+	return g.next.ChangeSubscriptionPlan(subscriptionID, plan)
+}
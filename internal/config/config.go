@@ -1,22 +1,54 @@
 package config
 
 import (
+	"errors"
 	"flag"
-	"log"
+	"fmt"
+	"log/slog"
 	"os"
+	"strings"
+	"time"
+
+	"github.com/mlvieira/store/internal/logging"
 )
 
 // Config holds application configuration settings.
 type Config struct {
-	Port int
-	Env  string
-	API  string
-	DB   struct {
+	Port      int
+	Env       string
+	API       string
+	Gateway   string
+	FrontEnd  string
+	SecretKey string
+	// FakeGatewayState is the file the fake gateway keeps its state in. The
+	// web and API servers must share it to see each other's payments.
+	FakeGatewayState string
+	// LogLevel is the lowest level written to the log.
+	LogLevel slog.Level
+	// MetricsToken is the bearer token Prometheus scrapes /metrics with.
+	// The endpoint is not served when it is empty.
+	MetricsToken string
+	// ShutdownTimeout bounds how long the server waits for open requests,
+	// and then for the shutdown hooks, when it is stopped.
+	ShutdownTimeout time.Duration
+	DB              struct {
 		DSN string
 	}
+	Mail struct {
+		Transport string
+		From      string
+		Dir       string
+		SMTP      struct {
+			Host     string
+			Port     int
+			Username string
+			Password string
+		}
+	}
 	Stripe struct {
-		Secret string
-		Key    string
+		Secret        string
+		Key           string
+		WebhookSecret string
 	}
 }
 
@@ -28,18 +60,65 @@ func NewConfig() *Config {
 	flag.StringVar(&cfg.Env, "env", "development", "Application enviroment {development|production}")
 	flag.StringVar(&cfg.DB.DSN, "dsn", "dev:dev@tcp(localhost:3306)/store?parseTime=true&tls=false", "DSN")
 	flag.StringVar(&cfg.API, "api", "http://localhost:4001", "URL to api")
+	flag.StringVar(&cfg.Gateway, "gateway", "stripe", "Payment gateway {stripe|fake}")
+	flag.StringVar(&cfg.FakeGatewayState, "fake-gateway-state", "./tmp/fake-gateway.json", "File the fake payment gateway keeps its state in")
+	flag.StringVar(&cfg.FrontEnd, "frontend", "http://localhost:4000", "URL to front end")
+	flag.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", 10*time.Second, "How long to wait for open requests when stopping")
+	flag.TextVar(&cfg.LogLevel, "log-level", slog.LevelInfo, "Lowest level logged {debug|info|warn|error}")
+
+	flag.StringVar(&cfg.Mail.Transport, "mail", "file", "Mail transport {smtp|file|log}; log leaves out bodies, so reset and sign-in links need file or smtp")
+	flag.StringVar(&cfg.Mail.From, "mail-from", "Widgets <no-reply@widgets.local>", "Sender of outgoing mail")
+	flag.StringVar(&cfg.Mail.Dir, "mail-dir", "./tmp/mail", "Directory the file mail transport writes to")
+	flag.StringVar(&cfg.Mail.SMTP.Host, "smtp-host", "localhost", "SMTP host")
+	flag.IntVar(&cfg.Mail.SMTP.Port, "smtp-port", 1025, "SMTP port")
 
 	flag.Parse()
 
 	cfg.Stripe.Key = os.Getenv("STRIPE_KEY")
 	cfg.Stripe.Secret = os.Getenv("STRIPE_SECRET_KEY")
+	cfg.Stripe.WebhookSecret = os.Getenv("STRIPE_WEBHOOK_SECRET")
+	cfg.SecretKey = os.Getenv("SIGNING_SECRET")
+	cfg.MetricsToken = os.Getenv("METRICS_TOKEN")
+	cfg.Mail.SMTP.Username = os.Getenv("SMTP_USERNAME")
+	cfg.Mail.SMTP.Password = os.Getenv("SMTP_PASSWORD")
 
 	return cfg
 }
 
-// NewLoggers creates info and error loggers.
-func NewLoggers() (*log.Logger, *log.Logger) {
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	return infoLog, errorLog
+// CheckGateway reports the settings the configured payment gateway needs but
+// were not given.
+func (c *Config) CheckGateway() error {
+	switch c.Gateway {
+	case "fake":
+		return nil
+	case "stripe":
+		var missing []string
+		if c.Stripe.Key == "" {
+			missing = append(missing, "STRIPE_KEY")
+		}
+		if c.Stripe.Secret == "" {
+			missing = append(missing, "STRIPE_SECRET_KEY")
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("missing %s", strings.Join(missing, ", "))
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid payment gateway: %s", c.Gateway)
+	}
+}
+
+// CheckWebhooks reports whether the secret to verify the configured payment
+// gateway's webhooks is missing. Only the API server, which receives them,
+// needs it.
+func (c *Config) CheckWebhooks() error {
+	if c.Gateway == "stripe" && c.Stripe.WebhookSecret == "" {
+		return errors.New("missing STRIPE_WEBHOOK_SECRET")
+	}
+	return nil
+}
+
+// NewLogger creates the JSON logger of the application, writing to stdout.
+func (c *Config) NewLogger() *slog.Logger {
+	return logging.New(os.Stdout, c.LogLevel)
 }
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckWebhooksOnlyAffectsWebhooks(t *testing.T) {
+	cfg := &Config{Gateway: "stripe"}
+	cfg.Stripe.Key = "pk_test_1"
+	cfg.Stripe.Secret = "sk_test_1"
+
+	// The web server never verifies webhooks, so it is ready without the secret.
+	if err := cfg.CheckGateway(); err != nil {
+		t.Errorf("CheckGateway = %v, want nil", err)
+	}
+
+	err := cfg.CheckWebhooks()
+	if err == nil || !strings.Contains(err.Error(), "STRIPE_WEBHOOK_SECRET") {
+		t.Fatalf("CheckWebhooks = %v, want STRIPE_WEBHOOK_SECRET missing", err)
+	}
+
+	cfg.Stripe.WebhookSecret = "whsec_1"
+	if err := cfg.CheckWebhooks(); err != nil {
+		t.Errorf("CheckWebhooks = %v, want nil", err)
+	}
+}
+
+func TestNewConfigDefaultsToFileMail(t *testing.T) {
+	cfg := NewConfig()
+
+	// The log transport leaves out bodies, so without a file or SMTP
+	// transport nobody could follow a reset or sign-in link in development.
+	if cfg.Mail.Transport != "file" {
+		t.Errorf("default mail transport = %q, want file", cfg.Mail.Transport)
+	}
+}
@@ -1,15 +1,28 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/mlvieira/store/internal/cards"
 	"github.com/mlvieira/store/internal/handlers"
+	"github.com/mlvieira/store/internal/invoice"
+	"github.com/mlvieira/store/internal/mailer"
+	"github.com/mlvieira/store/internal/middleware"
 	"github.com/mlvieira/store/internal/models"
-	"github.com/stripe/stripe-go/v81"
+	"github.com/mlvieira/store/internal/repository"
+	"github.com/mlvieira/store/internal/services"
+	"github.com/stripe/stripe-go/v81/webhook"
 )
 
 // APIHandlers embeds the shared Handlers to provide API-specific handlers.
@@ -22,80 +35,253 @@ func NewAPIHandlers(h *handlers.Handlers) *APIHandlers {
 	return &APIHandlers{Handlers: h}
 }
 
-// GetPaymentIntent creates a Stripe payment intent and returns it as JSON.
+// GetPaymentIntent creates a Stripe payment intent for one unit of a widget
+// and returns it as JSON. The amount charged is the widget's price, and the
+// widget and customer are stored on the payment intent so the purchase can
+// be recorded from it alone.
 func (h *APIHandlers) GetPaymentIntent(w http.ResponseWriter, r *http.Request) {
 	var payload stripePayload
 
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		h.App.ErrorLog.Println(err)
+		h.Logger(r).Warn("decoding payment intent payload failed", "error", err)
 		return
 	}
 
-	h.App.InfoLog.Printf("Calling card.Charge with Currency: %s, Amount: %d", payload.Currency, payload.Amount)
-	card := cards.Card{
-		Secret:   h.App.Config.Stripe.Secret,
-		Key:      h.App.Config.Stripe.Key,
-		Currency: payload.Currency,
+	productID, err := strconv.Atoi(payload.ProductID)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, jsonResponse{
+			OK:      false,
+			Message: "Invalid product ID",
+		}, h.Logger(r))
+		return
+	}
+
+	if strings.TrimSpace(payload.Email) == "" {
+		writeJSON(w, http.StatusBadRequest, jsonResponse{
+			OK:      false,
+			Message: "Please provide a valid email.",
+		}, h.Logger(r))
+		return
+	}
+
+	widget, err := h.App.Repositories.Widget.GetWidgetByID(r.Context(), productID)
+	if err == nil && widget.Archived {
+		err = sql.ErrNoRows
+	}
+	if err != nil {
+		h.Logger(r).Warn("loading widget failed", "widget_id", productID, "error", err)
+		writeJSON(w, http.StatusNotFound, jsonResponse{
+			OK:      false,
+			Message: "Product not found",
+		}, h.Logger(r))
+		return
+	}
+
+	if !widget.IsRecurring && widget.InventoryLevel < 1 {
+		writeJSON(w, http.StatusConflict, jsonResponse{
+			OK:      false,
+			Message: "Sorry, this widget is out of stock",
+		}, h.Logger(r))
+		return
 	}
 
-	pi, msg, err := card.Charge(payload.Currency, payload.Amount)
+	h.createPaymentIntent(w, r, models.Currency, widget.Price, services.PurchaseMetadata(widget.ID, models.Customer{
+		FirstName: payload.FirstName,
+		LastName:  payload.LastName,
+		Email:     strings.TrimSpace(payload.Email),
+	}))
+}
+
+// TerminalPaymentIntent creates a Stripe payment intent for an arbitrary
+// amount entered in the virtual terminal, in the store's currency.
+func (h *APIHandlers) TerminalPaymentIntent(w http.ResponseWriter, r *http.Request) {
+	var payload stripePayload
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeJSON(w, http.StatusBadRequest, jsonResponse{
+			OK:      false,
+			Message: "Invalid request body",
+		}, h.Logger(r))
+		return
+	}
+
+	if user, ok := middleware.UserFromContext(r.Context()); ok {
+		h.Logger(r).Info("virtual terminal charge requested", "user_id", user.ID)
+	}
+
+	h.createPaymentIntent(w, r, models.Currency, payload.Amount, nil)
+}
+
+// createPaymentIntent creates a payment intent and writes it as JSON.
+func (h *APIHandlers) createPaymentIntent(w http.ResponseWriter, r *http.Request, currency string, amount int64, metadata map[string]string) {
+	h.Logger(r).Info("creating payment intent", "currency", currency, "amount", amount)
+
+	pi, msg, err := h.App.Gateway.CreatePaymentIntent(currency, amount, metadata)
 	if err != nil {
-		h.App.ErrorLog.Printf("card.Charge failed: %v", err)
+		h.Logger(r).Error("creating payment intent failed", "error", err)
 
 		writeJSON(w, http.StatusInternalServerError, jsonResponse{
 			OK:      false,
 			Message: msg,
-		}, h.App.ErrorLog)
+		}, h.Logger(r))
+
+		return
+	}
 
+	writeJSON(w, http.StatusOK, pi, h.Logger(r))
+}
+
+// ConfirmFakePaymentIntent confirms a payment intent of the fake gateway with
+// the payment method in the request and returns it as JSON. It stands in for
+// the confirmation Stripe.js sends to Stripe, so decline cards fail here.
+func (h *APIHandlers) ConfirmFakePaymentIntent(w http.ResponseWriter, r *http.Request) {
+	var payload confirmPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeJSON(w, http.StatusBadRequest, jsonResponse{
+			OK:      false,
+			Message: "Invalid request body",
+		}, h.Logger(r))
+		return
+	}
+
+	pi, msg, err := h.App.FakeGateway.ConfirmPaymentIntent(chi.URLParam(r, "id"), payload.PaymentMethod)
+	if err != nil {
+		if cards.IsDeclined(err) {
+			writeJSON(w, http.StatusPaymentRequired, jsonResponse{
+				OK:      false,
+				Message: msg,
+			}, h.Logger(r))
+			return
+		}
+
+		h.Logger(r).Warn("confirming fake payment intent failed", "error", err)
+		writeJSON(w, http.StatusBadRequest, jsonResponse{
+			OK:      false,
+			Message: "Payment could not be confirmed",
+		}, h.Logger(r))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, pi, h.Logger(r))
+}
+
+// Authenticate checks an email and password and issues an API token.
+func (h *APIHandlers) Authenticate(w http.ResponseWriter, r *http.Request) {
+	var payload credentialsPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeJSON(w, http.StatusBadRequest, jsonResponse{
+			OK:      false,
+			Message: "Invalid request body",
+		}, h.Logger(r))
+		return
+	}
+
+	user, err := h.App.Services.AuthService.Authenticate(r.Context(), payload.Email, payload.Password)
+	if errors.Is(err, services.ErrInvalidCredentials) {
+		writeJSON(w, http.StatusUnauthorized, jsonResponse{
+			OK:      false,
+			Message: "Invalid email or password",
+		}, h.Logger(r))
+		return
+	}
+	if err != nil {
+		h.Logger(r).Error("authentication failed", "error", err)
+		writeJSON(w, http.StatusInternalServerError, jsonResponse{
+			OK:      false,
+			Message: "Error authenticating",
+		}, h.Logger(r))
 		return
 	}
 
-	writeJSON(w, http.StatusOK, pi, h.App.ErrorLog)
+	token, err := h.App.Services.AuthService.IssueToken(r.Context(), user.ID)
+	if err != nil {
+		h.Logger(r).Error("issuing token failed", "user_id", user.ID, "error", err)
+		writeJSON(w, http.StatusInternalServerError, jsonResponse{
+			OK:      false,
+			Message: "Error issuing token",
+		}, h.Logger(r))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, authResponse{
+		OK:      true,
+		Message: fmt.Sprintf("Token issued for %s", user.Email),
+		Token:   token,
+	}, h.Logger(r))
 }
 
-// GetWidgetByID fetches a widget by its ID and returns it as JSON.
+// GetWidgetByID fetches a widget by its ID and returns it as JSON. Archived
+// widgets are no longer sold and are not found.
 func (h *APIHandlers) GetWidgetByID(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	widgetID, err := strconv.Atoi(id)
 	if err != nil {
-		h.App.ErrorLog.Println(err)
+		h.Logger(r).Warn("invalid widget ID", "id", id, "error", err)
 		return
 	}
 
 	widget, err := h.App.Repositories.Widget.GetWidgetByID(r.Context(), widgetID)
+	if err == nil && widget.Archived {
+		err = sql.ErrNoRows
+	}
 	if err != nil {
-		h.App.ErrorLog.Println(err)
+		h.Logger(r).Warn("loading widget failed", "widget_id", widgetID, "error", err)
+		writeJSON(w, http.StatusNotFound, jsonResponse{
+			OK:      false,
+			Message: "Product not found",
+		}, h.Logger(r))
 		return
 	}
 
-	writeJSON(w, http.StatusOK, widget, h.App.ErrorLog)
+	writeJSON(w, http.StatusOK, widget, h.Logger(r))
 }
 
-// CreateSubscription creates a subscription for a product
+// CreateSubscription subscribes a customer to a recurring widget. The plan
+// comes from the widget, not from the request.
 func (h *APIHandlers) CreateSubscription(w http.ResponseWriter, r *http.Request) {
 	var payload stripePayload
 
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		h.App.ErrorLog.Println(err)
+		h.Logger(r).Warn("decoding subscription payload failed", "error", err)
 		return
 	}
 
-	h.App.InfoLog.Println(payload.Email, payload.LastFour, payload.PaymentMethod, payload.PlanID)
+	productID, err := strconv.Atoi(payload.ProductID)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, jsonResponse{
+			OK:      false,
+			Message: "Invalid product ID",
+		}, h.Logger(r))
+		return
+	}
 
-	card := cards.Card{
-		Secret:   h.App.Config.Stripe.Secret,
-		Key:      h.App.Config.Stripe.Key,
-		Currency: payload.Currency,
+	widget, err := h.App.Repositories.Widget.GetWidgetByID(r.Context(), productID)
+	if err == nil && (widget.Archived || !widget.IsRecurring || widget.PlanID == "") {
+		err = sql.ErrNoRows
+	}
+	if err != nil {
+		h.Logger(r).Warn("loading widget failed", "widget_id", productID, "error", err)
+		writeJSON(w, http.StatusNotFound, jsonResponse{
+			OK:      false,
+			Message: "Plan not found",
+		}, h.Logger(r))
+		return
 	}
 
-	var subscription *stripe.Subscription
+	h.Logger(r).Info("creating subscription", "email", payload.Email, "last_four", payload.LastFour, "payment_method", payload.PaymentMethod, "plan", widget.PlanID)
+
+	card := h.App.Gateway
 
-	stripeCustomer, msg, err := card.CreateCustomer(payload.PaymentMethod, payload.Email)
+	stripeCustomer, msg, err := h.App.Services.CustomerService.StripeCustomer(r.Context(), payload.Email, payload.PaymentMethod)
 	if err != nil {
+		h.Logger(r).Error("preparing Stripe customer failed", "error", err)
+		if msg == "" {
+			msg = "Error while saving the card"
+		}
 		writeJSON(w, http.StatusInternalServerError, jsonResponse{
 			OK:      false,
 			Message: msg,
-		}, h.App.ErrorLog)
+		}, h.Logger(r))
 		return
 	}
 
@@ -104,93 +290,635 @@ func (h *APIHandlers) CreateSubscription(w http.ResponseWriter, r *http.Request)
 		writeJSON(w, http.StatusInternalServerError, jsonResponse{
 			OK:      false,
 			Message: msg,
-		}, h.App.ErrorLog)
+		}, h.Logger(r))
 		return
 	}
 
-	subscription, err = card.SubscribeToPlan(stripeCustomer, payload.PlanID, payload.Email, payload.LastFour, "")
+	subscription, err := card.SubscribeToPlan(stripeCustomer.ID, widget.PlanID, payload.Email, payload.LastFour, "")
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, jsonResponse{
 			OK:      false,
 			Message: "Error while subscribing to plan",
-		}, h.App.ErrorLog)
+		}, h.Logger(r))
 		return
 	}
 
-	h.App.InfoLog.Println(subscription.ID)
+	h.Logger(r).Info("subscription created", "subscription", subscription.ID)
 
-	productID, err := strconv.Atoi(payload.ProductID)
+	txn := models.Transaction{
+		Amount:              widget.Price,
+		Currency:            models.Currency,
+		LastFour:            payload.LastFour,
+		ExpiryMonth:         payload.ExpiryMonth,
+		ExpiryYear:          payload.ExpiryYear,
+		TransactionStatusID: models.TransactionStatusCleared,
+		PaymentMethod:       payload.PaymentMethod,
+	}
+
+	if subscription.LatestPaymentIntent != nil {
+		txn.PaymentIntent = subscription.LatestPaymentIntent.ID
+	}
+
+	_, err = h.App.Services.CheckoutService.Checkout(r.Context(), services.Purchase{
+		Customer: models.Customer{
+			FirstName:        payload.FirstName,
+			LastName:         payload.LastName,
+			Email:            payload.Email,
+			StripeCustomerID: stripeCustomer.ID,
+		},
+		Transaction: txn,
+		Order: models.Order{
+			WidgetID: widget.ID,
+			StatusID: models.OrderStatusCleared,
+			Quantity: 1,
+			Amount:   widget.Price,
+		},
+		Subscription: subscription,
+		PaymentMethod: &models.PaymentMethod{
+			StripePaymentMethodID: payload.PaymentMethod,
+			Brand:                 payload.CardBrand,
+			LastFour:              payload.LastFour,
+			ExpiryMonth:           payload.ExpiryMonth,
+			ExpiryYear:            payload.ExpiryYear,
+		},
+	})
 	if err != nil {
+		h.Logger(r).Error("saving subscription checkout failed", "subscription", subscription.ID, "error", err)
+		h.cancelUnrecordedSubscription(r, subscription)
 		writeJSON(w, http.StatusInternalServerError, jsonResponse{
 			OK:      false,
-			Message: "Error converting product ID",
-		}, h.App.ErrorLog)
+			Message: "Error saving order",
+		}, h.Logger(r))
 		return
 	}
 
-	cust := models.Customer{
-		FirstName: payload.FirstName,
-		LastName:  payload.LastName,
-		Email:     payload.Email,
+	h.QueueMail(r, payload.Email, mailer.TemplateSubscriptionConfirmation, mailer.SubscriptionConfirmation{
+		FirstName:       payload.FirstName,
+		WidgetName:      widget.Name,
+		Amount:          widget.Price,
+		BillingInterval: widget.BillingInterval,
+		LastFour:        payload.LastFour,
+	})
+
+	writeJSON(w, http.StatusOK, jsonResponse{
+		OK:      true,
+		Message: "Transaction successful",
+		Content: sp.ClientSecret,
+	}, h.Logger(r))
+}
+
+// cancelUnrecordedSubscription cancels a subscription whose checkout could
+// not be saved, and refunds its first invoice, so the customer is not billed
+// for a plan the store has no record of.
+func (h *APIHandlers) cancelUnrecordedSubscription(r *http.Request, sub *cards.Subscription) {
+	if _, err := h.App.Gateway.CancelSubscription(sub.ID, false); err != nil {
+		h.Logger(r).Error("canceling unrecorded subscription failed", "subscription", sub.ID, "error", err)
+	}
+
+	if sub.LatestPaymentIntent == nil || sub.LatestPaymentIntent.Status != cards.PaymentIntentSucceeded {
+		return
+	}
+
+	pi := sub.LatestPaymentIntent.ID
+	if _, _, err := h.App.Gateway.Refund(pi, 0); err != nil {
+		h.Logger(r).Error("refunding unrecorded subscription failed", "subscription", sub.ID, "payment_intent", pi, "error", err)
+	}
+}
+
+// StripeWebhook verifies a Stripe webhook delivery and applies it to transactions and orders.
+func (h *APIHandlers) StripeWebhook(w http.ResponseWriter, r *http.Request) {
+	const maxBodyBytes = int64(65536)
+
+	if h.App.Config.Stripe.WebhookSecret == "" {
+		h.Logger(r).Error("webhook rejected: STRIPE_WEBHOOK_SECRET is not set")
+		writeJSON(w, http.StatusServiceUnavailable, jsonResponse{
+			OK:      false,
+			Message: "Webhooks are not configured",
+		}, h.Logger(r))
+		return
 	}
 
-	customerID, err := h.App.Services.CustomerService.SaveCustomer(r.Context(), cust)
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	payload, err := io.ReadAll(r.Body)
 	if err != nil {
+		h.Logger(r).Warn("reading webhook body failed", "error", err)
+		writeJSON(w, http.StatusRequestEntityTooLarge, jsonResponse{
+			OK:      false,
+			Message: "Error reading request body",
+		}, h.Logger(r))
+		return
+	}
+
+	event, err := webhook.ConstructEventWithOptions(
+		payload,
+		r.Header.Get("Stripe-Signature"),
+		h.App.Config.Stripe.WebhookSecret,
+		webhook.ConstructEventOptions{IgnoreAPIVersionMismatch: true},
+	)
+	if err != nil {
+		h.Logger(r).Warn("webhook signature verification failed", "error", err)
+		writeJSON(w, http.StatusBadRequest, jsonResponse{
+			OK:      false,
+			Message: "Invalid signature",
+		}, h.Logger(r))
+		return
+	}
+
+	processed, err := h.App.Services.WebhookService.HandleEvent(r.Context(), event)
+	if err != nil {
+		h.Logger(r).Error("processing webhook failed", "event", event.ID, "type", event.Type, "error", err)
 		writeJSON(w, http.StatusInternalServerError, jsonResponse{
 			OK:      false,
-			Message: "Error saving customer",
-		}, h.App.ErrorLog)
+			Message: "Error processing event",
+		}, h.Logger(r))
 		return
 	}
 
-	widget, err := h.App.Repositories.Widget.GetWidgetByID(r.Context(), productID)
+	if !processed {
+		h.Logger(r).Info("webhook already processed", "event", event.ID, "type", event.Type)
+		writeJSON(w, http.StatusOK, jsonResponse{
+			OK:      true,
+			Message: "Event already processed",
+		}, h.Logger(r))
+		return
+	}
+
+	h.Logger(r).Info("webhook processed", "event", event.ID, "type", event.Type)
+	writeJSON(w, http.StatusOK, jsonResponse{
+		OK:      true,
+		Message: "Event processed",
+	}, h.Logger(r))
+}
+
+// RefundOrder refunds an order in full or in part and returns the refunded amount.
+func (h *APIHandlers) RefundOrder(w http.ResponseWriter, r *http.Request) {
+	orderID, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
+		writeJSON(w, http.StatusBadRequest, jsonResponse{
+			OK:      false,
+			Message: "Invalid order ID",
+		}, h.Logger(r))
+		return
+	}
+
+	var payload refundPayload
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			writeJSON(w, http.StatusBadRequest, jsonResponse{
+				OK:      false,
+				Message: "Invalid request body",
+			}, h.Logger(r))
+			return
+		}
+	}
+
+	refunded, msg, err := h.App.Services.OrderService.RefundOrder(r.Context(), orderID, payload.Amount)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeJSON(w, http.StatusNotFound, jsonResponse{
+			OK:      false,
+			Message: "Order not found",
+		}, h.Logger(r))
+		return
+	case errors.Is(err, services.ErrNotRefundable), errors.Is(err, services.ErrRefundTooLarge):
+		writeJSON(w, http.StatusBadRequest, jsonResponse{
+			OK:      false,
+			Message: err.Error(),
+		}, h.Logger(r))
+		return
+	case err != nil:
+		h.Logger(r).Error("refunding order failed", "order_id", orderID, "error", err)
+		if msg == "" {
+			msg = "Error refunding order"
+		}
 		writeJSON(w, http.StatusInternalServerError, jsonResponse{
 			OK:      false,
-			Message: "Error getting product info",
-		}, h.App.ErrorLog)
+			Message: msg,
+		}, h.Logger(r))
 		return
 	}
 
-	txn := models.Transaction{
-		Amount:              widget.Price,
-		Currency:            payload.Currency,
-		LastFour:            payload.LastFour,
-		ExpiryMonth:         payload.ExpiryMonth,
-		ExpiryYear:          payload.ExpiryYear,
-		TransactionStatusID: 2,
+	h.Logger(r).Info("order refunded", "order_id", orderID, "amount", refunded)
+	writeJSON(w, http.StatusOK, jsonResponse{
+		OK:      true,
+		Message: "Refund successful",
+		ID:      orderID,
+	}, h.Logger(r))
+}
+
+// CancelSubscription cancels a subscription immediately or at the end of the current period.
+func (h *APIHandlers) CancelSubscription(w http.ResponseWriter, r *http.Request) {
+	var payload cancelSubscriptionPayload
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			writeJSON(w, http.StatusBadRequest, jsonResponse{
+				OK:      false,
+				Message: "Invalid request body",
+			}, h.Logger(r))
+			return
+		}
 	}
 
-	txnID, err := h.App.Services.TransactionService.SaveTransaction(r.Context(), txn)
+	h.updateSubscription(w, r, func(ctx context.Context, id int) (models.Subscription, error) {
+		return h.App.Services.SubscriptionService.Cancel(ctx, id, payload.AtPeriodEnd)
+	})
+}
+
+// PauseSubscription pauses payment collection on a subscription.
+func (h *APIHandlers) PauseSubscription(w http.ResponseWriter, r *http.Request) {
+	h.updateSubscription(w, r, h.App.Services.SubscriptionService.Pause)
+}
+
+// ResumeSubscription resumes payment collection on a paused subscription.
+func (h *APIHandlers) ResumeSubscription(w http.ResponseWriter, r *http.Request) {
+	h.updateSubscription(w, r, h.App.Services.SubscriptionService.Resume)
+}
+
+// ChangeSubscriptionPlan switches a subscription to another plan widget.
+func (h *APIHandlers) ChangeSubscriptionPlan(w http.ResponseWriter, r *http.Request) {
+	var payload changePlanPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.WidgetID == 0 {
+		writeJSON(w, http.StatusBadRequest, jsonResponse{
+			OK:      false,
+			Message: "A widget_id is required",
+		}, h.Logger(r))
+		return
+	}
+
+	h.updateSubscription(w, r, func(ctx context.Context, id int) (models.Subscription, error) {
+		return h.App.Services.SubscriptionService.ChangePlan(ctx, id, payload.WidgetID)
+	})
+}
+
+// updateSubscription runs a subscription change for the {id} URL parameter and writes the result.
+func (h *APIHandlers) updateSubscription(w http.ResponseWriter, r *http.Request, change func(ctx context.Context, id int) (models.Subscription, error)) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
+		writeJSON(w, http.StatusBadRequest, jsonResponse{
+			OK:      false,
+			Message: "Invalid subscription ID",
+		}, h.Logger(r))
+		return
+	}
+
+	sub, err := change(r.Context(), id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeJSON(w, http.StatusNotFound, jsonResponse{
+			OK:      false,
+			Message: "Subscription or widget not found",
+		}, h.Logger(r))
+		return
+	case errors.Is(err, services.ErrSubscriptionEnded), errors.Is(err, services.ErrNotAPlan),
+		errors.Is(err, services.ErrPlanArchived):
+		writeJSON(w, http.StatusBadRequest, jsonResponse{
+			OK:      false,
+			Message: err.Error(),
+		}, h.Logger(r))
+		return
+	case err != nil:
+		h.Logger(r).Error("updating subscription failed", "subscription_id", id, "error", err)
 		writeJSON(w, http.StatusInternalServerError, jsonResponse{
 			OK:      false,
-			Message: "Error saving transaction",
-		}, h.App.ErrorLog)
+			Message: "Error updating subscription",
+		}, h.Logger(r))
 		return
 	}
 
-	order := models.Order{
-		WidgetID:      widget.ID,
-		TransactionID: txnID,
-		CustomerID:    customerID,
-		StatusID:      1,
-		Quantity:      1,
-		Amount:        widget.Price,
+	writeJSON(w, http.StatusOK, sub, h.Logger(r))
+}
+
+// ListWidgets returns a page of the widget catalog. It accepts the page,
+// page_size, recurring and archived query parameters.
+func (h *APIHandlers) ListWidgets(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter := repository.WidgetFilter{
+		Page:            pageFromQuery(r),
+		IncludeArchived: query.Get("archived") == "true",
+	}
+
+	if v := query.Get("recurring"); v != "" {
+		recurring, err := strconv.ParseBool(v)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, jsonResponse{
+				OK:      false,
+				Message: "recurring must be true or false",
+			}, h.Logger(r))
+			return
+		}
+		filter.Recurring = &recurring
 	}
 
-	_, err = h.App.Services.OrderService.PlaceOrder(r.Context(), order)
+	widgets, total, err := h.App.Services.WidgetService.ListWidgets(r.Context(), filter)
 	if err != nil {
+		h.Logger(r).Error("listing widgets failed", "error", err)
 		writeJSON(w, http.StatusInternalServerError, jsonResponse{
 			OK:      false,
-			Message: "Error saving order",
-		}, h.App.ErrorLog)
+			Message: "Error listing widgets",
+		}, h.Logger(r))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, listResponse{
+		Items:    widgets,
+		Total:    total,
+		Page:     filter.Page.Page,
+		PageSize: filter.Page.PageSize,
+	}, h.Logger(r))
+}
+
+// CreateWidget adds a widget to the catalog and returns its ID.
+func (h *APIHandlers) CreateWidget(w http.ResponseWriter, r *http.Request) {
+	var payload widgetPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeJSON(w, http.StatusBadRequest, jsonResponse{
+			OK:      false,
+			Message: "Invalid request body",
+		}, h.Logger(r))
+		return
+	}
+
+	id, err := h.App.Services.WidgetService.CreateWidget(r.Context(), payload.widget())
+	if err != nil {
+		h.writeWidgetError(w, r, err, "Error creating widget")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, jsonResponse{
+		OK:      true,
+		Message: "Widget created",
+		ID:      id,
+	}, h.Logger(r))
+}
+
+// UpdateWidget replaces the editable fields of a widget, adds the inventory
+// adjustment to its stock and returns it.
+func (h *APIHandlers) UpdateWidget(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, jsonResponse{
+			OK:      false,
+			Message: "Invalid widget ID",
+		}, h.Logger(r))
+		return
+	}
+
+	var payload widgetPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeJSON(w, http.StatusBadRequest, jsonResponse{
+			OK:      false,
+			Message: "Invalid request body",
+		}, h.Logger(r))
+		return
+	}
+
+	widget := payload.widget()
+	widget.ID = id
+
+	widget, err = h.App.Services.WidgetService.UpdateWidget(r.Context(), widget, payload.InventoryAdjustment)
+	if err != nil {
+		h.writeWidgetError(w, r, err, "Error updating widget")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, widget, h.Logger(r))
+}
+
+// ArchiveWidget removes a widget from the catalog without deleting its orders.
+func (h *APIHandlers) ArchiveWidget(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, jsonResponse{
+			OK:      false,
+			Message: "Invalid widget ID",
+		}, h.Logger(r))
+		return
+	}
+
+	if err := h.App.Services.WidgetService.ArchiveWidget(r.Context(), id); err != nil {
+		h.writeWidgetError(w, r, err, "Error archiving widget")
 		return
 	}
 
 	writeJSON(w, http.StatusOK, jsonResponse{
 		OK:      true,
-		Message: "Transaction successful",
-		Content: sp.ClientSecret,
-	}, h.App.InfoLog)
+		Message: "Widget archived",
+		ID:      id,
+	}, h.Logger(r))
+}
+
+// writeWidgetError maps widget service errors to a JSON response.
+func (h *APIHandlers) writeWidgetError(w http.ResponseWriter, r *http.Request, err error, fallback string) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeJSON(w, http.StatusNotFound, jsonResponse{
+			OK:      false,
+			Message: "Widget not found",
+		}, h.Logger(r))
+	case errors.Is(err, services.ErrInvalidWidget):
+		writeJSON(w, http.StatusBadRequest, jsonResponse{
+			OK:      false,
+			Message: err.Error(),
+		}, h.Logger(r))
+	default:
+		h.Logger(r).Error("changing widget failed", "error", err)
+		writeJSON(w, http.StatusInternalServerError, jsonResponse{
+			OK:      false,
+			Message: fallback,
+		}, h.Logger(r))
+	}
+}
+
+// pageFromQuery reads the page and page_size query parameters, falling back to defaults.
+func pageFromQuery(r *http.Request) repository.Page {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	size, _ := strconv.Atoi(r.URL.Query().Get("page_size"))
+
+	return repository.Page{Page: page, PageSize: size}.Normalize()
+}
+
+// OrderInvoice renders the invoice of an order as a PDF download.
+func (h *APIHandlers) OrderInvoice(w http.ResponseWriter, r *http.Request) {
+	orderID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, jsonResponse{
+			OK:      false,
+			Message: "Invalid order ID",
+		}, h.Logger(r))
+		return
+	}
+
+	inv, err := h.App.Services.OrderService.Invoice(r.Context(), orderID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusNotFound, jsonResponse{
+			OK:      false,
+			Message: "Order not found",
+		}, h.Logger(r))
+		return
+	}
+	if err != nil {
+		h.Logger(r).Error("loading invoice failed", "order_id", orderID, "error", err)
+		writeJSON(w, http.StatusInternalServerError, jsonResponse{
+			OK:      false,
+			Message: "Error generating invoice",
+		}, h.Logger(r))
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := invoice.Render(&buf, inv); err != nil {
+		h.Logger(r).Error("rendering invoice failed", "order_id", orderID, "error", err)
+		writeJSON(w, http.StatusInternalServerError, jsonResponse{
+			OK:      false,
+			Message: "Error generating invoice",
+		}, h.Logger(r))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pdf"`, inv.Number()))
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	if _, err := buf.WriteTo(w); err != nil {
+		h.Logger(r).Error("writing invoice failed", "order_id", orderID, "error", err)
+	}
+}
+
+// ListOrders returns a page of orders. It accepts the page, page_size, from,
+// to, status_id, email and widget_id query parameters.
+func (h *APIHandlers) ListOrders(w http.ResponseWriter, r *http.Request) {
+	q, err := parseListQuery(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, jsonResponse{
+			OK:      false,
+			Message: err.Error(),
+		}, h.Logger(r))
+		return
+	}
+
+	filter := repository.OrderFilter{
+		Page:          q.page,
+		From:          q.from,
+		To:            q.to,
+		StatusID:      q.statusID,
+		CustomerEmail: q.email,
+		WidgetID:      q.widgetID,
+	}
+
+	orders, total, err := h.App.Services.OrderService.ListOrders(r.Context(), filter)
+	if err != nil {
+		h.Logger(r).Error("listing orders failed", "error", err)
+		writeJSON(w, http.StatusInternalServerError, jsonResponse{
+			OK:      false,
+			Message: "Error listing orders",
+		}, h.Logger(r))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, listResponse{
+		Items:    orders,
+		Total:    total,
+		Page:     filter.Page.Page,
+		PageSize: filter.Page.PageSize,
+	}, h.Logger(r))
+}
+
+// ListTransactions returns a page of transactions. It accepts the same query
+// parameters as ListOrders, with status_id being a transaction status.
+func (h *APIHandlers) ListTransactions(w http.ResponseWriter, r *http.Request) {
+	q, err := parseListQuery(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, jsonResponse{
+			OK:      false,
+			Message: err.Error(),
+		}, h.Logger(r))
+		return
+	}
+
+	filter := repository.TransactionFilter{
+		Page:          q.page,
+		From:          q.from,
+		To:            q.to,
+		StatusID:      q.statusID,
+		CustomerEmail: q.email,
+		WidgetID:      q.widgetID,
+	}
+
+	txns, total, err := h.App.Services.TransactionService.ListTransactions(r.Context(), filter)
+	if err != nil {
+		h.Logger(r).Error("listing transactions failed", "error", err)
+		writeJSON(w, http.StatusInternalServerError, jsonResponse{
+			OK:      false,
+			Message: "Error listing transactions",
+		}, h.Logger(r))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, listResponse{
+		Items:    txns,
+		Total:    total,
+		Page:     filter.Page.Page,
+		PageSize: filter.Page.PageSize,
+	}, h.Logger(r))
+}
+
+// listQuery holds the filters shared by the order and transaction listings.
+type listQuery struct {
+	page     repository.Page
+	from     time.Time
+	to       time.Time
+	statusID int
+	email    string
+	widgetID int
+}
+
+// parseListQuery reads listing filters from the query string. Dates are
+// either YYYY-MM-DD or RFC 3339; a plain "to" date includes that whole day.
+func parseListQuery(r *http.Request) (listQuery, error) {
+	query := r.URL.Query()
+	q := listQuery{
+		page:  pageFromQuery(r),
+		email: strings.TrimSpace(query.Get("email")),
+	}
+
+	var err error
+	if q.from, _, err = parseQueryTime(query.Get("from")); err != nil {
+		return q, errors.New("from must be a date (YYYY-MM-DD) or RFC 3339 time")
+	}
+
+	var dateOnly bool
+	if q.to, dateOnly, err = parseQueryTime(query.Get("to")); err != nil {
+		return q, errors.New("to must be a date (YYYY-MM-DD) or RFC 3339 time")
+	}
+	if dateOnly {
+		q.to = q.to.AddDate(0, 0, 1)
+	}
+
+	if v := query.Get("status_id"); v != "" {
+		if q.statusID, err = strconv.Atoi(v); err != nil {
+			return q, errors.New("status_id must be a number")
+		}
+	}
+
+	if v := query.Get("widget_id"); v != "" {
+		if q.widgetID, err = strconv.Atoi(v); err != nil {
+			return q, errors.New("widget_id must be a number")
+		}
+	}
+
+	return q, nil
+}
+
+// parseQueryTime parses a date or RFC 3339 time and reports whether it was a plain date.
+func parseQueryTime(v string) (time.Time, bool, error) {
+	if v == "" {
+		return time.Time{}, false, nil
+	}
+
+	if t, err := time.ParseInLocation(time.DateOnly, v, time.Local); err == nil {
+		return t, true, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	return t, false, err
 }
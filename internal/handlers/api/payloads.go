@@ -1,8 +1,9 @@
 package api
 
+import "github.com/mlvieira/store/internal/models"
+
 // stripePayload represents a payment intent request payload.
 type stripePayload struct {
-	Currency      string `json:"currency"`
 	Amount        int64  `json:"amount"`
 	PaymentMethod string `json:"payment_method"`
 	Email         string `json:"email"`
@@ -15,3 +16,60 @@ type stripePayload struct {
 	FirstName     string `json:"first_name"`
 	LastName      string `json:"last_name"`
 }
+
+// confirmPayload represents a payment intent confirmation request payload.
+type confirmPayload struct {
+	PaymentMethod string `json:"payment_method"`
+}
+
+// credentialsPayload represents an authentication request payload.
+type credentialsPayload struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// refundPayload represents a refund request payload. A zero amount refunds the full balance.
+type refundPayload struct {
+	Amount int64 `json:"amount"`
+}
+
+// cancelSubscriptionPayload represents a subscription cancellation request payload.
+type cancelSubscriptionPayload struct {
+	AtPeriodEnd bool `json:"at_period_end"`
+}
+
+// changePlanPayload represents a plan change request payload.
+type changePlanPayload struct {
+	WidgetID int `json:"widget_id"`
+}
+
+// widgetPayload represents a widget create or update request payload. The
+// inventory level sets the stock of a new widget; updates change it by the
+// inventory adjustment instead.
+type widgetPayload struct {
+	Name                string `json:"name"`
+	Description         string `json:"description"`
+	InventoryLevel      int    `json:"inventory_level"`
+	InventoryAdjustment int    `json:"inventory_adjustment"`
+	Price               int64  `json:"price"`
+	Image               string `json:"image"`
+	IsRecurring         bool   `json:"is_recurring"`
+	PlanID              string `json:"plan_id"`
+	BillingInterval     string `json:"billing_interval"`
+	Slug                string `json:"slug"`
+}
+
+// widget converts the payload into a widget model.
+func (p widgetPayload) widget() models.Widget {
+	return models.Widget{
+		Name:            p.Name,
+		Description:     p.Description,
+		InventoryLevel:  p.InventoryLevel,
+		Price:           p.Price,
+		Image:           p.Image,
+		IsRecurring:     p.IsRecurring,
+		PlanID:          p.PlanID,
+		BillingInterval: p.BillingInterval,
+		Slug:            p.Slug,
+	}
+}
@@ -2,8 +2,10 @@ package api
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
+
+	"github.com/mlvieira/store/internal/models"
 )
 
 // jsonResponse represents a standardized JSON response.
@@ -14,12 +16,27 @@ type jsonResponse struct {
 	ID      int    `json:"id,omitempty"`
 }
 
+// authResponse represents a successful authentication response.
+type authResponse struct {
+	OK      bool         `json:"ok"`
+	Message string       `json:"message,omitempty"`
+	Token   models.Token `json:"authentication_token"`
+}
+
+// listResponse represents one page of a listing.
+type listResponse struct {
+	Items    any `json:"items"`
+	Total    int `json:"total"`
+	Page     int `json:"page"`
+	PageSize int `json:"page_size"`
+}
+
 // writeJSON writes a JSON response to the HTTP response writer.
-func writeJSON(w http.ResponseWriter, status int, data interface{}, logger *log.Logger) {
+func writeJSON(w http.ResponseWriter, status int, data interface{}, logger *slog.Logger) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 
 	if err := json.NewEncoder(w).Encode(data); err != nil {
-		logger.Println("Failed to write JSON response:", err)
+		logger.Error("writing JSON response failed", "error", err)
 	}
 }
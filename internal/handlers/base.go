@@ -1,6 +1,12 @@
 package handlers
 
-import "github.com/mlvieira/store/internal/application"
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/mlvieira/store/internal/application"
+	"github.com/mlvieira/store/internal/logging"
+)
 
 // Handlers provides methods to handle web and API requests.
 type Handlers struct {
@@ -11,3 +17,16 @@ type Handlers struct {
 func NewHandlers(app *application.Application) *Handlers {
 	return &Handlers{App: app}
 }
+
+// Logger returns the application logger tagged with the ID of the request.
+func (h *Handlers) Logger(r *http.Request) *slog.Logger {
+	return logging.FromContext(r.Context(), h.App.Logger)
+}
+
+// QueueMail hands a templated email to the mailer's worker pool. Failures
+// are logged rather than returned so the request is never held up by mail.
+func (h *Handlers) QueueMail(r *http.Request, to, template string, data any) {
+	if err := h.App.Mailer.Enqueue(to, template, data); err != nil {
+		h.Logger(r).Error("queueing mail failed", "template", template, "to", to, "error", err)
+	}
+}
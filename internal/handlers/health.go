@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// readinessTimeout bounds the checks behind /readyz so a hung database does
+// not hold the load balancer's probe.
+const readinessTimeout = 2 * time.Second
+
+// buildInfo is the body of the /version response.
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+}
+
+// Healthz reports that the process is alive and serving requests.
+func (h *Handlers) Healthz(w http.ResponseWriter, r *http.Request) {
+	h.writeJSON(w, r, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readinessCheck is one of the checks behind /readyz.
+type readinessCheck struct {
+	name  string
+	check func() error
+}
+
+// Readyz reports whether the server can take traffic: the database answers
+// a ping, the templates parse and the payment gateway is configured. The
+// endpoint is public, so failures are only logged and the body just says
+// "unavailable".
+func (h *Handlers) Readyz(w http.ResponseWriter, r *http.Request) {
+	h.ready(w, r)
+}
+
+// APIReadyz is Readyz for the API server, which also needs the secret to
+// verify the gateway's webhooks.
+func (h *Handlers) APIReadyz(w http.ResponseWriter, r *http.Request) {
+	h.ready(w, r, readinessCheck{"webhooks", h.App.Config.CheckWebhooks})
+}
+
+// ready runs the checks of Readyz followed by extra and writes the result.
+func (h *Handlers) ready(w http.ResponseWriter, r *http.Request, extra ...readinessCheck) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := append([]readinessCheck{
+		{"database", func() error { return h.App.DB.PingContext(ctx) }},
+		{"templates", h.App.Renderer.CheckTemplates},
+		{"gateway", h.App.Config.CheckGateway},
+	}, extra...)
+
+	status, body := http.StatusOK, "ok"
+
+	for _, c := range checks {
+		if err := c.check(); err != nil {
+			h.Logger(r).Error("readiness check failed", "check", c.name, "error", err)
+			status, body = http.StatusServiceUnavailable, "unavailable"
+		}
+	}
+
+	h.writeJSON(w, r, status, map[string]string{"status": body})
+}
+
+// Version reports the version, commit and build time of the binary.
+func (h *Handlers) Version(w http.ResponseWriter, r *http.Request) {
+	h.writeJSON(w, r, http.StatusOK, buildInfo{
+		Version:   h.App.Version,
+		Commit:    h.App.Commit,
+		BuildTime: h.App.BuildTime,
+	})
+}
+
+// writeJSON writes data as a JSON response with the given status.
+func (h *Handlers) writeJSON(w http.ResponseWriter, r *http.Request, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		h.Logger(r).Error("writing JSON response failed", "error", err)
+	}
+}
@@ -0,0 +1,282 @@
+package web
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mlvieira/store/internal/invoice"
+	"github.com/mlvieira/store/internal/models"
+	"github.com/mlvieira/store/internal/render"
+	"github.com/mlvieira/store/internal/repository"
+	"github.com/mlvieira/store/internal/services"
+	"github.com/mlvieira/store/internal/urlsigner"
+)
+
+// AccountSignIn renders the page where customers ask for a sign-in link.
+func (h *WebHandlers) AccountSignIn(w http.ResponseWriter, r *http.Request) {
+	if err := h.App.Renderer.RenderTemplate(w, r, "account-sign-in", nil); err != nil {
+		h.Logger(r).Error("rendering page failed", "page", "account-sign-in", "error", err)
+	}
+}
+
+// PostAccountSignIn emails a sign-in link. The response is the same whether
+// or not the email belongs to a customer.
+func (h *WebHandlers) PostAccountSignIn(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.Logger(r).Warn("parsing form failed", "error", err)
+		return
+	}
+
+	if err := h.App.Services.AccountService.SendSignInLink(r.Context(), r.Form.Get("email")); err != nil {
+		h.Logger(r).Error("sending sign-in link failed", "error", err)
+		h.App.Session.Put(r.Context(), "error", "We could not send the sign-in link. Please try again.")
+		http.Redirect(w, r, "/account/sign-in", http.StatusSeeOther)
+		return
+	}
+
+	h.App.Session.Put(r.Context(), "flash", "If we have orders for that email, a sign-in link is on its way.")
+	http.Redirect(w, r, "/account/sign-in", http.StatusSeeOther)
+}
+
+// AccountVerify signs a customer in from the link emailed by PostAccountSignIn.
+func (h *WebHandlers) AccountVerify(w http.ResponseWriter, r *http.Request) {
+	customerID, err := h.App.Services.AccountService.VerifySignInLink(r.URL.RequestURI())
+	if err != nil {
+		reason := "This sign-in link is not valid. It may have been copied incompletely."
+		if errors.Is(err, urlsigner.ErrExpired) {
+			reason = "This sign-in link has expired. Sign-in links are valid for 15 minutes."
+		}
+		h.renderLinkError(w, r, reason, "/account/sign-in")
+		return
+	}
+
+	if err := h.App.Session.RenewToken(r.Context()); err != nil {
+		h.Logger(r).Error("renewing session token failed", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	h.App.Session.Put(r.Context(), "customerID", customerID)
+
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// AccountSignOut ends the customer session.
+func (h *WebHandlers) AccountSignOut(w http.ResponseWriter, r *http.Request) {
+	h.App.Session.Remove(r.Context(), "customerID")
+	if err := h.App.Session.RenewToken(r.Context()); err != nil {
+		h.Logger(r).Error("renewing session token failed", "error", err)
+	}
+
+	h.App.Session.Put(r.Context(), "flash", "You have been signed out.")
+
+	http.Redirect(w, r, "/account/sign-in", http.StatusSeeOther)
+}
+
+// Account renders the customer's orders, subscriptions and saved cards.
+func (h *WebHandlers) Account(w http.ResponseWriter, r *http.Request) {
+	customer, ok := h.accountCustomer(w, r)
+	if !ok {
+		return
+	}
+
+	filter := repository.OrderFilter{
+		Page:       pageFromQuery(r),
+		CustomerID: customer.ID,
+	}
+
+	orders, total, err := h.App.Services.OrderService.ListOrders(r.Context(), filter)
+	if err != nil {
+		h.Logger(r).Error("listing orders failed", "customer_id", customer.ID, "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	subs, _, err := h.App.Services.SubscriptionService.ListSubscriptions(r.Context(), repository.SubscriptionFilter{
+		CustomerID: customer.ID,
+	})
+	if err != nil {
+		h.Logger(r).Error("listing subscriptions failed", "customer_id", customer.ID, "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	cards, err := h.App.Services.AccountService.SavedCards(r.Context(), customer)
+	if err != nil {
+		h.Logger(r).Error("listing saved cards failed", "customer_id", customer.ID, "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]any{
+		"customer":      customer,
+		"orders":        orders,
+		"pagination":    newPagination(r, filter.Page, total),
+		"subscriptions": subs,
+		"cards":         cards,
+	}
+
+	if err := h.App.Renderer.RenderTemplate(w, r, "account", &render.TemplateData{
+		Data: data,
+	}, "pagination"); err != nil {
+		h.Logger(r).Error("rendering page failed", "page", "account", "error", err)
+	}
+}
+
+// AccountInvoice sends the PDF receipt of one of the customer's orders.
+func (h *WebHandlers) AccountInvoice(w http.ResponseWriter, r *http.Request) {
+	customer, ok := h.accountCustomer(w, r)
+	if !ok {
+		return
+	}
+
+	orderID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	inv, err := h.App.Services.AccountService.Invoice(r.Context(), customer.ID, orderID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		h.Logger(r).Error("loading invoice failed", "order_id", orderID, "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := invoice.Render(&buf, inv); err != nil {
+		h.Logger(r).Error("rendering invoice failed", "order_id", orderID, "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pdf"`, inv.Number()))
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	if _, err := buf.WriteTo(w); err != nil {
+		h.Logger(r).Error("writing invoice failed", "order_id", orderID, "error", err)
+	}
+}
+
+// AccountCancelSubscription cancels one of the customer's subscriptions at
+// the end of the current period.
+func (h *WebHandlers) AccountCancelSubscription(w http.ResponseWriter, r *http.Request) {
+	customer, ok := h.accountCustomer(w, r)
+	if !ok {
+		return
+	}
+
+	subID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	sub, err := h.App.Services.AccountService.CancelSubscription(r.Context(), customer.ID, subID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.NotFound(w, r)
+		return
+	case errors.Is(err, services.ErrSubscriptionEnded):
+		h.App.Session.Put(r.Context(), "error", "This subscription has already ended.")
+	case err != nil:
+		h.Logger(r).Error("canceling subscription failed", "subscription_id", subID, "error", err)
+		h.App.Session.Put(r.Context(), "error", "Your subscription could not be canceled. Please try again.")
+	default:
+		h.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Your subscription will end on %s.", sub.CurrentPeriodEnd.Format("2006-01-02")))
+	}
+
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// AccountCard renders the form to replace the customer's saved card.
+func (h *WebHandlers) AccountCard(w http.ResponseWriter, r *http.Request) {
+	customer, ok := h.accountCustomer(w, r)
+	if !ok {
+		return
+	}
+
+	si, msg, err := h.App.Services.AccountService.NewCardSetup(customer)
+	if errors.Is(err, services.ErrNoStripeCustomer) {
+		h.App.Session.Put(r.Context(), "warning", "You have no saved card yet. One is saved when you subscribe to a plan.")
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		h.Logger(r).Error("starting card setup failed", "customer_id", customer.ID, "error", err)
+		if msg == "" {
+			msg = "We could not start the card update. Please try again."
+		}
+		h.App.Session.Put(r.Context(), "error", msg)
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+		return
+	}
+
+	stringMap := map[string]string{
+		"client_secret": si.ClientSecret,
+		"email":         customer.Email,
+	}
+
+	if err := h.App.Renderer.RenderTemplate(w, r, "account-card", &render.TemplateData{
+		StringMap: stringMap,
+	}); err != nil {
+		h.Logger(r).Error("rendering page failed", "page", "account-card", "error", err)
+	}
+}
+
+// PostAccountCard saves the card confirmed on the account card page as the
+// one the customer's subscriptions are billed to.
+func (h *WebHandlers) PostAccountCard(w http.ResponseWriter, r *http.Request) {
+	customer, ok := h.accountCustomer(w, r)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		h.Logger(r).Warn("parsing form failed", "error", err)
+		return
+	}
+
+	msg, err := h.App.Services.AccountService.UpdateCard(r.Context(), customer, r.Form.Get("payment_method"))
+	switch {
+	case errors.Is(err, services.ErrNoStripeCustomer):
+		h.App.Session.Put(r.Context(), "warning", "You have no saved card yet. One is saved when you subscribe to a plan.")
+	case err != nil:
+		h.Logger(r).Error("updating card failed", "customer_id", customer.ID, "error", err)
+		if msg == "" {
+			msg = "Your card could not be saved. Please try again."
+		}
+		h.App.Session.Put(r.Context(), "error", msg)
+	default:
+		h.App.Session.Put(r.Context(), "flash", "Your card was updated.")
+	}
+
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// accountCustomer loads the signed-in customer. When the customer no longer
+// exists it ends the session, redirects to the sign-in page and returns false.
+func (h *WebHandlers) accountCustomer(w http.ResponseWriter, r *http.Request) (models.Customer, bool) {
+	customer, err := h.App.Services.CustomerService.GetCustomer(r.Context(), h.App.Session.GetInt(r.Context(), "customerID"))
+	if errors.Is(err, sql.ErrNoRows) {
+		h.App.Session.Remove(r.Context(), "customerID")
+		http.Redirect(w, r, "/account/sign-in", http.StatusSeeOther)
+		return customer, false
+	}
+	if err != nil {
+		h.Logger(r).Error("loading customer failed", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return customer, false
+	}
+
+	return customer, true
+}
@@ -0,0 +1,595 @@
+package web
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mlvieira/store/internal/cards"
+	"github.com/mlvieira/store/internal/models"
+	"github.com/mlvieira/store/internal/render"
+	"github.com/mlvieira/store/internal/repository"
+	"github.com/mlvieira/store/internal/services"
+)
+
+// adminRecentOrders is the number of orders listed on the admin dashboard.
+const adminRecentOrders = 10
+
+// pagination describes the page links under an admin table.
+type pagination struct {
+	Page  int
+	Pages int
+	Total int
+	Prev  string
+	Next  string
+}
+
+// newPagination builds the page links for the current request, keeping its
+// other query parameters.
+func newPagination(r *http.Request, page repository.Page, total int) pagination {
+	p := pagination{
+		Page:  page.Page,
+		Pages: (total + page.PageSize - 1) / page.PageSize,
+		Total: total,
+	}
+
+	link := func(n int) string {
+		query := r.URL.Query()
+		query.Set("page", strconv.Itoa(n))
+		return r.URL.Path + "?" + query.Encode()
+	}
+
+	if p.Page > 1 {
+		p.Prev = link(p.Page - 1)
+	}
+	if p.Page < p.Pages {
+		p.Next = link(p.Page + 1)
+	}
+
+	return p
+}
+
+// pageFromQuery reads the page query parameter of a paginated table.
+func pageFromQuery(r *http.Request) repository.Page {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	return repository.Page{Page: page}.Normalize()
+}
+
+// parseCents parses an amount typed in currency units, like "12.50", into cents.
+func parseCents(v string) (int64, error) {
+	f, err := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(v), ",", "."), 64)
+	if err != nil || f < 0 || math.IsInf(f, 0) || math.IsNaN(f) {
+		return 0, fmt.Errorf("invalid amount %q", v)
+	}
+	return int64(math.Round(f * 100)), nil
+}
+
+// AdminDashboard renders the back office home with the sales of the last
+// day and month and the most recent orders.
+func (h *WebHandlers) AdminDashboard(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	data := map[string]any{}
+
+	for key, since := range map[string]time.Time{
+		"day":   now.AddDate(0, 0, -1),
+		"month": now.AddDate(0, -1, 0),
+	} {
+		count, revenue, err := h.App.Services.OrderService.SalesSince(r.Context(), since)
+		if err != nil {
+			h.Logger(r).Error("loading sales failed", "error", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		data[key+"_orders"] = count
+		data[key+"_revenue"] = revenue
+	}
+
+	orders, _, err := h.App.Services.OrderService.ListOrders(r.Context(), repository.OrderFilter{
+		Page: repository.Page{PageSize: adminRecentOrders},
+	})
+	if err != nil {
+		h.Logger(r).Error("listing orders failed", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	data["orders"] = orders
+
+	if err := h.App.Renderer.RenderTemplate(w, r, "admin-dashboard", &render.TemplateData{
+		Data: data,
+	}); err != nil {
+		h.Logger(r).Error("rendering page failed", "page", "admin-dashboard", "error", err)
+	}
+}
+
+// AdminOrders renders a page of orders, optionally filtered by status and
+// customer email.
+func (h *WebHandlers) AdminOrders(w http.ResponseWriter, r *http.Request) {
+	filter := repository.OrderFilter{
+		Page:          pageFromQuery(r),
+		CustomerEmail: strings.TrimSpace(r.URL.Query().Get("email")),
+	}
+	filter.StatusID, _ = strconv.Atoi(r.URL.Query().Get("status_id"))
+
+	orders, total, err := h.App.Services.OrderService.ListOrders(r.Context(), filter)
+	if err != nil {
+		h.Logger(r).Error("listing orders failed", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]any{
+		"orders":     orders,
+		"pagination": newPagination(r, filter.Page, total),
+	}
+
+	stringMap := map[string]string{
+		"email":     filter.CustomerEmail,
+		"status_id": r.URL.Query().Get("status_id"),
+	}
+
+	if err := h.App.Renderer.RenderTemplate(w, r, "admin-orders", &render.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	}, "pagination"); err != nil {
+		h.Logger(r).Error("rendering page failed", "page", "admin-orders", "error", err)
+	}
+}
+
+// AdminOrder renders the details of an order with its refund form.
+func (h *WebHandlers) AdminOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	order, err := h.App.Services.OrderService.GetOrder(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		h.Logger(r).Error("loading order failed", "order_id", id, "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]any{
+		"order":      order,
+		"refundable": order.StatusID == models.OrderStatusCleared && order.Transaction.PaymentIntent != "" && order.Transaction.RefundedAmount < order.Transaction.Amount,
+		"remaining":  order.Transaction.Amount - order.Transaction.RefundedAmount,
+	}
+
+	if err := h.App.Renderer.RenderTemplate(w, r, "admin-order", &render.TemplateData{
+		Data: data,
+	}); err != nil {
+		h.Logger(r).Error("rendering page failed", "page", "admin-order", "error", err)
+	}
+}
+
+// AdminRefundOrder refunds an order from its detail page. An empty amount
+// refunds whatever is left of the payment.
+func (h *WebHandlers) AdminRefundOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		h.Logger(r).Warn("parsing form failed", "error", err)
+		return
+	}
+
+	back := fmt.Sprintf("/admin/orders/%d", id)
+
+	var amount int64
+	if v := r.Form.Get("amount"); strings.TrimSpace(v) != "" {
+		if amount, err = parseCents(v); err != nil || amount == 0 {
+			h.App.Session.Put(r.Context(), "error", "Enter the amount to refund, like 12.50, or leave it empty to refund everything.")
+			http.Redirect(w, r, back, http.StatusSeeOther)
+			return
+		}
+	}
+
+	refunded, msg, err := h.App.Services.OrderService.RefundOrder(r.Context(), id, amount)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.NotFound(w, r)
+		return
+	case errors.Is(err, services.ErrNotRefundable):
+		h.App.Session.Put(r.Context(), "error", "This order has nothing left to refund.")
+	case errors.Is(err, services.ErrRefundTooLarge):
+		h.App.Session.Put(r.Context(), "error", "The refund is larger than what is left of the payment.")
+	case err != nil:
+		h.Logger(r).Error("refunding order failed", "order_id", id, "error", err)
+		if msg == "" {
+			msg = "The refund failed. Please try again."
+		}
+		h.App.Session.Put(r.Context(), "error", msg)
+	default:
+		h.Logger(r).Info("order refunded", "order_id", id, "amount", refunded)
+		h.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Refunded %s.", render.FormatPrice(refunded, "R$")))
+	}
+
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// AdminSubscriptions renders a page of subscriptions, optionally filtered by status.
+func (h *WebHandlers) AdminSubscriptions(w http.ResponseWriter, r *http.Request) {
+	filter := repository.SubscriptionFilter{
+		Page:   pageFromQuery(r),
+		Status: r.URL.Query().Get("status"),
+	}
+
+	subs, total, err := h.App.Services.SubscriptionService.ListSubscriptions(r.Context(), filter)
+	if err != nil {
+		h.Logger(r).Error("listing subscriptions failed", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]any{
+		"subscriptions": subs,
+		"pagination":    newPagination(r, filter.Page, total),
+	}
+
+	stringMap := map[string]string{
+		"status": filter.Status,
+	}
+
+	if err := h.App.Renderer.RenderTemplate(w, r, "admin-subscriptions", &render.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	}, "pagination"); err != nil {
+		h.Logger(r).Error("rendering page failed", "page", "admin-subscriptions", "error", err)
+	}
+}
+
+// AdminSubscription renders the details of a subscription with its cancel form.
+func (h *WebHandlers) AdminSubscription(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	sub, err := h.App.Services.SubscriptionService.GetSubscription(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		h.Logger(r).Error("loading subscription failed", "subscription_id", id, "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]any{
+		"subscription": sub,
+		"cancelable":   sub.Status != string(cards.SubscriptionCanceled),
+	}
+
+	if err := h.App.Renderer.RenderTemplate(w, r, "admin-subscription", &render.TemplateData{
+		Data: data,
+	}); err != nil {
+		h.Logger(r).Error("rendering page failed", "page", "admin-subscription", "error", err)
+	}
+}
+
+// AdminCancelSubscription cancels a subscription from its detail page,
+// immediately or at the end of the current period.
+func (h *WebHandlers) AdminCancelSubscription(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		h.Logger(r).Warn("parsing form failed", "error", err)
+		return
+	}
+
+	atPeriodEnd := r.Form.Get("at_period_end") == "1"
+
+	_, err = h.App.Services.SubscriptionService.Cancel(r.Context(), id, atPeriodEnd)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.NotFound(w, r)
+		return
+	case errors.Is(err, services.ErrSubscriptionEnded):
+		h.App.Session.Put(r.Context(), "error", "This subscription has already ended.")
+	case err != nil:
+		h.Logger(r).Error("canceling subscription failed", "subscription_id", id, "error", err)
+		h.App.Session.Put(r.Context(), "error", "The subscription could not be canceled. Please try again.")
+	case atPeriodEnd:
+		h.App.Session.Put(r.Context(), "flash", "The subscription will end with the current period.")
+	default:
+		h.App.Session.Put(r.Context(), "flash", "The subscription was canceled.")
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/subscriptions/%d", id), http.StatusSeeOther)
+}
+
+// AdminCustomers renders the customer lookup. The q query parameter matches
+// the start of an email or name.
+func (h *WebHandlers) AdminCustomers(w http.ResponseWriter, r *http.Request) {
+	filter := repository.CustomerFilter{
+		Page:   pageFromQuery(r),
+		Search: r.URL.Query().Get("q"),
+	}
+
+	customers, total, err := h.App.Services.CustomerService.ListCustomers(r.Context(), filter)
+	if err != nil {
+		h.Logger(r).Error("listing customers failed", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]any{
+		"customers":  customers,
+		"pagination": newPagination(r, filter.Page, total),
+	}
+
+	stringMap := map[string]string{
+		"q": filter.Search,
+	}
+
+	if err := h.App.Renderer.RenderTemplate(w, r, "admin-customers", &render.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	}, "pagination"); err != nil {
+		h.Logger(r).Error("rendering page failed", "page", "admin-customers", "error", err)
+	}
+}
+
+// AdminCustomer renders a customer with their latest orders and subscriptions.
+func (h *WebHandlers) AdminCustomer(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	customer, err := h.App.Services.CustomerService.GetCustomer(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		h.Logger(r).Error("loading customer failed", "customer_id", id, "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	orders, _, err := h.App.Services.OrderService.ListOrders(r.Context(), repository.OrderFilter{
+		CustomerID: id,
+	})
+	if err != nil {
+		h.Logger(r).Error("listing orders failed", "customer_id", id, "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	subs, _, err := h.App.Services.SubscriptionService.ListSubscriptions(r.Context(), repository.SubscriptionFilter{
+		CustomerID: id,
+	})
+	if err != nil {
+		h.Logger(r).Error("listing subscriptions failed", "customer_id", id, "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]any{
+		"customer":      customer,
+		"orders":        orders,
+		"subscriptions": subs,
+	}
+
+	if err := h.App.Renderer.RenderTemplate(w, r, "admin-customer", &render.TemplateData{
+		Data: data,
+	}); err != nil {
+		h.Logger(r).Error("rendering page failed", "page", "admin-customer", "error", err)
+	}
+}
+
+// AdminWidgets renders the whole catalog, archived widgets included.
+func (h *WebHandlers) AdminWidgets(w http.ResponseWriter, r *http.Request) {
+	filter := repository.WidgetFilter{
+		Page:            pageFromQuery(r),
+		IncludeArchived: true,
+	}
+
+	widgets, total, err := h.App.Services.WidgetService.ListWidgets(r.Context(), filter)
+	if err != nil {
+		h.Logger(r).Error("listing widgets failed", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]any{
+		"widgets":    widgets,
+		"pagination": newPagination(r, filter.Page, total),
+	}
+
+	if err := h.App.Renderer.RenderTemplate(w, r, "admin-widgets", &render.TemplateData{
+		Data: data,
+	}, "pagination"); err != nil {
+		h.Logger(r).Error("rendering page failed", "page", "admin-widgets", "error", err)
+	}
+}
+
+// AdminNewWidget renders an empty widget form.
+func (h *WebHandlers) AdminNewWidget(w http.ResponseWriter, r *http.Request) {
+	h.renderWidgetForm(w, r, models.Widget{}, nil)
+}
+
+// AdminEditWidget renders the form to edit a widget.
+func (h *WebHandlers) AdminEditWidget(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	widget, err := h.App.Repositories.Widget.GetWidgetByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		h.Logger(r).Error("loading widget failed", "widget_id", id, "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	h.renderWidgetForm(w, r, widget, map[string]string{
+		"price": render.FormatPrice(widget.Price, ""),
+	})
+}
+
+// AdminSaveWidget creates a widget, or updates the one in the {id} URL
+// parameter, from the widget form.
+func (h *WebHandlers) AdminSaveWidget(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.Logger(r).Warn("parsing form failed", "error", err)
+		return
+	}
+
+	widget := models.Widget{
+		Name:            r.Form.Get("name"),
+		Description:     r.Form.Get("description"),
+		Image:           r.Form.Get("image"),
+		IsRecurring:     r.Form.Get("is_recurring") == "1",
+		PlanID:          r.Form.Get("plan_id"),
+		BillingInterval: r.Form.Get("billing_interval"),
+		Slug:            r.Form.Get("slug"),
+	}
+	if !widget.IsRecurring {
+		widget.BillingInterval = ""
+	}
+
+	form := map[string]string{
+		"price":                r.Form.Get("price"),
+		"inventory_adjustment": r.Form.Get("inventory_adjustment"),
+	}
+
+	var err error
+	if id := chi.URLParam(r, "id"); id != "" {
+		if widget.ID, err = strconv.Atoi(id); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		// The form only shows the stock of an existing widget. It is changed
+		// by an adjustment so the sales made while editing are kept.
+		existing, err := h.App.Repositories.Widget.GetWidgetByID(r.Context(), widget.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			h.Logger(r).Error("loading widget failed", "widget_id", widget.ID, "error", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		widget.InventoryLevel = existing.InventoryLevel
+		widget.Archived = existing.Archived
+	}
+
+	if widget.Price, err = parseCents(form["price"]); err != nil {
+		h.App.Session.Put(r.Context(), "error", "Enter the price like 12.50.")
+		h.renderWidgetForm(w, r, widget, form)
+		return
+	}
+
+	var adjustment int
+	if widget.ID == 0 {
+		widget.InventoryLevel, err = strconv.Atoi(strings.TrimSpace(r.Form.Get("inventory_level")))
+	} else if v := strings.TrimSpace(form["inventory_adjustment"]); v != "" {
+		adjustment, err = strconv.Atoi(v)
+	}
+	if err != nil {
+		h.App.Session.Put(r.Context(), "error", "Enter the inventory as a whole number.")
+		h.renderWidgetForm(w, r, widget, form)
+		return
+	}
+
+	if widget.ID == 0 {
+		widget.ID, err = h.App.Services.WidgetService.CreateWidget(r.Context(), widget)
+	} else {
+		_, err = h.App.Services.WidgetService.UpdateWidget(r.Context(), widget, adjustment)
+	}
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.NotFound(w, r)
+		return
+	case errors.Is(err, services.ErrInvalidWidget):
+		h.App.Session.Put(r.Context(), "error", err.Error())
+		h.renderWidgetForm(w, r, widget, form)
+		return
+	case err != nil:
+		h.Logger(r).Error("saving widget failed", "widget_id", widget.ID, "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	h.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%s was saved.", widget.Name))
+	http.Redirect(w, r, fmt.Sprintf("/admin/widgets/%d", widget.ID), http.StatusSeeOther)
+}
+
+// AdminArchiveWidget takes a widget out of the catalog.
+func (h *WebHandlers) AdminArchiveWidget(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	err = h.App.Services.WidgetService.ArchiveWidget(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		h.Logger(r).Error("archiving widget failed", "widget_id", id, "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	h.App.Session.Put(r.Context(), "flash", "The widget was archived.")
+	http.Redirect(w, r, "/admin/widgets", http.StatusSeeOther)
+}
+
+// renderWidgetForm renders the widget form. The price and inventory
+// adjustment are passed in form as typed so an invalid value can be corrected.
+func (h *WebHandlers) renderWidgetForm(w http.ResponseWriter, r *http.Request, widget models.Widget, form map[string]string) {
+	if widget.BillingInterval == "" {
+		widget.BillingInterval = models.BillingIntervalMonth
+	}
+
+	data := map[string]any{
+		"widget": widget,
+		"intervals": []string{
+			models.BillingIntervalDay,
+			models.BillingIntervalWeek,
+			models.BillingIntervalMonth,
+			models.BillingIntervalYear,
+		},
+	}
+
+	if err := h.App.Renderer.RenderTemplate(w, r, "admin-widget", &render.TemplateData{
+		StringMap: form,
+		Data:      data,
+	}); err != nil {
+		h.Logger(r).Error("rendering page failed", "page", "admin-widget", "error", err)
+	}
+}
@@ -1,14 +1,23 @@
 package web
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/mlvieira/store/internal/cards"
 	"github.com/mlvieira/store/internal/handlers"
+	"github.com/mlvieira/store/internal/mailer"
 	"github.com/mlvieira/store/internal/models"
 	"github.com/mlvieira/store/internal/render"
+	"github.com/mlvieira/store/internal/repository"
+	"github.com/mlvieira/store/internal/services"
+	"github.com/mlvieira/store/internal/urlsigner"
 )
 
 // WebHandlers embeds the shared Handlers to provide Web-specific handlers.
@@ -21,25 +30,152 @@ func NewWebHandlers(h *handlers.Handlers) *WebHandlers {
 	return &WebHandlers{Handlers: h}
 }
 
-// Homepage renders the home page.
+// Homepage renders the home page with the widgets and plans in the catalog.
 func (h *WebHandlers) Homepage(w http.ResponseWriter, r *http.Request) {
-	if err := h.App.Renderer.RenderTemplate(w, r, "home", nil); err != nil {
-		h.App.ErrorLog.Println(err)
+	catalog, _, err := h.App.Services.WidgetService.ListWidgets(r.Context(), repository.WidgetFilter{
+		Page: repository.Page{PageSize: 100},
+	})
+	if err != nil {
+		h.Logger(r).Error("listing widgets failed", "error", err)
+	}
+
+	var widgets, plans []models.Widget
+	for _, widget := range catalog {
+		if widget.IsRecurring {
+			plans = append(plans, widget)
+		} else {
+			widgets = append(widgets, widget)
+		}
+	}
+
+	data := map[string]any{
+		"widgets": widgets,
+		"plans":   plans,
+	}
+
+	if err := h.App.Renderer.RenderTemplate(w, r, "home", &render.TemplateData{
+		Data: data,
+	}); err != nil {
+		h.Logger(r).Error("rendering page failed", "page", "home", "error", err)
 	}
 }
 
-// VirtualTerminal renders the virtual terminal page.
+// VirtualTerminal renders the virtual terminal page with the staff's API
+// token. With an email in the query it also lists the saved cards of that
+// customer so they can be charged again.
 func (h *WebHandlers) VirtualTerminal(w http.ResponseWriter, r *http.Request) {
-	if err := h.App.Renderer.RenderTemplate(w, r, "terminal", nil); err != nil {
-		h.App.ErrorLog.Println(err)
+	stringMap := map[string]string{
+		"api_token": h.App.Session.GetString(r.Context(), "api_token"),
+	}
+	data := map[string]any{}
+
+	if email := strings.TrimSpace(r.URL.Query().Get("email")); email != "" {
+		stringMap["lookup_email"] = email
+
+		customer, err := h.App.Services.CustomerService.GetCustomerByEmail(r.Context(), email)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			h.Logger(r).Error("loading customer failed", "error", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		if err == nil {
+			pms, err := h.App.Services.PaymentMethodService.ListPaymentMethods(r.Context(), customer.ID)
+			if err != nil {
+				h.Logger(r).Error("listing saved cards failed", "customer_id", customer.ID, "error", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+
+			data["customer"] = customer
+			data["payment_methods"] = pms
+		}
+	}
+
+	if err := h.App.Renderer.RenderTemplate(w, r, "terminal", &render.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	}); err != nil {
+		h.Logger(r).Error("rendering page failed", "page", "terminal", "error", err)
 	}
 }
 
+// ChargeSavedCard charges a returning customer's saved card from the virtual
+// terminal. When the bank asks for authentication the customer is emailed a
+// link to confirm the payment instead.
+func (h *WebHandlers) ChargeSavedCard(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.Logger(r).Warn("parsing form failed", "error", err)
+		return
+	}
+
+	back := "/terminal?email=" + url.QueryEscape(r.Form.Get("email"))
+
+	id, err := strconv.Atoi(r.Form.Get("payment_method_id"))
+	if err != nil {
+		h.App.Session.Put(r.Context(), "error", "Please choose a saved card.")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	amount, err := parseCents(r.Form.Get("amount"))
+	if err != nil || amount == 0 {
+		h.App.Session.Put(r.Context(), "error", "Please enter a valid amount.")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	charge, msg, err := h.App.Services.PaymentMethodService.ChargeSavedMethod(r.Context(), id, models.Currency, amount)
+	switch {
+	case errors.Is(err, cards.ErrAuthenticationRequired):
+		h.App.Session.Put(r.Context(), "warning", fmt.Sprintf("The bank asked %s to authenticate this payment. We emailed them a link to confirm it.", charge.Customer.Email))
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	case errors.Is(err, sql.ErrNoRows):
+		h.App.Session.Put(r.Context(), "error", "That saved card no longer exists.")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	case err != nil:
+		h.Logger(r).Error("charging saved card failed", "saved_card_id", id, "error", err)
+		if msg == "" {
+			msg = "The card could not be charged. Please try again."
+		}
+		h.App.Session.Put(r.Context(), "error", msg)
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	txn := charge.Transaction
+	txnData := models.TransactionData{
+		FirstName:       charge.Customer.FirstName,
+		LastName:        charge.Customer.LastName,
+		Email:           charge.Customer.Email,
+		PaymentIntentID: txn.PaymentIntent,
+		PaymentMethodID: txn.PaymentMethod,
+		PaymentAmount:   txn.Amount,
+		PaymentCurrency: txn.Currency,
+		LastFour:        txn.LastFour,
+		ExpiryMonth:     strconv.Itoa(txn.ExpiryMonth),
+		ExpiryYear:      strconv.Itoa(txn.ExpiryYear),
+		BankReturnCode:  txn.BankReturnCode,
+	}
+
+	h.QueueMail(r, txnData.Email, mailer.TemplateTerminalReceipt, mailer.Receipt{
+		Amount:        txnData.PaymentAmount,
+		LastFour:      txnData.LastFour,
+		PaymentIntent: txnData.PaymentIntentID,
+	})
+
+	h.App.Session.Put(r.Context(), "receipt", txnData)
+
+	http.Redirect(w, r, "/terminal/receipt", http.StatusSeeOther)
+}
+
 // PaymentVirtualTerminal processes payment success from virtual terminal and renders a success page.
 func (h *WebHandlers) PaymentVirtualTerminal(w http.ResponseWriter, r *http.Request) {
 	txnData, err := h.GetTransactionData(r)
 	if err != nil {
-		h.App.ErrorLog.Println(err)
+		h.Logger(r).Error("reading transaction data failed", "error", err)
 		return
 	}
 
@@ -53,155 +189,193 @@ func (h *WebHandlers) PaymentVirtualTerminal(w http.ResponseWriter, r *http.Requ
 		ExpiryMonth:         intExpiryMonth,
 		ExpiryYear:          intExpiryYear,
 		BankReturnCode:      txnData.BankReturnCode,
-		TransactionStatusID: 2,
+		TransactionStatusID: models.TransactionStatusCleared,
 		PaymentIntent:       txnData.PaymentIntentID,
 		PaymentMethod:       txnData.PaymentMethodID,
 	}
 
 	_, err = h.App.Services.TransactionService.SaveTransaction(r.Context(), txn)
+	if errors.Is(err, repository.ErrDuplicate) {
+		// The form was posted again; the payment and its receipt are already recorded.
+		h.App.Session.Put(r.Context(), "receipt", txnData)
+		http.Redirect(w, r, "/terminal/receipt", http.StatusSeeOther)
+		return
+	}
 	if err != nil {
-		h.App.InfoLog.Println(err)
+		h.Logger(r).Error("saving transaction failed", "payment_intent", txn.PaymentIntent, "error", err)
 		return
 	}
 
+	h.QueueMail(r, txnData.Email, mailer.TemplateTerminalReceipt, mailer.Receipt{
+		Amount:        txnData.PaymentAmount,
+		LastFour:      txnData.LastFour,
+		PaymentIntent: txnData.PaymentIntentID,
+	})
+
 	h.App.Session.Put(r.Context(), "receipt", txnData)
 
 	http.Redirect(w, r, "/terminal/receipt", http.StatusSeeOther)
 }
 
-// GetTransactionData gets transaction data from post request and stripe
+// GetTransactionData reads the customer from the posted form, and the amount,
+// card and charge from the posted payment intent as the gateway reports it.
+// The payment intent must have succeeded.
 func (h *WebHandlers) GetTransactionData(r *http.Request) (models.TransactionData, error) {
 	var txnData models.TransactionData
 
 	err := r.ParseForm()
 	if err != nil {
-		h.App.ErrorLog.Println(err)
+		h.Logger(r).Warn("parsing form failed", "error", err)
 		return txnData, err
 	}
 
-	firstName := r.Form.Get("first_name")
-	lastName := r.Form.Get("last_name")
-	email := r.Form.Get("email")
+	card := h.App.Gateway
+
 	paymentIntent := r.Form.Get("payment_intent")
-	paymentMethod := r.Form.Get("payment_method")
-	paymentAmount := r.Form.Get("payment_amount")
-	paymentCurrency := r.Form.Get("payment_currency")
-	amount, err := strconv.ParseInt(paymentAmount, 10, 64)
+	pi, err := card.RetrievePaymentIntent(paymentIntent)
 	if err != nil {
-		h.App.ErrorLog.Println(err)
+		h.Logger(r).Error("retrieving payment intent failed", "payment_intent", paymentIntent, "error", err)
 		return txnData, err
 	}
 
-	card := cards.Card{
-		Secret: h.App.Config.Stripe.Secret,
-		Key:    h.App.Config.Stripe.Key,
+	if pi.Status != cards.PaymentIntentSucceeded || pi.PaymentMethodID == "" {
+		h.Logger(r).Warn("payment intent has not succeeded", "payment_intent", pi.ID, "status", pi.Status)
+		return txnData, services.ErrPaymentNotSucceeded
 	}
 
-	ci, err := card.RetrieveChargeID(paymentIntent)
+	pm, err := card.GetPaymentMethod(pi.PaymentMethodID)
 	if err != nil {
-		h.App.ErrorLog.Println(err)
+		h.Logger(r).Error("retrieving payment method failed", "payment_method", pi.PaymentMethodID, "error", err)
 		return txnData, err
 	}
 
-	pm, err := card.GetPaymentMethod(paymentMethod)
-	if err != nil {
-		h.App.ErrorLog.Println(err)
-		return txnData, err
-	}
-
-	lastFour := pm.Card.Last4
-	expiryMonth := pm.Card.ExpMonth
-	expiryYear := pm.Card.ExpYear
-
 	txnData = models.TransactionData{
-		FirstName:       firstName,
-		LastName:        lastName,
-		Email:           email,
-		PaymentIntentID: paymentIntent,
-		PaymentMethodID: paymentMethod,
-		PaymentAmount:   amount,
-		PaymentCurrency: paymentCurrency,
-		LastFour:        lastFour,
-		ExpiryMonth:     strconv.FormatInt(expiryMonth, 10),
-		ExpiryYear:      strconv.FormatInt(expiryYear, 10),
-		BankReturnCode:  ci,
+		FirstName:       r.Form.Get("first_name"),
+		LastName:        r.Form.Get("last_name"),
+		Email:           r.Form.Get("email"),
+		PaymentIntentID: pi.ID,
+		PaymentMethodID: pm.ID,
+		PaymentAmount:   pi.Amount,
+		PaymentCurrency: pi.Currency,
+		LastFour:        pm.LastFour,
+		ExpiryMonth:     strconv.Itoa(pm.ExpiryMonth),
+		ExpiryYear:      strconv.Itoa(pm.ExpiryYear),
+		BankReturnCode:  pi.LatestChargeID,
 	}
 
 	return txnData, nil
-
 }
 
-// PaymentSucceeded processes payment success and renders a success page.
+// PaymentSucceeded records the purchase paid with the posted payment intent
+// and shows its receipt. The widget, customer and amount are read from the
+// payment intent on the gateway, never from the form. The receipt is only
+// emailed when this request recorded the purchase, the webhook may have done
+// it first.
 func (h *WebHandlers) PaymentSucceeded(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		h.App.ErrorLog.Println(err)
+		h.Logger(r).Warn("parsing form failed", "error", err)
 		return
 	}
 
-	widgetID, err := strconv.Atoi(r.Form.Get("widget_id"))
+	paymentIntent := r.Form.Get("payment_intent")
+	pi, err := h.App.Gateway.RetrievePaymentIntent(paymentIntent)
 	if err != nil {
-		h.App.ErrorLog.Println(err)
+		h.Logger(r).Error("retrieving payment intent failed", "payment_intent", paymentIntent, "error", err)
 		return
 	}
 
-	txnData, err := h.GetTransactionData(r)
-	if err != nil {
-		h.App.ErrorLog.Println(err)
+	p, created, err := h.App.Services.CheckoutService.CheckoutPaymentIntent(r.Context(), pi)
+	switch {
+	case errors.Is(err, repository.ErrInsufficientStock):
+		h.App.Session.Put(r.Context(), "error", "Sorry, this widget sold out before your order went through. Your payment has been refunded.")
+		http.Redirect(w, r, fmt.Sprintf("/widget/%d", p.Order.WidgetID), http.StatusSeeOther)
+		return
+	case errors.Is(err, services.ErrPaymentMismatch):
+		h.Logger(r).Warn("payment does not match the widget price", "payment_intent", pi.ID, "error", err)
+		h.App.Session.Put(r.Context(), "error", "Sorry, the price of this widget changed before your order went through. Your payment has been refunded.")
+		http.Redirect(w, r, fmt.Sprintf("/widget/%d", p.Order.WidgetID), http.StatusSeeOther)
+		return
+	case err != nil:
+		h.Logger(r).Error("saving checkout failed", "payment_intent", pi.ID, "error", err)
 		return
 	}
 
-	customer := models.Customer{
-		FirstName: txnData.FirstName,
-		LastName:  txnData.LastName,
-		Email:     txnData.Email,
+	txn := p.Transaction
+	txnData := models.TransactionData{
+		FirstName:       p.Customer.FirstName,
+		LastName:        p.Customer.LastName,
+		Email:           p.Customer.Email,
+		PaymentIntentID: txn.PaymentIntent,
+		PaymentMethodID: txn.PaymentMethod,
+		PaymentAmount:   txn.Amount,
+		PaymentCurrency: txn.Currency,
+		LastFour:        txn.LastFour,
+		ExpiryMonth:     strconv.Itoa(txn.ExpiryMonth),
+		ExpiryYear:      strconv.Itoa(txn.ExpiryYear),
+		BankReturnCode:  txn.BankReturnCode,
 	}
 
-	customerID, err := h.App.Services.CustomerService.SaveCustomer(r.Context(), customer)
-	if err != nil {
-		h.App.ErrorLog.Println(err)
-		return
+	if created {
+		receipt := mailer.Receipt{
+			FirstName:     txnData.FirstName,
+			Amount:        txnData.PaymentAmount,
+			LastFour:      txnData.LastFour,
+			PaymentIntent: txnData.PaymentIntentID,
+		}
+		if widget, err := h.App.Repositories.Widget.GetWidgetByID(r.Context(), p.Order.WidgetID); err == nil {
+			receipt.WidgetName = widget.Name
+		}
+		h.QueueMail(r, txnData.Email, mailer.TemplateOrderReceipt, receipt)
 	}
 
-	intExpiryMonth, _ := strconv.Atoi(txnData.ExpiryMonth)
-	intExpiryYear, _ := strconv.Atoi(txnData.ExpiryYear)
+	h.App.Session.Put(r.Context(), "receipt", txnData)
 
-	txn := models.Transaction{
-		Amount:              txnData.PaymentAmount,
-		Currency:            txnData.PaymentCurrency,
-		LastFour:            txnData.LastFour,
-		ExpiryMonth:         intExpiryMonth,
-		ExpiryYear:          intExpiryYear,
-		BankReturnCode:      txnData.BankReturnCode,
-		TransactionStatusID: 2,
-		PaymentIntent:       txnData.PaymentIntentID,
-		PaymentMethod:       txnData.PaymentMethodID,
-	}
+	http.Redirect(w, r, "/payment/receipt", http.StatusSeeOther)
+}
 
-	txnID, err := h.App.Services.TransactionService.SaveTransaction(r.Context(), txn)
+// SubscriptionSucceeded shows the receipt once the card of a new subscription
+// is confirmed. The subscription and its order were already saved by the
+// create-subscription API call.
+func (h *WebHandlers) SubscriptionSucceeded(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
 	if err != nil {
-		h.App.InfoLog.Println(err)
+		h.Logger(r).Warn("parsing form failed", "error", err)
 		return
 	}
 
-	order := models.Order{
-		WidgetID:      widgetID,
-		TransactionID: txnID,
-		CustomerID:    customerID,
-		StatusID:      1,
-		Quantity:      1,
-		Amount:        txnData.PaymentAmount,
+	widgetID, err := strconv.Atoi(r.Form.Get("widget_id"))
+	if err != nil {
+		h.Logger(r).Warn("parsing widget ID failed", "error", err)
+		return
 	}
 
-	_, err = h.App.Services.OrderService.PlaceOrder(r.Context(), order)
+	widget, err := h.App.Repositories.Widget.GetWidgetByID(r.Context(), widgetID)
 	if err != nil {
-		h.App.ErrorLog.Println(err)
+		h.Logger(r).Error("loading widget failed", "widget_id", widgetID, "error", err)
 		return
 	}
 
-	h.App.Session.Put(r.Context(), "receipt", txnData)
+	pm, err := h.App.Gateway.GetPaymentMethod(r.Form.Get("payment_method"))
+	if err != nil {
+		h.Logger(r).Error("retrieving payment method failed", "error", err)
+		return
+	}
 
-	http.Redirect(w, r, "/receipt", http.StatusSeeOther)
+	h.App.Session.Put(r.Context(), "receipt", models.TransactionData{
+		FirstName:       r.Form.Get("first_name"),
+		LastName:        r.Form.Get("last_name"),
+		Email:           r.Form.Get("email"),
+		PaymentIntentID: r.Form.Get("payment_intent"),
+		PaymentMethodID: pm.ID,
+		PaymentAmount:   widget.Price,
+		PaymentCurrency: models.Currency,
+		LastFour:        pm.LastFour,
+		ExpiryMonth:     strconv.Itoa(pm.ExpiryMonth),
+		ExpiryYear:      strconv.Itoa(pm.ExpiryYear),
+	})
+
+	http.Redirect(w, r, "/payment/receipt", http.StatusSeeOther)
 }
 
 // ReceiptVirtualTerminal display receipt page for orders from virtual terminal
@@ -217,7 +391,7 @@ func (h *WebHandlers) ReceiptVirtualTerminal(w http.ResponseWriter, r *http.Requ
 	if err := h.App.Renderer.RenderTemplate(w, r, "terminal-receipt", &render.TemplateData{
 		Data: data,
 	}); err != nil {
-		h.App.ErrorLog.Println(err)
+		h.Logger(r).Error("rendering page failed", "page", "terminal-receipt", "error", err)
 	}
 }
 
@@ -234,7 +408,7 @@ func (h *WebHandlers) Receipt(w http.ResponseWriter, r *http.Request) {
 	if err := h.App.Renderer.RenderTemplate(w, r, "receipt", &render.TemplateData{
 		Data: data,
 	}); err != nil {
-		h.App.ErrorLog.Println(err)
+		h.Logger(r).Error("rendering page failed", "page", "receipt", "error", err)
 	}
 }
 
@@ -243,13 +417,18 @@ func (h *WebHandlers) ChargeOnce(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	widgetID, err := strconv.Atoi(id)
 	if err != nil {
-		h.App.ErrorLog.Println(err)
+		h.Logger(r).Warn("parsing widget ID failed", "error", err)
 		return
 	}
 
 	widget, err := h.App.Repositories.Widget.GetWidgetByID(r.Context(), widgetID)
 	if err != nil {
-		h.App.ErrorLog.Println(err)
+		h.Logger(r).Error("loading widget failed", "widget_id", widgetID, "error", err)
+		return
+	}
+
+	if widget.Archived {
+		http.NotFound(w, r)
 		return
 	}
 
@@ -260,15 +439,32 @@ func (h *WebHandlers) ChargeOnce(w http.ResponseWriter, r *http.Request) {
 	if err := h.App.Renderer.RenderTemplate(w, r, "buy-once", &render.TemplateData{
 		Data: data,
 	}); err != nil {
-		h.App.ErrorLog.Println(err)
+		h.Logger(r).Error("rendering page failed", "page", "buy-once", "error", err)
 	}
 }
 
-// BronzePlan renders the page of bronze subscription
-func (h *WebHandlers) BronzePlan(w http.ResponseWriter, r *http.Request) {
-	widget, err := h.App.Repositories.Widget.GetWidgetByID(r.Context(), 2)
-	if err != nil {
-		h.App.ErrorLog.Println(err)
+// legacyPlanSlugs maps the slugs of plan pages that were served before
+// slugs were derived from widget names to the slug the page has now, so
+// old links and bookmarks keep working.
+var legacyPlanSlugs = map[string]string{
+	"bronze": "bronze-plan",
+}
+
+// Plan renders the subscription page of the recurring widget with the given
+// slug. A legacy slug no widget uses any more is redirected permanently.
+func (h *WebHandlers) Plan(w http.ResponseWriter, r *http.Request) {
+	slug := chi.URLParam(r, "slug")
+
+	widget, err := h.App.Repositories.Widget.GetWidgetBySlug(r.Context(), slug)
+	if to, ok := legacyPlanSlugs[slug]; ok && errors.Is(err, sql.ErrNoRows) {
+		http.Redirect(w, r, "/plans/"+to, http.StatusMovedPermanently)
+		return
+	}
+	if err != nil || widget.Archived || !widget.IsRecurring {
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			h.Logger(r).Error("loading widget failed", "error", err)
+		}
+		http.NotFound(w, r)
 		return
 	}
 
@@ -276,9 +472,276 @@ func (h *WebHandlers) BronzePlan(w http.ResponseWriter, r *http.Request) {
 		"widget": widget,
 	}
 
-	if err := h.App.Renderer.RenderTemplate(w, r, "bronze-plan", &render.TemplateData{
+	if err := h.App.Renderer.RenderTemplate(w, r, "plan", &render.TemplateData{
 		Data: data,
 	}); err != nil {
-		h.App.ErrorLog.Println(err)
+		h.Logger(r).Error("rendering page failed", "page", "plan", "error", err)
+	}
+}
+
+// LoginPage renders the staff login page.
+func (h *WebHandlers) LoginPage(w http.ResponseWriter, r *http.Request) {
+	if err := h.App.Renderer.RenderTemplate(w, r, "login", nil); err != nil {
+		h.Logger(r).Error("rendering page failed", "page", "login", "error", err)
+	}
+}
+
+// PostLoginPage logs a staff member in. Besides the session it issues an API
+// token so the virtual terminal can call the protected API routes.
+func (h *WebHandlers) PostLoginPage(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.Logger(r).Warn("parsing form failed", "error", err)
+		return
+	}
+
+	email := r.Form.Get("email")
+
+	user, err := h.App.Services.AuthService.Authenticate(r.Context(), email, r.Form.Get("password"))
+	if errors.Is(err, services.ErrInvalidCredentials) {
+		h.App.Session.Put(r.Context(), "error", "Invalid email or password.")
+		h.renderLogin(w, r, email)
+		return
+	}
+	if err != nil {
+		h.Logger(r).Error("authenticating user failed", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	token, err := h.App.Services.AuthService.IssueToken(r.Context(), user.ID)
+	if err != nil {
+		h.Logger(r).Error("issuing token failed", "user_id", user.ID, "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.App.Session.RenewToken(r.Context()); err != nil {
+		h.Logger(r).Error("renewing session token failed", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	h.App.Session.Put(r.Context(), "userID", user.ID)
+	h.App.Session.Put(r.Context(), "password_stamp", h.App.Services.AuthService.SessionStamp(user))
+	h.App.Session.Put(r.Context(), "api_token", token.PlainText)
+	h.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Welcome back, %s.", user.FirstName))
+
+	http.Redirect(w, r, "/terminal", http.StatusSeeOther)
+}
+
+// Logout ends the staff session and revokes the API token issued with it.
+func (h *WebHandlers) Logout(w http.ResponseWriter, r *http.Request) {
+	if token := h.App.Session.GetString(r.Context(), "api_token"); token != "" {
+		if err := h.App.Services.AuthService.RevokeToken(r.Context(), token); err != nil {
+			h.Logger(r).Error("revoking API token failed", "error", err)
+		}
+	}
+
+	if err := h.App.Session.Destroy(r.Context()); err != nil {
+		h.Logger(r).Error("destroying session failed", "error", err)
+	}
+
+	h.App.Session.Put(r.Context(), "flash", "You have been logged out.")
+
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// renderLogin renders the login page keeping the email that was typed.
+func (h *WebHandlers) renderLogin(w http.ResponseWriter, r *http.Request, email string) {
+	stringMap := map[string]string{
+		"email": email,
+	}
+
+	if err := h.App.Renderer.RenderTemplate(w, r, "login", &render.TemplateData{
+		StringMap: stringMap,
+	}); err != nil {
+		h.Logger(r).Error("rendering page failed", "page", "login", "error", err)
+	}
+}
+
+// ForgotPassword renders the page to request a password reset link.
+func (h *WebHandlers) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	if err := h.App.Renderer.RenderTemplate(w, r, "forgot-password", nil); err != nil {
+		h.Logger(r).Error("rendering page failed", "page", "forgot-password", "error", err)
+	}
+}
+
+// PostForgotPassword emails a password reset link. The response is the same
+// whether or not the email belongs to a user.
+func (h *WebHandlers) PostForgotPassword(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.Logger(r).Warn("parsing form failed", "error", err)
+		return
+	}
+
+	if err := h.App.Services.PasswordResetService.SendResetLink(r.Context(), r.Form.Get("email")); err != nil {
+		h.Logger(r).Error("sending reset link failed", "error", err)
+		h.App.Session.Put(r.Context(), "error", "We could not send the reset link. Please try again.")
+		http.Redirect(w, r, "/forgot-password", http.StatusSeeOther)
+		return
+	}
+
+	h.App.Session.Put(r.Context(), "flash", "If that email belongs to an account, a reset link is on its way.")
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// ResetPassword renders the form to choose a new password from a signed reset link.
+func (h *WebHandlers) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	link := r.URL.RequestURI()
+
+	email, err := h.App.Services.PasswordResetService.VerifyLink(r.Context(), link)
+	if err != nil {
+		h.renderInvalidLink(w, r, err)
+		return
+	}
+
+	stringMap := map[string]string{
+		"email": email,
+		"link":  link,
+	}
+
+	if err := h.App.Renderer.RenderTemplate(w, r, "reset-password", &render.TemplateData{
+		StringMap: stringMap,
+	}); err != nil {
+		h.Logger(r).Error("rendering page failed", "page", "reset-password", "error", err)
+	}
+}
+
+// PostResetPassword saves the new password chosen through a signed reset link.
+func (h *WebHandlers) PostResetPassword(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.Logger(r).Warn("parsing form failed", "error", err)
+		return
+	}
+
+	link := r.Form.Get("link")
+	password := r.Form.Get("password")
+
+	if _, err := h.App.Services.PasswordResetService.VerifyLink(r.Context(), link); err != nil {
+		h.renderInvalidLink(w, r, err)
+		return
+	}
+
+	// The link is verified, so its path is ours. Drop any host before redirecting back to it.
+	u, _ := url.Parse(link)
+	link = u.RequestURI()
+
+	if password != r.Form.Get("verify_password") {
+		h.App.Session.Put(r.Context(), "error", "The passwords do not match.")
+		http.Redirect(w, r, link, http.StatusSeeOther)
+		return
+	}
+
+	err := h.App.Services.PasswordResetService.ResetPassword(r.Context(), link, password)
+	switch {
+	case errors.Is(err, urlsigner.ErrInvalidSignature), errors.Is(err, urlsigner.ErrExpired),
+		errors.Is(err, services.ErrLinkUsed), errors.Is(err, sql.ErrNoRows):
+		h.renderInvalidLink(w, r, err)
+		return
+	case errors.Is(err, services.ErrWeakPassword):
+		h.App.Session.Put(r.Context(), "error", "Your password must be at least 8 characters long.")
+		http.Redirect(w, r, link, http.StatusSeeOther)
+		return
+	case err != nil:
+		h.Logger(r).Error("resetting password failed", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	h.App.Session.Put(r.Context(), "flash", "Your password was changed. You can log in now.")
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// AuthenticatePayment renders the page where a customer confirms a saved
+// card charge their bank asked them to authenticate.
+func (h *WebHandlers) AuthenticatePayment(w http.ResponseWriter, r *http.Request) {
+	link := r.URL.RequestURI()
+
+	pi, pm, err := h.App.Services.PaymentMethodService.AuthenticationPayment(link)
+	if errors.Is(err, urlsigner.ErrExpired) || errors.Is(err, urlsigner.ErrInvalidSignature) {
+		h.renderPaymentLinkError(w, r, err)
+		return
+	}
+	if err != nil {
+		h.Logger(r).Error("loading payment to authenticate failed", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	stringMap := map[string]string{
+		"link":           link,
+		"client_secret":  pi.ClientSecret,
+		"payment_method": pm,
+	}
+	if pi.Status == cards.PaymentIntentSucceeded {
+		stringMap["paid"] = "1"
+	}
+
+	if err := h.App.Renderer.RenderTemplate(w, r, "payment-authentication", &render.TemplateData{
+		StringMap: stringMap,
+		Data:      map[string]any{"amount": pi.Amount},
+	}); err != nil {
+		h.Logger(r).Error("rendering page failed", "page", "payment-authentication", "error", err)
+	}
+}
+
+// PostAuthenticatePayment records a payment the customer confirmed on the
+// authentication page.
+func (h *WebHandlers) PostAuthenticatePayment(w http.ResponseWriter, r *http.Request) {
+	link := r.URL.RequestURI()
+
+	err := h.App.Services.PaymentMethodService.CompleteAuthentication(r.Context(), link)
+	switch {
+	case errors.Is(err, urlsigner.ErrExpired) || errors.Is(err, urlsigner.ErrInvalidSignature):
+		h.renderPaymentLinkError(w, r, err)
+		return
+	case errors.Is(err, services.ErrPaymentIncomplete):
+		h.App.Session.Put(r.Context(), "error", "Your payment was not confirmed. Please try again.")
+	case err != nil:
+		h.Logger(r).Error("completing authenticated payment failed", "error", err)
+		h.App.Session.Put(r.Context(), "error", "We could not check your payment. Please try again.")
+	default:
+		h.App.Session.Put(r.Context(), "flash", "Thank you, your payment is confirmed.")
+	}
+
+	http.Redirect(w, r, link, http.StatusSeeOther)
+}
+
+// renderPaymentLinkError explains why a payment authentication link was rejected.
+func (h *WebHandlers) renderPaymentLinkError(w http.ResponseWriter, r *http.Request, err error) {
+	reason := "This payment link is not valid. It may have been copied incompletely."
+	if errors.Is(err, urlsigner.ErrExpired) {
+		reason = "This payment link has expired. Payment links are valid for 7 days. Please contact us for a new one."
+	}
+
+	h.renderLinkError(w, r, reason, "")
+}
+
+// renderInvalidLink explains why a password reset link was rejected.
+func (h *WebHandlers) renderInvalidLink(w http.ResponseWriter, r *http.Request, err error) {
+	reason := "This password reset link is not valid. It may have been copied incompletely."
+	switch {
+	case errors.Is(err, urlsigner.ErrExpired):
+		reason = "This password reset link has expired. Reset links are valid for one hour."
+	case errors.Is(err, services.ErrLinkUsed):
+		reason = "This password reset link was already used. Request a new one to change your password again."
+	}
+
+	h.renderLinkError(w, r, reason, "/forgot-password")
+}
+
+// renderLinkError renders the page for a rejected signed link, pointing to
+// where a new link can be requested when retry is not empty.
+func (h *WebHandlers) renderLinkError(w http.ResponseWriter, r *http.Request, reason, retry string) {
+	stringMap := map[string]string{
+		"reason": reason,
+		"retry":  retry,
+	}
+
+	w.WriteHeader(http.StatusBadRequest)
+	if err := h.App.Renderer.RenderTemplate(w, r, "invalid-link", &render.TemplateData{
+		StringMap: stringMap,
+	}); err != nil {
+		h.Logger(r).Error("rendering page failed", "page", "invalid-link", "error", err)
 	}
 }
@@ -0,0 +1,78 @@
+package web
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alexedwards/scs/v2"
+	"github.com/mlvieira/store/internal/application"
+	"github.com/mlvieira/store/internal/cards"
+	"github.com/mlvieira/store/internal/config"
+	"github.com/mlvieira/store/internal/handlers"
+	"github.com/mlvieira/store/internal/logging"
+	"github.com/mlvieira/store/internal/repository"
+	"github.com/mlvieira/store/internal/services"
+)
+
+// newWebHandlers returns web handlers on a mocked database and the fake
+// gateway.
+func newWebHandlers(t *testing.T) (*WebHandlers, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	gateway := cards.NewFakeGateway()
+	repos := repository.NewRepositories(db)
+
+	return NewWebHandlers(handlers.NewHandlers(&application.Application{
+		Config:       &config.Config{Gateway: "fake"},
+		Logger:       logging.New(io.Discard, slog.LevelError),
+		DB:           db,
+		Repositories: repos,
+		Session:      scs.New(),
+		Services:     services.NewServices(repos, gateway, nil, nil, ""),
+		Gateway:      gateway,
+	})), mock
+}
+
+// withSession serves next with a session holding values.
+func withSession(h *WebHandlers, values map[string]string, next http.HandlerFunc) http.Handler {
+	return h.App.Session.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range values {
+			h.App.Session.Put(r.Context(), k, v)
+		}
+		next(w, r)
+	}))
+}
+
+func TestLogoutRevokesAPIToken(t *testing.T) {
+	h, mock := newWebHandlers(t)
+
+	sum := sha256.Sum256([]byte("PLAINTEXT"))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM tokens WHERE token_hash = ?")).
+		WithArgs(hex.EncodeToString(sum[:])).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	rec := httptest.NewRecorder()
+	withSession(h, map[string]string{"api_token": "PLAINTEXT"}, h.Logout).
+		ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/logout", nil))
+
+	if rec.Code != http.StatusSeeOther {
+		t.Errorf("status = %d, want 303", rec.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,94 @@
+// Package invoice renders order invoices as PDF documents.
+package invoice
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/mlvieira/store/internal/models"
+	"github.com/mlvieira/store/internal/render"
+)
+
+// currencySymbol prefixes every amount on the invoice.
+const currencySymbol = "R$"
+
+// Invoice holds everything printed on an order's invoice.
+type Invoice struct {
+	Order       models.Order
+	Customer    models.Customer
+	Widget      models.Widget
+	Transaction models.Transaction
+}
+
+// Number returns the invoice number, derived from the order ID.
+func (inv Invoice) Number() string {
+	return fmt.Sprintf("INV-%06d", inv.Order.ID)
+}
+
+// Render writes the invoice as a PDF to w.
+func Render(w io.Writer, inv Invoice) error {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetTitle("Invoice "+inv.Number(), true)
+	pdf.SetAuthor("Widgets", true)
+	pdf.AddPage()
+
+	// The core fonts are cp1252, so accented names need translating.
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	pdf.SetFont("Helvetica", "B", 20)
+	pdf.CellFormat(0, 12, "Invoice", "", 1, "L", false, 0, "")
+
+	pdf.SetFont("Helvetica", "", 11)
+	pdf.CellFormat(0, 6, "Invoice number: "+inv.Number(), "", 1, "L", false, 0, "")
+	pdf.CellFormat(0, 6, "Date: "+inv.Order.CreatedAt.Format(time.DateOnly), "", 1, "L", false, 0, "")
+	pdf.Ln(6)
+
+	pdf.SetFont("Helvetica", "B", 11)
+	pdf.CellFormat(0, 6, "Bill to", "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 11)
+	pdf.CellFormat(0, 6, tr(inv.Customer.FirstName+" "+inv.Customer.LastName), "", 1, "L", false, 0, "")
+	pdf.CellFormat(0, 6, tr(inv.Customer.Email), "", 1, "L", false, 0, "")
+	pdf.Ln(8)
+
+	unitPrice := inv.Order.Amount
+	if inv.Order.Quantity > 0 {
+		unitPrice = inv.Order.Amount / int64(inv.Order.Quantity)
+	}
+
+	pdf.SetFont("Helvetica", "B", 11)
+	pdf.SetFillColor(230, 230, 230)
+	pdf.CellFormat(95, 8, "Item", "1", 0, "L", true, 0, "")
+	pdf.CellFormat(25, 8, "Quantity", "1", 0, "R", true, 0, "")
+	pdf.CellFormat(35, 8, "Unit price", "1", 0, "R", true, 0, "")
+	pdf.CellFormat(35, 8, "Amount", "1", 1, "R", true, 0, "")
+
+	pdf.SetFont("Helvetica", "", 11)
+	pdf.CellFormat(95, 8, tr(inv.Widget.Name), "1", 0, "L", false, 0, "")
+	pdf.CellFormat(25, 8, strconv.Itoa(inv.Order.Quantity), "1", 0, "R", false, 0, "")
+	pdf.CellFormat(35, 8, render.FormatPrice(unitPrice, currencySymbol), "1", 0, "R", false, 0, "")
+	pdf.CellFormat(35, 8, render.FormatPrice(inv.Order.Amount, currencySymbol), "1", 1, "R", false, 0, "")
+
+	pdf.SetFont("Helvetica", "B", 11)
+	pdf.CellFormat(155, 8, "Total", "1", 0, "R", false, 0, "")
+	pdf.CellFormat(35, 8, render.FormatPrice(inv.Order.Amount, currencySymbol), "1", 1, "R", false, 0, "")
+
+	if inv.Transaction.RefundedAmount > 0 {
+		pdf.SetFont("Helvetica", "", 11)
+		pdf.CellFormat(155, 8, "Refunded", "1", 0, "R", false, 0, "")
+		pdf.CellFormat(35, 8, "-"+render.FormatPrice(inv.Transaction.RefundedAmount, currencySymbol), "1", 1, "R", false, 0, "")
+	}
+	pdf.Ln(8)
+
+	pdf.SetFont("Helvetica", "", 11)
+	if inv.Transaction.LastFour != "" {
+		pdf.CellFormat(0, 6, "Paid with card ending in "+inv.Transaction.LastFour, "", 1, "L", false, 0, "")
+	}
+	if inv.Transaction.PaymentIntent != "" {
+		pdf.CellFormat(0, 6, "Payment reference: "+inv.Transaction.PaymentIntent, "", 1, "L", false, 0, "")
+	}
+
+	return pdf.Output(w)
+}
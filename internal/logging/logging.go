@@ -0,0 +1,46 @@
+// Package logging builds the structured logger of the store, which masks
+// personal and card data, and carries the ID of the current request through
+// contexts so every line logged while serving it can be correlated.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+)
+
+// RequestIDHeader is the header a request ID is read from and echoed in.
+// The web front end forwards it when the browser calls the API.
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDKey holds the request ID in a context.
+type requestIDKey struct{}
+
+// New returns a logger writing JSON lines at or above level to w. Personal
+// and card data is masked before it is written, see Redact.
+func New(w io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redactAttr,
+	}))
+}
+
+// WithRequestID returns a copy of ctx carrying the request ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID stored in ctx, or "" when there is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// FromContext returns a child of logger that adds the request ID of ctx to
+// every line, or logger itself outside of a request.
+func FromContext(ctx context.Context, logger *slog.Logger) *slog.Logger {
+	if id := RequestID(ctx); id != "" {
+		return logger.With("request_id", id)
+	}
+	return logger
+}
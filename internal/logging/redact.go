@@ -0,0 +1,168 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// redacted replaces values that must never reach the log.
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are attribute keys whose values are dropped whatever they
+// look like. Keys containing "secret", "password" or "token" are dropped too.
+var sensitiveKeys = map[string]bool{
+	"authorization":     true,
+	"card_number":       true,
+	"payment_method":    true,
+	"payment_method_id": true,
+}
+
+var (
+	// emailPattern matches email addresses, keeping the first character of
+	// the local part and the domain.
+	emailPattern = regexp.MustCompile(`([A-Za-z0-9._%+\-])[A-Za-z0-9._%+\-]*@([A-Za-z0-9.\-]+\.[A-Za-z]{2,})`)
+	// secretPattern matches Stripe secret and restricted keys and webhook
+	// signing secrets.
+	secretPattern = regexp.MustCompile(`\b(sk|rk)_(live|test)_[A-Za-z0-9]+|\bwhsec_[A-Za-z0-9]+`)
+	// clientSecretPattern matches payment and setup intent client secrets.
+	clientSecretPattern = regexp.MustCompile(`\b(pi|seti)_[A-Za-z0-9]+_secret_[A-Za-z0-9]+`)
+	// paymentMethodPattern matches Stripe payment method and card IDs. The
+	// fake gateway puts the whole card number in them.
+	paymentMethodPattern = regexp.MustCompile(`\b(pm|card|src)_[A-Za-z0-9]+`)
+	// panPattern matches runs of digits as long as a card number.
+	panPattern = regexp.MustCompile(`\b\d{13,19}\b`)
+)
+
+// redactAttr is the ReplaceAttr hook of the logger. Attributes with a
+// sensitive key, or inside a group with one, are dropped, and emails, Stripe
+// secrets, client secrets, payment method IDs and card numbers are masked
+// wherever they appear in a string or error value, including the message.
+// LogValuers are resolved and groups walked, and other values, such as
+// structs, are redacted field by field through their JSON encoding.
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if sensitiveKey(a.Key) || slices.ContainsFunc(groups, sensitiveKey) {
+		return slog.String(a.Key, redacted)
+	}
+
+	a.Value = a.Value.Resolve()
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, Redact(a.Value.String()))
+	case slog.KindGroup:
+		inner := append(slices.Clip(groups), a.Key)
+		attrs := a.Value.Group()
+		out := make([]slog.Attr, len(attrs))
+		for i, ga := range attrs {
+			out[i] = redactAttr(inner, ga)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(out...)}
+	case slog.KindAny:
+		return slog.Attr{Key: a.Key, Value: redactAny(a.Value.Any())}
+	}
+
+	return a
+}
+
+// redactAny redacts a value of kind Any. Errors are logged as their
+// redacted message; anything else is encoded as JSON, which is what the
+// handler would write, and redacted with redactJSON.
+func redactAny(v any) slog.Value {
+	switch v := v.(type) {
+	case slog.Level:
+		return slog.AnyValue(v)
+	case error:
+		return slog.StringValue(Redact(v.Error()))
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return slog.StringValue(Redact(fmt.Sprint(v)))
+	}
+
+	// UseNumber keeps large IDs exact.
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var decoded any
+	if err := dec.Decode(&decoded); err != nil {
+		return slog.StringValue(Redact(string(data)))
+	}
+
+	return slog.AnyValue(redactJSON(decoded))
+}
+
+// redactJSON drops the fields of decoded JSON with a sensitive key and
+// masks its strings, in place.
+func redactJSON(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			if sensitiveKey(k) {
+				v[k] = redacted
+				continue
+			}
+			v[k] = redactJSON(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = redactJSON(e)
+		}
+	case string:
+		return Redact(v)
+	}
+
+	return v
+}
+
+// sensitiveKey reports whether values logged under key are always dropped.
+func sensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	return sensitiveKeys[key] ||
+		strings.Contains(key, "secret") ||
+		strings.Contains(key, "password") ||
+		strings.Contains(key, "token")
+}
+
+// Redact masks the personal and card data in s. Emails keep their first
+// character and domain, card numbers their last four digits.
+func Redact(s string) string {
+	s = secretPattern.ReplaceAllString(s, redacted)
+	s = clientSecretPattern.ReplaceAllString(s, "${1}_"+redacted)
+	s = paymentMethodPattern.ReplaceAllString(s, "${1}_"+redacted)
+	s = emailPattern.ReplaceAllString(s, "${1}***@${2}")
+	s = panPattern.ReplaceAllStringFunc(s, maskPAN)
+	return s
+}
+
+// maskPAN masks all but the last four digits of a number that passes the
+// Luhn check, leaving other long numbers such as timestamps alone.
+func maskPAN(digits string) string {
+	if !luhn(digits) {
+		return digits
+	}
+	return strings.Repeat("*", len(digits)-4) + digits[len(digits)-4:]
+}
+
+// luhn reports whether digits passes the Luhn checksum used by card numbers.
+func luhn(digits string) bool {
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
@@ -0,0 +1,126 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// customer stands in for the models the services log.
+type customer struct {
+	ID       int64
+	Email    string
+	Password string
+	Cards    []card `json:"cards"`
+}
+
+type card struct {
+	PaymentMethod string `json:"payment_method"`
+	Last4         string `json:"last_four"`
+}
+
+// loggedCustomer logs as a group, the way a LogValuer hides its fields.
+type loggedCustomer struct {
+	email string
+}
+
+func (c loggedCustomer) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("email", c.email))
+}
+
+// raw is personal and card data that must never be written.
+var raw = []string{
+	"ana.souza@example.com",
+	"4242424242424242",
+	"hunter2",
+	"pm_4000002760003184",
+	"sk_test_abc123",
+	"pi_123_secret_456",
+}
+
+func TestLoggerRedacts(t *testing.T) {
+	tests := []struct {
+		name string
+		log  func(*slog.Logger)
+		want []string
+	}{
+		{
+			name: "message and strings",
+			log: func(l *slog.Logger) {
+				l.Info("charging ana.souza@example.com with 4242424242424242", "secret", "sk_test_abc123", "client", "pi_123_secret_456")
+			},
+			want: []string{"a***@example.com", "************4242"},
+		},
+		{
+			name: "error",
+			log: func(l *slog.Logger) {
+				l.Error("charge failed", "error", errors.New("card pm_4000002760003184 of ana.souza@example.com declined"))
+			},
+			want: []string{"pm_" + redacted, "a***@example.com"},
+		},
+		{
+			name: "struct",
+			log: func(l *slog.Logger) {
+				l.Info("signed up", "customer", customer{
+					ID:       9007199254740993,
+					Email:    "ana.souza@example.com",
+					Password: "hunter2",
+					Cards:    []card{{PaymentMethod: "pm_4000002760003184", Last4: "3184"}},
+				})
+			},
+			want: []string{`"ID":9007199254740993`, `"Password":"` + redacted, `"last_four":"3184"`},
+		},
+		{
+			name: "pointer to struct",
+			log: func(l *slog.Logger) {
+				l.Info("signed up", "customer", &customer{Email: "ana.souza@example.com"})
+			},
+			want: []string{"a***@example.com"},
+		},
+		{
+			name: "log valuer",
+			log: func(l *slog.Logger) {
+				l.Info("signed up", "customer", loggedCustomer{email: "ana.souza@example.com"})
+			},
+			want: []string{"a***@example.com"},
+		},
+		{
+			name: "group with a sensitive key",
+			log: func(l *slog.Logger) {
+				l.Info("changed password", slog.Group("password", "new", "hunter2"))
+			},
+			want: []string{`"new":"` + redacted},
+		},
+		{
+			name: "logger group",
+			log: func(l *slog.Logger) {
+				l.WithGroup("payment_method").With("number", "4242424242424242").Info("saved card", "email", "ana.souza@example.com")
+			},
+			want: []string{`"email":"` + redacted},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tt.log(New(&buf, slog.LevelInfo))
+			out := buf.String()
+
+			for _, s := range raw {
+				if strings.Contains(out, s) {
+					t.Errorf("logged %q:\n%s", s, out)
+				}
+			}
+			for _, s := range tt.want {
+				if !strings.Contains(out, s) {
+					t.Errorf("missing %q:\n%s", s, out)
+				}
+			}
+			if !strings.Contains(out, `"level":"INFO"`) && !strings.Contains(out, `"level":"ERROR"`) {
+				t.Errorf("level not written as text:\n%s", out)
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+// Package mailer renders and sends transactional email. Messages are
+// rendered from templates embedded in the binary and delivered through a
+// pluggable Transport, either right away or from a bounded worker pool.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	htmltemplate "html/template"
+	"log/slog"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/mlvieira/store/internal/render"
+)
+
+// ErrQueueFull is returned by Enqueue when every worker is busy and the queue is full.
+var ErrQueueFull = errors.New("mail queue is full")
+
+// ErrClosed is returned by Enqueue after Close was called.
+var ErrClosed = errors.New("mailer is closed")
+
+// sendTimeout bounds the delivery of one queued message.
+const sendTimeout = 30 * time.Second
+
+// Embed templates directory
+//
+//go:embed templates
+var templateFS embed.FS
+
+// functions defines custom template functions.
+var functions = map[string]any{
+	"formatPrice": render.FormatPrice,
+}
+
+// Message is an email with a plain text body and an optional HTML alternative.
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// job is a queued templated message.
+type job struct {
+	to       string
+	template string
+	data     any
+}
+
+// Mailer renders templated messages and hands them to a Transport.
+type Mailer struct {
+	transport Transport
+	from      string
+	logger    *slog.Logger
+
+	text *texttemplate.Template
+	html *htmltemplate.Template
+
+	mu     sync.RWMutex
+	closed bool
+	jobs   chan job
+	wg     sync.WaitGroup
+}
+
+// New parses the embedded templates and starts workers goroutines that
+// deliver queued messages. At most queueSize messages wait for a worker.
+func New(transport Transport, from string, workers, queueSize int, logger *slog.Logger) (*Mailer, error) {
+	text, err := texttemplate.New("").Funcs(functions).ParseFS(templateFS, "templates/*.txt")
+	if err != nil {
+		return nil, err
+	}
+
+	html, err := htmltemplate.New("").Funcs(functions).ParseFS(templateFS, "templates/*.html")
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Mailer{
+		transport: transport,
+		from:      from,
+		logger:    logger,
+		text:      text,
+		html:      html,
+		jobs:      make(chan job, queueSize),
+	}
+
+	for range workers {
+		m.wg.Add(1)
+		go m.work()
+	}
+
+	return m, nil
+}
+
+// Send renders the named template with data and delivers it to the given
+// address, waiting for the transport.
+func (m *Mailer) Send(ctx context.Context, to, template string, data any) error {
+	msg, err := m.render(to, template, data)
+	if err != nil {
+		return err
+	}
+
+	return m.transport.Send(ctx, msg)
+}
+
+// Enqueue queues a templated message for delivery by the worker pool. It
+// never blocks; when the queue is full the message is dropped and
+// ErrQueueFull is returned.
+func (m *Mailer) Enqueue(to, template string, data any) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+
+	select {
+	case m.jobs <- job{to: to, template: template, data: data}:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Close stops accepting messages and waits until the queued ones are
+// delivered or ctx is done.
+func (m *Mailer) Close(ctx context.Context) error {
+	m.mu.Lock()
+	if !m.closed {
+		m.closed = true
+		close(m.jobs)
+	}
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// work delivers queued messages until the queue is closed.
+func (m *Mailer) work() {
+	defer m.wg.Done()
+
+	for j := range m.jobs {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		if err := m.Send(ctx, j.to, j.template, j.data); err != nil {
+			m.logger.Error("sending mail failed", "template", j.template, "to", j.to, "error", err)
+		}
+		cancel()
+	}
+}
+
+// render builds a message from the "<name>.txt" and optional "<name>.html"
+// templates. The text template defines the subject in a "<name>.subject" block.
+func (m *Mailer) render(to, name string, data any) (Message, error) {
+	msg := Message{From: m.from, To: to}
+
+	var buf bytes.Buffer
+	if err := m.text.ExecuteTemplate(&buf, name+".txt", data); err != nil {
+		return msg, err
+	}
+	msg.Text = buf.String()
+
+	buf.Reset()
+	if err := m.text.ExecuteTemplate(&buf, name+".subject", data); err != nil {
+		return msg, err
+	}
+	msg.Subject = strings.TrimSpace(buf.String())
+
+	if m.html.Lookup(name+".html") != nil {
+		buf.Reset()
+		if err := m.html.ExecuteTemplate(&buf, name+".html", data); err != nil {
+			return msg, err
+		}
+		msg.HTML = buf.String()
+	}
+
+	return msg, nil
+}
@@ -0,0 +1,50 @@
+package mailer
+
+// Template names.
+const (
+	TemplateOrderReceipt             = "order-receipt"
+	TemplateTerminalReceipt          = "terminal-receipt"
+	TemplateSubscriptionConfirmation = "subscription-confirmation"
+	TemplatePasswordReset            = "password-reset"
+	TemplateAccountSignIn            = "account-sign-in"
+	TemplatePaymentAuthentication    = "payment-authentication"
+)
+
+// Receipt is the data of the order and virtual terminal receipts.
+type Receipt struct {
+	FirstName     string
+	WidgetName    string
+	Amount        int64
+	LastFour      string
+	PaymentIntent string
+}
+
+// SubscriptionConfirmation is the data of the subscription confirmation.
+type SubscriptionConfirmation struct {
+	FirstName       string
+	WidgetName      string
+	Amount          int64
+	BillingInterval string
+	LastFour        string
+}
+
+// PasswordReset is the data of the password reset email.
+type PasswordReset struct {
+	FirstName string
+	Link      string
+}
+
+// AccountSignIn is the data of the customer account sign-in email.
+type AccountSignIn struct {
+	FirstName string
+	Link      string
+}
+
+// PaymentAuthentication is the data of the email asking a customer to
+// authenticate a charge to their saved card.
+type PaymentAuthentication struct {
+	FirstName string
+	Amount    int64
+	LastFour  string
+	Link      string
+}
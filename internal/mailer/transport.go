@@ -0,0 +1,239 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Transport delivers rendered messages.
+type Transport interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// SMTPTransport delivers messages through an SMTP server, using STARTTLS
+// when the server offers it.
+type SMTPTransport struct {
+	addr string
+	auth smtp.Auth
+}
+
+// NewSMTPTransport creates an SMTPTransport. Authentication is skipped when
+// username is empty.
+func NewSMTPTransport(host string, port int, username, password string) *SMTPTransport {
+	t := &SMTPTransport{addr: host + ":" + strconv.Itoa(port)}
+	if username != "" {
+		t.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return t
+}
+
+// Send delivers the message. The connection is dialed with ctx, and its
+// deadline, or its cancellation, also stops a conversation with a server that
+// stopped answering.
+func (t *SMTPTransport) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender: %w", err)
+	}
+
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient: %w", err)
+	}
+
+	raw, err := msg.bytes()
+	if err != nil {
+		return err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", t.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	if err := t.send(conn, from.Address, to.Address, raw); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("%w: %w", ctx.Err(), err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// send runs the SMTP conversation of smtp.SendMail over conn.
+func (t *SMTPTransport) send(conn net.Conn, from, to string, raw []byte) error {
+	host, _, err := net.SplitHostPort(t.addr)
+	if err != nil {
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+
+	if t.auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp: server doesn't support AUTH")
+		}
+		if err := c.Auth(t.auth); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(raw); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+// FileTransport writes each message as an .eml file into a directory, for
+// development and tests.
+type FileTransport struct {
+	dir string
+}
+
+// NewFileTransport creates a FileTransport writing into dir, creating it if needed.
+func NewFileTransport(dir string) (*FileTransport, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileTransport{dir: dir}, nil
+}
+
+// unsafeFileChars matches characters that are replaced in .eml file names.
+var unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9@._-]`)
+
+// Send writes the message to a new file.
+func (t *FileTransport) Send(ctx context.Context, msg Message) error {
+	raw, err := msg.bytes()
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), unsafeFileChars.ReplaceAllString(msg.To, "_"))
+	return os.WriteFile(filepath.Join(t.dir, name), raw, 0o644)
+}
+
+// LogTransport logs the recipient and subject of each message, for
+// development. Bodies carry signed reset and payment links, so they are not
+// logged; use FileTransport to read them.
+type LogTransport struct {
+	log *slog.Logger
+}
+
+// NewLogTransport creates a LogTransport writing to the given logger.
+func NewLogTransport(logger *slog.Logger) *LogTransport {
+	return &LogTransport{log: logger}
+}
+
+// Send logs the message without its body.
+func (t *LogTransport) Send(ctx context.Context, msg Message) error {
+	t.log.InfoContext(ctx, "mail", "to", msg.To, "subject", msg.Subject, "bytes", len(msg.Text)+len(msg.HTML))
+	return nil
+}
+
+var (
+	_ Transport = (*SMTPTransport)(nil)
+	_ Transport = (*FileTransport)(nil)
+	_ Transport = (*LogTransport)(nil)
+)
+
+// bytes encodes the message as MIME, with the HTML part as an alternative
+// to the text part when present.
+func (msg Message) bytes() ([]byte, error) {
+	if strings.ContainsAny(msg.From+msg.To, "\r\n") {
+		return nil, errors.New("line break in address header")
+	}
+
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "From: %s\r\n", msg.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+
+	if msg.HTML == "" {
+		buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+		buf.WriteString(msg.Text)
+		return buf.Bytes(), nil
+	}
+
+	w := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", w.Boundary())
+
+	parts := []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	}
+
+	for _, part := range parts {
+		pw, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {part.contentType}})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := pw.Write([]byte(part.body)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
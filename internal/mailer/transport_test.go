@@ -0,0 +1,146 @@
+package mailer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listen starts a TCP listener on a free local port and returns an
+// SMTPTransport pointed at it.
+func listen(t *testing.T) (net.Listener, *SMTPTransport) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	addr := ln.Addr().(*net.TCPAddr)
+	return ln, NewSMTPTransport("127.0.0.1", addr.Port, "", "")
+}
+
+// serveSMTP answers one SMTP conversation, sending the received message
+// data on got.
+func serveSMTP(ln net.Listener, got chan<- string) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 localhost ready")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+		case strings.HasPrefix(cmd, "EHLO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "DATA"):
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			got <- data.String()
+			reply("250 queued")
+		case strings.HasPrefix(cmd, "QUIT"):
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func TestSMTPTransportSend(t *testing.T) {
+	ln, transport := listen(t)
+
+	got := make(chan string, 1)
+	go serveSMTP(ln, got)
+
+	err := transport.Send(context.Background(), Message{
+		From:    "Widgets <no-reply@widgets.local>",
+		To:      "ana@example.com",
+		Subject: "Hello",
+		Text:    "Hello Ana",
+	})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	if data := <-got; !strings.Contains(data, "Hello Ana") {
+		t.Errorf("message data = %q, want the text body", data)
+	}
+}
+
+func TestSMTPTransportSendStopsAtDeadline(t *testing.T) {
+	ln, transport := listen(t)
+
+	// The server accepts the connection and never greets.
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			defer conn.Close()
+			<-done
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := transport.Send(ctx, Message{From: "no-reply@widgets.local", To: "ana@example.com", Text: "Hello"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Send error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Send returned after %s, want it to stop at the deadline", elapsed)
+	}
+}
+
+func TestLogTransportOmitsBody(t *testing.T) {
+	var buf bytes.Buffer
+	transport := NewLogTransport(slog.New(slog.NewJSONHandler(&buf, nil)))
+
+	link := "http://localhost:4000/reset-password?email=ana%40example.com&stamp=1f2e&signature=abc123"
+	err := transport.Send(context.Background(), Message{
+		To:      "ana@example.com",
+		Subject: "Reset your password",
+		Text:    "Reset it at " + link,
+		HTML:    `<a href="` + link + `">Reset it</a>`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+	if strings.Contains(out, "signature=") {
+		t.Errorf("logged the body:\n%s", out)
+	}
+	if !strings.Contains(out, "Reset your password") {
+		t.Errorf("did not log the subject:\n%s", out)
+	}
+}
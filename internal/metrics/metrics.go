@@ -0,0 +1,123 @@
+// Package metrics defines the Prometheus collectors of the store and the
+// handler that exposes them. Collectors live in the default registry, next
+// to the Go runtime and process metrics.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mlvieira/store/internal/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes every metric defined here.
+const namespace = "store"
+
+// otherCurrency labels the revenue of currencies outside revenueCurrencies.
+const otherCurrency = "other"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route pattern and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests by method and route pattern.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	gatewayCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "gateway_calls_total",
+		Help:      "Payment gateway calls by operation, outcome and Stripe error code.",
+	}, []string{"operation", "outcome", "code"})
+
+	gatewayDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "gateway_call_duration_seconds",
+		Help:      "Latency of payment gateway calls by operation and outcome.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "outcome"})
+
+	ordersPlaced = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "orders_placed_total",
+		Help:      "Orders placed through checkout.",
+	})
+
+	revenue = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "revenue_cents_total",
+		Help:      "Cleared payments in cents by currency.",
+	}, []string{"currency"})
+
+	// revenueCurrencies are the currencies given their own revenue series.
+	// Payments in any other currency, e.g. made outside the store, share
+	// otherCurrency to keep the label bounded.
+	revenueCurrencies = map[string]bool{models.Currency: true}
+
+	subscriptionsCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "subscriptions_created_total",
+		Help:      "Subscriptions created through checkout.",
+	})
+)
+
+// Handler serves the collected metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// RegisterDB exposes the connection pool statistics of db. It must be
+// called once per process.
+func RegisterDB(db *sql.DB) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, namespace))
+}
+
+// ObserveHTTP records a served request.
+func ObserveHTTP(method, route string, status int, elapsed time.Duration) {
+	httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	httpDuration.WithLabelValues(method, route).Observe(elapsed.Seconds())
+}
+
+// ObserveGatewayCall records a payment gateway call. The code is the Stripe
+// error code, empty when the call succeeded or failed without one.
+func ObserveGatewayCall(operation, outcome, code string, elapsed time.Duration) {
+	gatewayCalls.WithLabelValues(operation, outcome, code).Inc()
+	gatewayDuration.WithLabelValues(operation, outcome).Observe(elapsed.Seconds())
+}
+
+// OrderPlaced records an order saved by checkout.
+func OrderPlaced() {
+	ordersPlaced.Inc()
+}
+
+// PaymentCleared adds a cleared payment to the revenue of its currency.
+func PaymentCleared(currency string, amount int64) {
+	revenue.WithLabelValues(revenueCurrency(currency)).Add(float64(amount))
+}
+
+// revenueCurrency returns the label value recorded for currency.
+func revenueCurrency(currency string) string {
+	currency = strings.ToLower(currency)
+	if !revenueCurrencies[currency] {
+		return otherCurrency
+	}
+	return currency
+}
+
+// SubscriptionCreated records a subscription saved by checkout.
+func SubscriptionCreated() {
+	subscriptionsCreated.Inc()
+}
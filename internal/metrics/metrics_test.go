@@ -0,0 +1,26 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPaymentClearedBoundsCurrencies(t *testing.T) {
+	revenue.Reset()
+
+	PaymentCleared("brl", 1000)
+	PaymentCleared("BRL", 500)
+	PaymentCleared("usd", 200)
+	PaymentCleared("not-a-currency-1", 300)
+
+	if got := testutil.ToFloat64(revenue.WithLabelValues("brl")); got != 1500 {
+		t.Errorf("brl revenue = %v, want 1500", got)
+	}
+	if got := testutil.ToFloat64(revenue.WithLabelValues(otherCurrency)); got != 500 {
+		t.Errorf("other revenue = %v, want 500", got)
+	}
+	if got := testutil.CollectAndCount(revenue); got != 2 {
+		t.Errorf("revenue has %d series, want 2", got)
+	}
+}
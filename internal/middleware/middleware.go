@@ -1,14 +1,211 @@
 package middleware
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log/slog"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/alexedwards/scs/v2"
+	"github.com/go-chi/chi/v5"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+	"github.com/mlvieira/store/internal/logging"
+	"github.com/mlvieira/store/internal/metrics"
+	"github.com/mlvieira/store/internal/models"
+	"github.com/mlvieira/store/internal/services"
 )
 
+// contextKey is the type of the keys this package stores in request contexts.
+type contextKey string
+
+// userContextKey holds the authenticated user of a request.
+const userContextKey contextKey = "user"
+
 // MiddlewareSession wraps the session manager around requests.
 func MiddlewareSession(sessionManager *scs.SessionManager) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return sessionManager.LoadAndSave(next)
 	}
 }
+
+// maxRequestIDLength bounds the request IDs accepted from clients.
+const maxRequestIDLength = 64
+
+// RequestID puts the request's X-Request-ID in its context and echoes it in
+// the response. A missing or malformed ID is replaced with a random one, so
+// IDs forwarded by the web front end are kept across servers.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(logging.RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set(logging.RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
+
+// validRequestID reports whether a client-supplied ID is short and made of
+// characters that are safe to log and echo.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// newRequestID returns 16 random bytes as hex.
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Metrics records the count and latency of requests by chi route pattern,
+// so /orders/1 and /orders/2 are counted together. Requests that match no
+// route are counted as "unmatched".
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := chimiddleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		metrics.ObserveHTTP(r.Method, route, status, time.Since(start))
+	})
+}
+
+// RequireUser rejects requests without a valid bearer token in the
+// Authorization header and puts the token's user in the request context.
+func RequireUser(auth *services.AuthService, logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || token == "" {
+				unauthorized(w)
+				return
+			}
+
+			user, err := auth.ValidateToken(r.Context(), token)
+			if err != nil {
+				if !errors.Is(err, services.ErrInvalidToken) {
+					logging.FromContext(r.Context(), logger).Error("validating token failed", "error", err)
+				}
+				unauthorized(w)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(WithUser(r.Context(), user)))
+		})
+	}
+}
+
+// RequireToken only lets through requests carrying token as a bearer token
+// in the Authorization header. With an empty token every request gets a 404,
+// so an unconfigured endpoint stays hidden.
+func RequireToken(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token == "" {
+				http.NotFound(w, r)
+				return
+			}
+
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				unauthorized(w)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// WithUser returns a copy of ctx carrying the authenticated user.
+func WithUser(ctx context.Context, user models.User) context.Context {
+	return context.WithValue(ctx, userContextKey, user)
+}
+
+// UserFromContext returns the authenticated user stored by RequireUser.
+func UserFromContext(ctx context.Context) (models.User, bool) {
+	user, ok := ctx.Value(userContextKey).(models.User)
+	return user, ok
+}
+
+// unauthorized writes a 401 JSON response.
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write([]byte(`{"ok":false,"message":"Unauthorized"}`))
+}
+
+// Auth redirects visitors without a staff session to the login page. A
+// session opened before the user's password changed is destroyed first.
+func Auth(sessionManager *scs.SessionManager, auth *services.AuthService, logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			if !sessionManager.Exists(ctx, "userID") {
+				http.Redirect(w, r, "/login", http.StatusSeeOther)
+				return
+			}
+
+			err := auth.ValidateSession(ctx, sessionManager.GetInt(ctx, "userID"), sessionManager.GetString(ctx, "password_stamp"))
+			if errors.Is(err, services.ErrSessionExpired) {
+				if err := sessionManager.Destroy(ctx); err != nil {
+					logging.FromContext(ctx, logger).Error("destroying session failed", "error", err)
+				}
+				http.Redirect(w, r, "/login", http.StatusSeeOther)
+				return
+			}
+			if err != nil {
+				logging.FromContext(ctx, logger).Error("validating session failed", "error", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CustomerAuth redirects visitors without a customer session to the account sign-in page.
+func CustomerAuth(sessionManager *scs.SessionManager) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !sessionManager.Exists(r.Context(), "customerID") {
+				http.Redirect(w, r, "/account/sign-in", http.StatusSeeOther)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
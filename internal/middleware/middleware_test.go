@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alexedwards/scs/v2"
+	"github.com/mlvieira/store/internal/models"
+	"github.com/mlvieira/store/internal/repository"
+	"github.com/mlvieira/store/internal/services"
+)
+
+func TestAuthRejectsSessionAfterPasswordReset(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	repos := repository.NewRepositories(db)
+	auth := services.NewAuthService(repos.User, repos.Token)
+	sessions := scs.New()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	// The session was opened with the old password.
+	stamp := auth.SessionStamp(models.User{ID: 4, Password: "old-hash"})
+
+	expectUser := func(hash string) {
+		mock.ExpectQuery(regexp.QuoteMeta("FROM users")).
+			WithArgs(4).
+			WillReturnRows(sqlmock.NewRows([]string{
+				"id", "first_name", "last_name", "email", "password", "created_at", "updated_at",
+			}).AddRow(4, "Staff", "User", "staff@example.com", hash, time.Now(), time.Now()))
+	}
+
+	serve := func() (*httptest.ResponseRecorder, bool) {
+		reached := false
+		var loggedIn bool
+		protected := Auth(sessions, auth, logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reached = true
+		}))
+
+		rec := httptest.NewRecorder()
+		sessions.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sessions.Put(r.Context(), "userID", 4)
+			sessions.Put(r.Context(), "password_stamp", stamp)
+			protected.ServeHTTP(w, r)
+			loggedIn = sessions.Exists(r.Context(), "userID")
+		})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/terminal", nil))
+
+		if reached != loggedIn {
+			t.Errorf("reached handler = %v but session kept = %v", reached, loggedIn)
+		}
+		return rec, reached
+	}
+
+	expectUser("old-hash")
+	if _, reached := serve(); !reached {
+		t.Fatal("session with the current password was rejected")
+	}
+
+	// The password was reset since the session was opened.
+	expectUser("new-hash")
+	rec, reached := serve()
+	if reached {
+		t.Error("session opened with the old password reached the handler")
+	}
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/login" {
+		t.Errorf("got %d to %q, want a redirect to /login", rec.Code, rec.Header().Get("Location"))
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
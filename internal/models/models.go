@@ -4,31 +4,96 @@ import (
 	"time"
 )
 
+// Order statuses, matching the rows seeded in the statuses table.
+const (
+	OrderStatusCleared = iota + 1
+	OrderStatusRefunded
+	OrderStatusCancelled
+)
+
+// Transaction statuses, matching the rows seeded in the transaction_statuses table.
+const (
+	TransactionStatusPending = iota + 1
+	TransactionStatusCleared
+	TransactionStatusDeclined
+	TransactionStatusRefunded
+	TransactionStatusPartiallyRefunded
+)
+
+// orderStatusesBefore lists, for each order status that payment events can
+// set, the statuses an order may move to it from. Orders are only saved once
+// paid, so a failed payment never cancels one, and a refunded order stays
+// refunded whatever events arrive late.
+var orderStatusesBefore = map[int][]int{
+	OrderStatusCleared:  {OrderStatusCancelled},
+	OrderStatusRefunded: {OrderStatusCleared},
+}
+
+// transactionStatusesBefore lists, for each transaction status, the statuses
+// a transaction may move to it from. Refunded transactions never change
+// again, and a cleared one is not declined by a late failure event.
+var transactionStatusesBefore = map[int][]int{
+	TransactionStatusCleared:           {TransactionStatusPending, TransactionStatusDeclined},
+	TransactionStatusDeclined:          {TransactionStatusPending},
+	TransactionStatusPartiallyRefunded: {TransactionStatusCleared, TransactionStatusPartiallyRefunded},
+	TransactionStatusRefunded:          {TransactionStatusCleared, TransactionStatusPartiallyRefunded},
+}
+
+// OrderStatusesBefore returns the statuses an order may move to statusID
+// from. It is empty when statusID is never set on an existing order.
+func OrderStatusesBefore(statusID int) []int {
+	return orderStatusesBefore[statusID]
+}
+
+// TransactionStatusesBefore returns the statuses a transaction may move to
+// statusID from.
+func TransactionStatusesBefore(statusID int) []int {
+	return transactionStatusesBefore[statusID]
+}
+
+// Currency is the currency widget prices are set and charged in.
+const Currency = "brl"
+
+// Billing intervals of recurring widgets, as understood by Stripe.
+const (
+	BillingIntervalDay   = "day"
+	BillingIntervalWeek  = "week"
+	BillingIntervalMonth = "month"
+	BillingIntervalYear  = "year"
+)
+
 // Widget is the type for all widgets (product)
 type Widget struct {
-	ID             int       `json:"id"`
-	Name           string    `json:"name"`
-	Description    string    `json:"description"`
-	InventoryLevel int       `json:"inventory_level"`
-	Price          int64     `json:"price"`
-	Image          string    `json:"image"`
-	IsRecurring    bool      `json:"is_recurring"`
-	PlanID         string    `json:"plan_id"`
-	CreatedAt      time.Time `json:"-"`
-	UpdatedAt      time.Time `json:"-"`
+	ID              int       `json:"id"`
+	Name            string    `json:"name"`
+	Description     string    `json:"description"`
+	InventoryLevel  int       `json:"inventory_level"`
+	Price           int64     `json:"price"`
+	Image           string    `json:"image"`
+	IsRecurring     bool      `json:"is_recurring"`
+	PlanID          string    `json:"plan_id"`
+	BillingInterval string    `json:"billing_interval"`
+	Slug            string    `json:"slug"`
+	Archived        bool      `json:"archived"`
+	CreatedAt       time.Time `json:"-"`
+	UpdatedAt       time.Time `json:"-"`
 }
 
 // Order is the type for all order
 type Order struct {
-	ID            int       `json:"id"`
-	WidgetID      int       `json:"widget_id"`
-	TransactionID int       `json:"transaction_id"`
-	CustomerID    int       `json:"customer_id"`
-	StatusID      int       `json:"status_id"`
-	Quantity      int       `json:"quantity"`
-	Amount        int64     `json:"amount"`
-	CreatedAt     time.Time `json:"-"`
-	UpdatedAt     time.Time `json:"-"`
+	ID            int         `json:"id"`
+	WidgetID      int         `json:"widget_id"`
+	TransactionID int         `json:"transaction_id"`
+	CustomerID    int         `json:"customer_id"`
+	StatusID      int         `json:"status_id"`
+	Quantity      int         `json:"quantity"`
+	Amount        int64       `json:"amount"`
+	CreatedAt     time.Time   `json:"created_at"`
+	UpdatedAt     time.Time   `json:"-"`
+	Widget        Widget      `json:"widget"`
+	Transaction   Transaction `json:"transaction"`
+	Customer      Customer    `json:"customer"`
+	Status        Status      `json:"status"`
 }
 
 // Status is the type for order statuses
@@ -49,18 +114,20 @@ type TransactionStatus struct {
 
 // Transaction is the type for transactions
 type Transaction struct {
-	ID                  int       `json:"id"`
-	Amount              int64     `json:"amount"`
-	Currency            string    `json:"currency"`
-	LastFour            string    `json:"last_four"`
-	BankReturnCode      string    `json:"bank_return_code"`
-	TransactionStatusID int       `json:"transaction_status_id"`
-	ExpiryMonth         int       `json:"expiry_month"`
-	ExpiryYear          int       `json:"expiry_year"`
-	PaymentIntent       string    `json:"payment_intent"`
-	PaymentMethod       string    `json:"payment_method"`
-	CreatedAt           time.Time `json:"-"`
-	UpdatedAt           time.Time `json:"-"`
+	ID                  int               `json:"id"`
+	Amount              int64             `json:"amount"`
+	Currency            string            `json:"currency"`
+	LastFour            string            `json:"last_four"`
+	BankReturnCode      string            `json:"bank_return_code"`
+	TransactionStatusID int               `json:"transaction_status_id"`
+	ExpiryMonth         int               `json:"expiry_month"`
+	ExpiryYear          int               `json:"expiry_year"`
+	PaymentIntent       string            `json:"payment_intent"`
+	PaymentMethod       string            `json:"payment_method"`
+	RefundedAmount      int64             `json:"refunded_amount"`
+	CreatedAt           time.Time         `json:"created_at"`
+	UpdatedAt           time.Time         `json:"-"`
+	Status              TransactionStatus `json:"status"`
 }
 
 // User is the type for users
@@ -69,21 +136,64 @@ type User struct {
 	FirstName string    `json:"first_name"`
 	LastName  string    `json:"last_name"`
 	Email     string    `json:"email"`
-	Password  string    `json:"password"`
+	Password  string    `json:"-"`
 	CreatedAt time.Time `json:"-"`
 	UpdatedAt time.Time `json:"-"`
 }
 
-// Customer is the type for customers
-type Customer struct {
-	ID        int       `json:"id"`
-	FirstName string    `json:"first_name"`
-	LastName  string    `json:"last_name"`
-	Email     string    `json:"email"`
+// Token is the type for API authentication tokens. Only the hash of the
+// plain text token is stored.
+type Token struct {
+	ID        int       `json:"-"`
+	UserID    int       `json:"-"`
+	PlainText string    `json:"token"`
+	Hash      string    `json:"-"`
+	Expiry    time.Time `json:"expiry"`
 	CreatedAt time.Time `json:"-"`
 	UpdatedAt time.Time `json:"-"`
 }
 
+// Customer is the type for customers. Email is unique.
+type Customer struct {
+	ID               int       `json:"id"`
+	FirstName        string    `json:"first_name"`
+	LastName         string    `json:"last_name"`
+	Email            string    `json:"email"`
+	StripeCustomerID string    `json:"stripe_customer_id"`
+	CreatedAt        time.Time `json:"-"`
+	UpdatedAt        time.Time `json:"-"`
+}
+
+// PaymentMethod is the type for cards saved with Stripe for a customer.
+// At most one card per customer is the default.
+type PaymentMethod struct {
+	ID                    int       `json:"id"`
+	CustomerID            int       `json:"customer_id"`
+	StripePaymentMethodID string    `json:"stripe_payment_method_id"`
+	Brand                 string    `json:"brand"`
+	LastFour              string    `json:"last_four"`
+	ExpiryMonth           int       `json:"expiry_month"`
+	ExpiryYear            int       `json:"expiry_year"`
+	IsDefault             bool      `json:"is_default"`
+	CreatedAt             time.Time `json:"-"`
+	UpdatedAt             time.Time `json:"-"`
+}
+
+// Subscription is the type for customer subscriptions to recurring widgets
+type Subscription struct {
+	ID                   int       `json:"id"`
+	CustomerID           int       `json:"customer_id"`
+	WidgetID             int       `json:"widget_id"`
+	StripeSubscriptionID string    `json:"stripe_subscription_id"`
+	Status               string    `json:"status"`
+	CancelAtPeriodEnd    bool      `json:"cancel_at_period_end"`
+	CurrentPeriodEnd     time.Time `json:"current_period_end"`
+	CreatedAt            time.Time `json:"-"`
+	UpdatedAt            time.Time `json:"-"`
+	Widget               Widget    `json:"widget"`
+	Customer             Customer  `json:"customer"`
+}
+
 // TransactionData is the type for basic transaction data
 // Should we reuse Transaction model here?
 type TransactionData struct {
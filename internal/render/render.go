@@ -4,19 +4,24 @@ import (
 	"embed"
 	"fmt"
 	"html/template"
-	"log"
+	"io/fs"
+	"log/slog"
 	"net/http"
+	"path"
 	"strings"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/mlvieira/store/internal/logging"
 )
 
 // functions defines custom template functions.
 var functions = template.FuncMap{
-	"formatPrice": formatPrice,
+	"formatPrice": FormatPrice,
 	"concat":      concat,
 }
 
-// formatPrice formats an int64 (in cents) as a currency string or plain float string.
-func formatPrice(n int64, currencySymbol string) string {
+// FormatPrice formats an int64 (in cents) as a currency string or plain float string.
+func FormatPrice(n int64, currencySymbol string) string {
 	if currencySymbol != "" {
 		return fmt.Sprintf("%s%.2f", currencySymbol, float64(n)/100.0)
 	}
@@ -42,24 +47,38 @@ type Renderer struct {
 	Env           string
 	StripeKey     string
 	API           string
-	ErrorLog      *log.Logger
+	Gateway       string
+	Session       *scs.SessionManager
+	Logger        *slog.Logger
 }
 
 // NewRenderer initializes a Renderer with caching and configuration.
-func NewRenderer(env, stripeKey, api string, errorLog *log.Logger) *Renderer {
+func NewRenderer(env, stripeKey, api, gateway string, session *scs.SessionManager, logger *slog.Logger) *Renderer {
 	return &Renderer{
 		TemplateCache: make(map[string]*template.Template),
 		Env:           env,
 		StripeKey:     stripeKey,
 		API:           api,
-		ErrorLog:      errorLog,
+		Gateway:       gateway,
+		Session:       session,
+		Logger:        logger,
 	}
 }
 
-// AddDefaultData adds default data like Stripe key and API URL to templates.
+// AddDefaultData adds default data like Stripe key, API URL, gateway, the
+// request ID, the staff login state and one-time session messages to
+// templates.
 func (r *Renderer) AddDefaultData(td *TemplateData, req *http.Request) *TemplateData {
 	td.StripePublic = r.StripeKey
 	td.API = r.API
+	td.RequestID = logging.RequestID(req.Context())
+	td.Gateway = r.Gateway
+	td.Flash = r.Session.PopString(req.Context(), "flash")
+	td.Warning = r.Session.PopString(req.Context(), "warning")
+	td.Error = r.Session.PopString(req.Context(), "error")
+	if r.Session.Exists(req.Context(), "userID") {
+		td.IsAuthenticated = 1
+	}
 	return td
 }
 
@@ -77,7 +96,7 @@ func (r *Renderer) RenderTemplate(w http.ResponseWriter, req *http.Request, page
 	} else {
 		t, err = r.parseTemplate(partials, page, templateToRender)
 		if err != nil {
-			r.ErrorLog.Println("Error parsing template:", err)
+			logging.FromContext(req.Context(), r.Logger).Error("parsing template failed", "page", page, "error", err)
 			return err
 		}
 	}
@@ -90,10 +109,35 @@ func (r *Renderer) RenderTemplate(w http.ResponseWriter, req *http.Request, page
 
 	err = t.Execute(w, td)
 	if err != nil {
-		r.ErrorLog.Println("Error executing template:", err)
+		logging.FromContext(req.Context(), r.Logger).Error("executing template failed", "page", page, "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// CheckTemplates parses every page template with the layout and the
+// partials, without caching them, and returns the first error.
+func (r *Renderer) CheckTemplates() error {
+	pages, err := fs.Glob(templateFS, "templates/*.page.html")
+	if err != nil {
 		return err
 	}
 
+	partials, err := fs.Glob(templateFS, "templates/*.partials.html")
+	if err != nil {
+		return err
+	}
+
+	for _, page := range pages {
+		files := append([]string{"templates/base.layout.html"}, partials...)
+		files = append(files, page)
+
+		if _, err := template.New(path.Base(page)).Funcs(functions).ParseFS(templateFS, files...); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -121,7 +165,6 @@ func (r *Renderer) parseTemplate(partials []string, page, templateToRender strin
 	}
 
 	if err != nil {
-		r.ErrorLog.Println("Error parsing template:", err)
 		return nil, err
 	}
 
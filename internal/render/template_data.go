@@ -14,4 +14,7 @@ type TemplateData struct {
 	API             string
 	CSSVersion      string
 	StripePublic    string
+	Gateway         string
+	// RequestID is forwarded by the page's scripts when they call the API.
+	RequestID string
 }
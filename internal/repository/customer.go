@@ -2,7 +2,7 @@ package repository
 
 import (
 	"context"
-	"database/sql"
+	"strings"
 	"time"
 
 	"github.com/mlvieira/store/internal/models"
@@ -10,46 +10,143 @@ import (
 
 // customerRepo handles database operations for customer.
 type customerRepo struct {
-	db *sql.DB
+	db DBTX
 }
 
 // NewCustomerRepository creates a new customerRepository
-func NewCustomerRepository(db *sql.DB) CustomerRepository {
+func NewCustomerRepository(db DBTX) CustomerRepository {
 	return &customerRepo{db: db}
 }
 
-// InsertCustomer inserts a new customer into the database.
-func (r *customerRepo) InsertCustomer(ctx context.Context, customer models.Customer) (int, error) {
+// customerColumns lists the customer columns in the order scanCustomer expects.
+const customerColumns = `
+	id, first_name, last_name, email, stripe_customer_id, created_at, updated_at
+`
+
+// scanCustomer scans a row selected with customerColumns.
+func scanCustomer(row interface{ Scan(...any) error }, customer *models.Customer) error {
+	return row.Scan(
+		&customer.ID,
+		&customer.FirstName,
+		&customer.LastName,
+		&customer.Email,
+		&customer.StripeCustomerID,
+		&customer.CreatedAt,
+		&customer.UpdatedAt,
+	)
+}
+
+// normalizeEmail lowercases and trims an email so it matches the unique key.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// UpsertCustomer inserts a customer, or updates the name of the customer
+// with the same email, and returns the ID. An empty Stripe customer ID keeps
+// the stored one.
+func (r *customerRepo) UpsertCustomer(ctx context.Context, customer models.Customer) (int, error) {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	tx, err := r.db.Begin()
-	if err != nil {
-		return 0, err
-	}
-
 	stmt := `
-		INSERT INTO customers 
-		(first_name, last_name, email, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?)
+		INSERT INTO customers
+		(first_name, last_name, email, stripe_customer_id, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			id = LAST_INSERT_ID(id),
+			first_name = VALUES(first_name),
+			last_name = VALUES(last_name),
+			stripe_customer_id = IF(VALUES(stripe_customer_id) = '', stripe_customer_id, VALUES(stripe_customer_id)),
+			updated_at = VALUES(updated_at)
 	`
 
-	result, err := tx.ExecContext(ctx, stmt,
+	result, err := r.db.ExecContext(ctx, stmt,
 		customer.FirstName,
 		customer.LastName,
-		customer.Email,
+		normalizeEmail(customer.Email),
+		customer.StripeCustomerID,
 		time.Now(),
 		time.Now(),
 	)
 	if err != nil {
-		tx.Rollback()
-		return 0, err
-	}
-
-	if err = tx.Commit(); err != nil {
 		return 0, err
 	}
 
 	id, _ := result.LastInsertId()
 	return int(id), nil
 }
+
+// GetCustomerByID fetches a customer by its ID.
+func (r *customerRepo) GetCustomerByID(ctx context.Context, id int) (models.Customer, error) {
+	return r.getCustomer(ctx, "id = ?", id)
+}
+
+// GetCustomerByEmail fetches the customer with the given email.
+func (r *customerRepo) GetCustomerByEmail(ctx context.Context, email string) (models.Customer, error) {
+	return r.getCustomer(ctx, "email = ?", normalizeEmail(email))
+}
+
+// getCustomer fetches a single customer matching the where clause.
+func (r *customerRepo) getCustomer(ctx context.Context, where string, arg any) (models.Customer, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var customer models.Customer
+
+	stmt := `SELECT ` + customerColumns + ` FROM customers WHERE ` + where
+
+	row := r.db.QueryRowContext(ctx, stmt, arg)
+	if err := scanCustomer(row, &customer); err != nil {
+		return customer, err
+	}
+
+	return customer, nil
+}
+
+// ListCustomers returns a page of customers matching the filter, sorted by
+// name, and the total number of matches.
+func (r *customerRepo) ListCustomers(ctx context.Context, filter CustomerFilter) ([]models.Customer, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var c conditions
+	if filter.Search != "" {
+		prefix := escapeLike(filter.Search) + "%"
+		c.where = append(c.where, "(email LIKE ? OR first_name LIKE ? OR last_name LIKE ?)")
+		c.args = append(c.args, prefix, prefix, prefix)
+	}
+
+	from := ` FROM customers WHERE ` + c.sql()
+
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*)`+from, c.args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	limit, offset := filter.limitOffset()
+	stmt := `SELECT ` + customerColumns + from + `
+		ORDER BY last_name, first_name, id
+		LIMIT ? OFFSET ?
+	`
+
+	rows, err := r.db.QueryContext(ctx, stmt, append(c.args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	customers := []models.Customer{}
+	for rows.Next() {
+		var customer models.Customer
+		if err := scanCustomer(rows, &customer); err != nil {
+			return nil, 0, err
+		}
+		customers = append(customers, customer)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return customers, total, nil
+}
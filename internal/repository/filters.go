@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// Page selects a page of results. Pages start at 1.
+type Page struct {
+	Page     int
+	PageSize int
+}
+
+// Normalize fills in defaults and clamps the page size.
+func (p Page) Normalize() Page {
+	if p.Page < 1 {
+		p.Page = 1
+	}
+
+	switch {
+	case p.PageSize < 1:
+		p.PageSize = defaultPageSize
+	case p.PageSize > maxPageSize:
+		p.PageSize = maxPageSize
+	}
+
+	return p
+}
+
+// limitOffset returns the LIMIT and OFFSET for the page.
+func (p Page) limitOffset() (int, int) {
+	p = p.Normalize()
+	return p.PageSize, (p.Page - 1) * p.PageSize
+}
+
+// WidgetFilter narrows a widget listing. A nil Recurring lists both one-off
+// widgets and plans.
+type WidgetFilter struct {
+	Page
+	Recurring       *bool
+	IncludeArchived bool
+}
+
+// OrderFilter narrows an order listing. Zero values do not filter. The date
+// range covers From inclusive to To exclusive.
+type OrderFilter struct {
+	Page
+	From          time.Time
+	To            time.Time
+	StatusID      int
+	CustomerID    int
+	CustomerEmail string
+	WidgetID      int
+}
+
+// TransactionFilter narrows a transaction listing. Zero values do not
+// filter. The customer and widget filters match through the transaction's
+// order, so they leave out virtual terminal charges.
+type TransactionFilter struct {
+	Page
+	From          time.Time
+	To            time.Time
+	StatusID      int
+	CustomerEmail string
+	WidgetID      int
+}
+
+// SubscriptionFilter narrows a subscription listing. Zero values do not filter.
+type SubscriptionFilter struct {
+	Page
+	Status     string
+	CustomerID int
+}
+
+// CustomerFilter narrows a customer listing. Search matches the start of the
+// email, first name or last name.
+type CustomerFilter struct {
+	Page
+	Search string
+}
+
+// conditions collects the WHERE clauses and arguments of a listing query.
+type conditions struct {
+	where []string
+	args  []any
+}
+
+// add appends a clause when ok is true.
+func (c *conditions) add(ok bool, clause string, arg any) {
+	if ok {
+		c.where = append(c.where, clause)
+		c.args = append(c.args, arg)
+	}
+}
+
+// sql returns the clauses joined with AND.
+func (c *conditions) sql() string {
+	if len(c.where) == 0 {
+		return "1 = 1"
+	}
+	return strings.Join(c.where, " AND ")
+}
+
+// inList returns the placeholders and arguments of an IN list of ids.
+func inList(ids []int) (string, []any) {
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", "), args
+}
+
+// escapeLike escapes the LIKE wildcards in s so it matches literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
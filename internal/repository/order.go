@@ -2,7 +2,6 @@ package repository
 
 import (
 	"context"
-	"database/sql"
 	"time"
 
 	"github.com/mlvieira/store/internal/models"
@@ -10,11 +9,11 @@ import (
 
 // orderRepo handles database operations for order.
 type orderRepo struct {
-	db *sql.DB
+	db DBTX
 }
 
 // NewOrderRepository creates a new orderRepository
-func NewOrderRepository(db *sql.DB) OrderRepository {
+func NewOrderRepository(db DBTX) OrderRepository {
 	return &orderRepo{db: db}
 }
 
@@ -23,11 +22,6 @@ func (r *orderRepo) InsertOrder(ctx context.Context, order models.Order) (int, e
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	tx, err := r.db.Begin()
-	if err != nil {
-		return 0, err
-	}
-
 	stmt := `
 		INSERT INTO orders  
 		(widget_id, transaction_id, status_id, quantity, 
@@ -35,7 +29,7 @@ func (r *orderRepo) InsertOrder(ctx context.Context, order models.Order) (int, e
 		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	result, err := tx.ExecContext(ctx, stmt,
+	result, err := r.db.ExecContext(ctx, stmt,
 		order.WidgetID,
 		order.TransactionID,
 		order.StatusID,
@@ -46,14 +40,218 @@ func (r *orderRepo) InsertOrder(ctx context.Context, order models.Order) (int, e
 		order.CustomerID,
 	)
 	if err != nil {
-		tx.Rollback()
 		return 0, err
 	}
 
-	if err = tx.Commit(); err != nil {
+	id, _ := result.LastInsertId()
+	return int(id), nil
+}
+
+// UpdateStatusByPaymentIntent sets the status of the orders whose transaction
+// was paid with the given payment intent and returns the number of orders
+// changed. Orders whose status may not move to statusID, see
+// models.OrderStatusesBefore, are left alone.
+func (r *orderRepo) UpdateStatusByPaymentIntent(ctx context.Context, paymentIntent string, statusID int) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	before := models.OrderStatusesBefore(statusID)
+	if len(before) == 0 {
+		return 0, nil
+	}
+	in, args := inList(before)
+
+	stmt := `
+		UPDATE orders o
+		JOIN transactions t ON t.id = o.transaction_id
+		SET o.status_id = ?, o.updated_at = ?
+		WHERE t.payment_intent = ? AND o.status_id IN (` + in + `)
+	`
+
+	args = append([]any{statusID, time.Now(), paymentIntent}, args...)
+	result, err := r.db.ExecContext(ctx, stmt, args...)
+	if err != nil {
 		return 0, err
 	}
 
-	id, _ := result.LastInsertId()
-	return int(id), nil
+	rows, _ := result.RowsAffected()
+	return int(rows), nil
+}
+
+// orderColumns lists the order columns, with the order's widget, transaction,
+// customer and status, in the order scanOrder expects. Select them from
+// orderTables.
+const orderColumns = `
+	o.id, o.widget_id, o.transaction_id, o.customer_id, o.status_id,
+	o.quantity, o.amount, o.created_at, o.updated_at,
+	w.id, w.name, w.description, w.price, w.is_recurring, w.slug,
+	t.id, t.amount, t.currency, t.last_four, t.bank_return_code,
+	t.transaction_status_id, t.expiry_month, t.expiry_year,
+	t.payment_intent, t.payment_method, t.refunded_amount,
+	t.created_at, t.updated_at,
+	c.id, c.first_name, c.last_name, c.email,
+	s.id, s.name
+`
+
+// orderTables joins an order to its widget, transaction, customer and status.
+const orderTables = `
+	orders o
+	JOIN widgets w ON w.id = o.widget_id
+	JOIN transactions t ON t.id = o.transaction_id
+	JOIN customers c ON c.id = o.customer_id
+	JOIN statuses s ON s.id = o.status_id
+`
+
+// scanOrder scans a row selected with orderColumns.
+func scanOrder(row interface{ Scan(...any) error }, o *models.Order) error {
+	return row.Scan(
+		&o.ID,
+		&o.WidgetID,
+		&o.TransactionID,
+		&o.CustomerID,
+		&o.StatusID,
+		&o.Quantity,
+		&o.Amount,
+		&o.CreatedAt,
+		&o.UpdatedAt,
+		&o.Widget.ID,
+		&o.Widget.Name,
+		&o.Widget.Description,
+		&o.Widget.Price,
+		&o.Widget.IsRecurring,
+		&o.Widget.Slug,
+		&o.Transaction.ID,
+		&o.Transaction.Amount,
+		&o.Transaction.Currency,
+		&o.Transaction.LastFour,
+		&o.Transaction.BankReturnCode,
+		&o.Transaction.TransactionStatusID,
+		&o.Transaction.ExpiryMonth,
+		&o.Transaction.ExpiryYear,
+		&o.Transaction.PaymentIntent,
+		&o.Transaction.PaymentMethod,
+		&o.Transaction.RefundedAmount,
+		&o.Transaction.CreatedAt,
+		&o.Transaction.UpdatedAt,
+		&o.Customer.ID,
+		&o.Customer.FirstName,
+		&o.Customer.LastName,
+		&o.Customer.Email,
+		&o.Status.ID,
+		&o.Status.Name,
+	)
+}
+
+// GetOrderByID fetches an order by its ID.
+func (r *orderRepo) GetOrderByID(ctx context.Context, id int) (models.Order, error) {
+	return r.getOrder(ctx, "o.id = ?", id)
+}
+
+// GetOrderByPaymentIntent fetches the order paid with the given payment intent.
+func (r *orderRepo) GetOrderByPaymentIntent(ctx context.Context, paymentIntent string) (models.Order, error) {
+	return r.getOrder(ctx, "t.payment_intent = ?", paymentIntent)
+}
+
+// getOrder fetches a single order matching the where clause.
+func (r *orderRepo) getOrder(ctx context.Context, where string, arg any) (models.Order, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var order models.Order
+
+	stmt := `SELECT ` + orderColumns + ` FROM ` + orderTables + ` WHERE ` + where
+
+	row := r.db.QueryRowContext(ctx, stmt, arg)
+	if err := scanOrder(row, &order); err != nil {
+		return order, err
+	}
+
+	return order, nil
+}
+
+// UpdateStatus sets the status of an order.
+func (r *orderRepo) UpdateStatus(ctx context.Context, id int, statusID int) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	stmt := `
+		UPDATE orders
+		SET status_id = ?, updated_at = ?
+		WHERE id = ?
+	`
+
+	_, err := r.db.ExecContext(ctx, stmt, statusID, time.Now(), id)
+	return err
+}
+
+// ListOrders returns a page of orders matching the filter, newest first,
+// with their widget, transaction, customer and status, and the total number
+// of matches.
+func (r *orderRepo) ListOrders(ctx context.Context, filter OrderFilter) ([]models.Order, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var c conditions
+	c.add(!filter.From.IsZero(), "o.created_at >= ?", filter.From)
+	c.add(!filter.To.IsZero(), "o.created_at < ?", filter.To)
+	c.add(filter.StatusID != 0, "o.status_id = ?", filter.StatusID)
+	c.add(filter.CustomerID != 0, "o.customer_id = ?", filter.CustomerID)
+	c.add(filter.CustomerEmail != "", "c.email = ?", filter.CustomerEmail)
+	c.add(filter.WidgetID != 0, "o.widget_id = ?", filter.WidgetID)
+
+	from := ` FROM ` + orderTables + ` WHERE ` + c.sql()
+
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*)`+from, c.args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	limit, offset := filter.limitOffset()
+	stmt := `SELECT ` + orderColumns + from + `
+		ORDER BY o.created_at DESC, o.id DESC
+		LIMIT ? OFFSET ?
+	`
+
+	rows, err := r.db.QueryContext(ctx, stmt, append(c.args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	orders := []models.Order{}
+	for rows.Next() {
+		var o models.Order
+		if err := scanOrder(rows, &o); err != nil {
+			return nil, 0, err
+		}
+		orders = append(orders, o)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return orders, total, nil
+}
+
+// SalesSince returns the number of orders placed since the given time and
+// what they brought in, net of refunds.
+func (r *orderRepo) SalesSince(ctx context.Context, since time.Time) (int, int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	stmt := `
+		SELECT COUNT(*), COALESCE(SUM(t.amount - t.refunded_amount), 0)
+		FROM orders o
+		JOIN transactions t ON t.id = o.transaction_id
+		WHERE o.created_at >= ?
+	`
+
+	var count int
+	var revenue int64
+	if err := r.db.QueryRowContext(ctx, stmt, since).Scan(&count, &revenue); err != nil {
+		return 0, 0, err
+	}
+
+	return count, revenue, nil
 }
@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/mlvieira/store/internal/models"
+)
+
+// paymentMethodRepo handles database operations for saved payment methods.
+type paymentMethodRepo struct {
+	db DBTX
+}
+
+// NewPaymentMethodRepository creates a new PaymentMethodRepository
+func NewPaymentMethodRepository(db DBTX) PaymentMethodRepository {
+	return &paymentMethodRepo{db: db}
+}
+
+// paymentMethodColumns lists the payment method columns in the order
+// scanPaymentMethod expects.
+const paymentMethodColumns = `
+	id, customer_id, stripe_payment_method_id, brand, last_four,
+	expiry_month, expiry_year, is_default, created_at, updated_at
+`
+
+// scanPaymentMethod scans a row selected with paymentMethodColumns.
+func scanPaymentMethod(row interface{ Scan(...any) error }, pm *models.PaymentMethod) error {
+	return row.Scan(
+		&pm.ID,
+		&pm.CustomerID,
+		&pm.StripePaymentMethodID,
+		&pm.Brand,
+		&pm.LastFour,
+		&pm.ExpiryMonth,
+		&pm.ExpiryYear,
+		&pm.IsDefault,
+		&pm.CreatedAt,
+		&pm.UpdatedAt,
+	)
+}
+
+// SavePaymentMethod inserts a payment method, or updates the card details of
+// the one with the same Stripe ID, and returns the ID. The default flag is
+// left alone; use SetDefaultPaymentMethod to change it.
+func (r *paymentMethodRepo) SavePaymentMethod(ctx context.Context, pm models.PaymentMethod) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	stmt := `
+		INSERT INTO payment_methods
+		(customer_id, stripe_payment_method_id, brand, last_four, expiry_month, expiry_year, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			id = LAST_INSERT_ID(id),
+			brand = VALUES(brand),
+			last_four = VALUES(last_four),
+			expiry_month = VALUES(expiry_month),
+			expiry_year = VALUES(expiry_year),
+			updated_at = VALUES(updated_at)
+	`
+
+	result, err := r.db.ExecContext(ctx, stmt,
+		pm.CustomerID,
+		pm.StripePaymentMethodID,
+		pm.Brand,
+		pm.LastFour,
+		pm.ExpiryMonth,
+		pm.ExpiryYear,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, _ := result.LastInsertId()
+	return int(id), nil
+}
+
+// SetDefaultPaymentMethod makes the payment method the only default one of
+// the customer.
+func (r *paymentMethodRepo) SetDefaultPaymentMethod(ctx context.Context, customerID, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	stmt := `
+		UPDATE payment_methods
+		SET is_default = (id = ?), updated_at = ?
+		WHERE customer_id = ?
+	`
+
+	_, err := r.db.ExecContext(ctx, stmt, id, time.Now(), customerID)
+	return err
+}
+
+// GetPaymentMethodByID fetches a payment method by its ID.
+func (r *paymentMethodRepo) GetPaymentMethodByID(ctx context.Context, id int) (models.PaymentMethod, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var pm models.PaymentMethod
+
+	stmt := `SELECT ` + paymentMethodColumns + ` FROM payment_methods WHERE id = ?`
+
+	row := r.db.QueryRowContext(ctx, stmt, id)
+	if err := scanPaymentMethod(row, &pm); err != nil {
+		return pm, err
+	}
+
+	return pm, nil
+}
+
+// ListPaymentMethods returns the payment methods of a customer, the default
+// one first and then the most recently saved.
+func (r *paymentMethodRepo) ListPaymentMethods(ctx context.Context, customerID int) ([]models.PaymentMethod, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	stmt := `SELECT ` + paymentMethodColumns + `
+		FROM payment_methods
+		WHERE customer_id = ?
+		ORDER BY is_default DESC, id DESC
+	`
+
+	rows, err := r.db.QueryContext(ctx, stmt, customerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pms := []models.PaymentMethod{}
+	for rows.Next() {
+		var pm models.PaymentMethod
+		if err := scanPaymentMethod(rows, &pm); err != nil {
+			return nil, err
+		}
+		pms = append(pms, pm)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return pms, nil
+}
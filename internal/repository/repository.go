@@ -3,44 +3,146 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"time"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/mlvieira/store/internal/models"
 )
 
+var (
+	// ErrInsufficientStock is returned when a widget does not have enough inventory for a purchase.
+	ErrInsufficientStock = errors.New("insufficient stock")
+	// ErrDuplicate is returned when a write violates a unique key.
+	ErrDuplicate = errors.New("duplicate entry")
+)
+
+// mysqlErrDuplicateEntry is the MySQL error number for a unique key violation.
+const mysqlErrDuplicateEntry = 1062
+
+// duplicateErr translates unique key violations into ErrDuplicate.
+func duplicateErr(err error) error {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrDuplicateEntry {
+		return ErrDuplicate
+	}
+	return err
+}
+
 // WidgetRepository defines methods to interact with widget data.
 type WidgetRepository interface {
 	GetWidgetByID(ctx context.Context, id int) (models.Widget, error)
+	GetWidgetBySlug(ctx context.Context, slug string) (models.Widget, error)
+	ListWidgets(ctx context.Context, filter WidgetFilter) ([]models.Widget, int, error)
+	InsertWidget(ctx context.Context, widget models.Widget) (int, error)
+	UpdateWidget(ctx context.Context, widget models.Widget) error
+	ArchiveWidget(ctx context.Context, id int) error
+	AdjustInventory(ctx context.Context, id, delta int) error
+	DecrementInventory(ctx context.Context, id, quantity int) error
+	RestoreInventory(ctx context.Context, id, quantity int) error
 }
 
 // TransactionRepository defines methods to interact with transaction data.
 type TransactionRepository interface {
 	InsertTransaction(ctx context.Context, txn models.Transaction) (int, error)
+	UpdateStatusByPaymentIntent(ctx context.Context, paymentIntent string, statusID int) (int, error)
+	GetTransactionByID(ctx context.Context, id int) (models.Transaction, error)
+	LockTransactionByID(ctx context.Context, id int) (models.Transaction, error)
+	UpdateRefund(ctx context.Context, paymentIntent string, refundedAmount int64, statusID int) (int, error)
+	ListTransactions(ctx context.Context, filter TransactionFilter) ([]models.Transaction, int, error)
 }
 
 // OrderRepository defines methods to interact with order data.
 type OrderRepository interface {
 	InsertOrder(ctx context.Context, order models.Order) (int, error)
+	UpdateStatusByPaymentIntent(ctx context.Context, paymentIntent string, statusID int) (int, error)
+	GetOrderByID(ctx context.Context, id int) (models.Order, error)
+	GetOrderByPaymentIntent(ctx context.Context, paymentIntent string) (models.Order, error)
+	UpdateStatus(ctx context.Context, id int, statusID int) error
+	ListOrders(ctx context.Context, filter OrderFilter) ([]models.Order, int, error)
+	SalesSince(ctx context.Context, since time.Time) (int, int64, error)
 }
 
 // CustomerRepository defines methods to interact with customer data.
 type CustomerRepository interface {
-	InsertCustomer(ctx context.Context, customer models.Customer) (int, error)
+	UpsertCustomer(ctx context.Context, customer models.Customer) (int, error)
+	GetCustomerByID(ctx context.Context, id int) (models.Customer, error)
+	GetCustomerByEmail(ctx context.Context, email string) (models.Customer, error)
+	ListCustomers(ctx context.Context, filter CustomerFilter) ([]models.Customer, int, error)
+}
+
+// PaymentMethodRepository defines methods to interact with saved payment methods.
+type PaymentMethodRepository interface {
+	SavePaymentMethod(ctx context.Context, pm models.PaymentMethod) (int, error)
+	SetDefaultPaymentMethod(ctx context.Context, customerID, id int) error
+	GetPaymentMethodByID(ctx context.Context, id int) (models.PaymentMethod, error)
+	ListPaymentMethods(ctx context.Context, customerID int) ([]models.PaymentMethod, error)
+}
+
+// WebhookEventRepository defines methods to record processed webhook events.
+type WebhookEventRepository interface {
+	InsertEvent(ctx context.Context, eventID, eventType string) (bool, error)
+}
+
+// SubscriptionRepository defines methods to interact with subscription data.
+type SubscriptionRepository interface {
+	InsertSubscription(ctx context.Context, sub models.Subscription) (int, error)
+	GetSubscriptionByID(ctx context.Context, id int) (models.Subscription, error)
+	GetSubscriptionByStripeID(ctx context.Context, stripeID string) (models.Subscription, error)
+	UpdateSubscription(ctx context.Context, sub models.Subscription) error
+	ListSubscriptions(ctx context.Context, filter SubscriptionFilter) ([]models.Subscription, int, error)
+}
+
+// UserRepository defines methods to interact with user data.
+type UserRepository interface {
+	GetUserByID(ctx context.Context, id int) (models.User, error)
+	GetUserByEmail(ctx context.Context, email string) (models.User, error)
+	UpdatePassword(ctx context.Context, id int, hash string) error
+}
+
+// TokenRepository defines methods to interact with API tokens.
+type TokenRepository interface {
+	InsertToken(ctx context.Context, token models.Token) (int, error)
+	GetUserForToken(ctx context.Context, hash string) (models.User, error)
+	DeleteExpiredTokens(ctx context.Context) error
+	DeleteToken(ctx context.Context, hash string) error
+	DeleteTokensForUser(ctx context.Context, userID int) error
 }
 
 // Repositories aggregates repository interfaces.
 type Repositories struct {
-	Widget      WidgetRepository
-	Transaction TransactionRepository
-	Order       OrderRepository
-	Customer    CustomerRepository
+	Widget        WidgetRepository
+	Transaction   TransactionRepository
+	Order         OrderRepository
+	Customer      CustomerRepository
+	PaymentMethod PaymentMethodRepository
+	WebhookEvent  WebhookEventRepository
+	Subscription  SubscriptionRepository
+	User          UserRepository
+	Token         TokenRepository
+
+	// conn is nil when the repositories are bound to a transaction.
+	conn *sql.DB
 }
 
 // NewRepositories initializes repositories with a database connection.
 func NewRepositories(conn *sql.DB) *Repositories {
+	repos := newRepositories(conn)
+	repos.conn = conn
+	return repos
+}
+
+// newRepositories initializes repositories on top of a connection or transaction.
+func newRepositories(db DBTX) *Repositories {
 	return &Repositories{
-		Widget:      NewWidgetRepository(conn),
-		Transaction: NewTransactionRepository(conn),
-		Order:       NewOrderRepository(conn),
-		Customer:    NewCustomerRepository(conn),
+		Widget:        NewWidgetRepository(db),
+		Transaction:   NewTransactionRepository(db),
+		Order:         NewOrderRepository(db),
+		Customer:      NewCustomerRepository(db),
+		PaymentMethod: NewPaymentMethodRepository(db),
+		WebhookEvent:  NewWebhookEventRepository(db),
+		Subscription:  NewSubscriptionRepository(db),
+		User:          NewUserRepository(db),
+		Token:         NewTokenRepository(db),
 	}
 }
@@ -0,0 +1,185 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/mlvieira/store/internal/models"
+)
+
+// subscriptionRepo handles database operations for subscriptions.
+type subscriptionRepo struct {
+	db DBTX
+}
+
+// NewSubscriptionRepository creates a new SubscriptionRepository
+func NewSubscriptionRepository(db DBTX) SubscriptionRepository {
+	return &subscriptionRepo{db: db}
+}
+
+// InsertSubscription inserts a new subscription into the database.
+func (r *subscriptionRepo) InsertSubscription(ctx context.Context, sub models.Subscription) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	stmt := `
+		INSERT INTO subscriptions
+		(customer_id, widget_id, stripe_subscription_id, status,
+		 cancel_at_period_end, current_period_end, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	result, err := r.db.ExecContext(ctx, stmt,
+		sub.CustomerID,
+		sub.WidgetID,
+		sub.StripeSubscriptionID,
+		sub.Status,
+		sub.CancelAtPeriodEnd,
+		sub.CurrentPeriodEnd,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, _ := result.LastInsertId()
+	return int(id), nil
+}
+
+// subscriptionColumns lists the subscription columns, with the subscribed
+// widget and the customer, in the order scanSubscription expects. Select them
+// from subscriptionTables.
+const subscriptionColumns = `
+	s.id, s.customer_id, s.widget_id, s.stripe_subscription_id, s.status,
+	s.cancel_at_period_end, s.current_period_end, s.created_at, s.updated_at,
+	w.id, w.name, w.price, w.billing_interval, w.slug,
+	c.id, c.first_name, c.last_name, c.email
+`
+
+// subscriptionTables joins a subscription to its widget and customer.
+const subscriptionTables = `
+	subscriptions s
+	JOIN widgets w ON w.id = s.widget_id
+	JOIN customers c ON c.id = s.customer_id
+`
+
+// scanSubscription scans a row selected with subscriptionColumns.
+func scanSubscription(row interface{ Scan(...any) error }, sub *models.Subscription) error {
+	return row.Scan(
+		&sub.ID,
+		&sub.CustomerID,
+		&sub.WidgetID,
+		&sub.StripeSubscriptionID,
+		&sub.Status,
+		&sub.CancelAtPeriodEnd,
+		&sub.CurrentPeriodEnd,
+		&sub.CreatedAt,
+		&sub.UpdatedAt,
+		&sub.Widget.ID,
+		&sub.Widget.Name,
+		&sub.Widget.Price,
+		&sub.Widget.BillingInterval,
+		&sub.Widget.Slug,
+		&sub.Customer.ID,
+		&sub.Customer.FirstName,
+		&sub.Customer.LastName,
+		&sub.Customer.Email,
+	)
+}
+
+// GetSubscriptionByID fetches a subscription by its ID.
+func (r *subscriptionRepo) GetSubscriptionByID(ctx context.Context, id int) (models.Subscription, error) {
+	return r.getSubscription(ctx, "s.id = ?", id)
+}
+
+// GetSubscriptionByStripeID fetches a subscription by its Stripe subscription ID.
+func (r *subscriptionRepo) GetSubscriptionByStripeID(ctx context.Context, stripeID string) (models.Subscription, error) {
+	return r.getSubscription(ctx, "s.stripe_subscription_id = ?", stripeID)
+}
+
+// getSubscription fetches a single subscription matching the where clause.
+func (r *subscriptionRepo) getSubscription(ctx context.Context, where string, arg any) (models.Subscription, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var sub models.Subscription
+
+	stmt := `SELECT ` + subscriptionColumns + ` FROM ` + subscriptionTables + ` WHERE ` + where
+
+	row := r.db.QueryRowContext(ctx, stmt, arg)
+	if err := scanSubscription(row, &sub); err != nil {
+		return sub, err
+	}
+
+	return sub, nil
+}
+
+// UpdateSubscription saves the plan, status and billing period of a subscription.
+func (r *subscriptionRepo) UpdateSubscription(ctx context.Context, sub models.Subscription) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	stmt := `
+		UPDATE subscriptions
+		SET widget_id = ?, status = ?, cancel_at_period_end = ?,
+		    current_period_end = ?, updated_at = ?
+		WHERE id = ?
+	`
+
+	_, err := r.db.ExecContext(ctx, stmt,
+		sub.WidgetID,
+		sub.Status,
+		sub.CancelAtPeriodEnd,
+		sub.CurrentPeriodEnd,
+		time.Now(),
+		sub.ID,
+	)
+	return err
+}
+
+// ListSubscriptions returns a page of subscriptions matching the filter,
+// newest first, with their widget and customer, and the total number of
+// matches.
+func (r *subscriptionRepo) ListSubscriptions(ctx context.Context, filter SubscriptionFilter) ([]models.Subscription, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var c conditions
+	c.add(filter.Status != "", "s.status = ?", filter.Status)
+	c.add(filter.CustomerID != 0, "s.customer_id = ?", filter.CustomerID)
+
+	from := ` FROM ` + subscriptionTables + ` WHERE ` + c.sql()
+
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*)`+from, c.args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	limit, offset := filter.limitOffset()
+	stmt := `SELECT ` + subscriptionColumns + from + `
+		ORDER BY s.created_at DESC, s.id DESC
+		LIMIT ? OFFSET ?
+	`
+
+	rows, err := r.db.QueryContext(ctx, stmt, append(c.args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	subs := []models.Subscription{}
+	for rows.Next() {
+		var sub models.Subscription
+		if err := scanSubscription(rows, &sub); err != nil {
+			return nil, 0, err
+		}
+		subs = append(subs, sub)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return subs, total, nil
+}
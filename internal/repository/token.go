@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/mlvieira/store/internal/models"
+)
+
+// tokenRepo handles database operations for API tokens.
+type tokenRepo struct {
+	db DBTX
+}
+
+// NewTokenRepository creates a new tokenRepository
+func NewTokenRepository(db DBTX) TokenRepository {
+	return &tokenRepo{db: db}
+}
+
+// InsertToken stores the hash of a newly issued token.
+func (r *tokenRepo) InsertToken(ctx context.Context, token models.Token) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	stmt := `
+		INSERT INTO tokens
+		(user_id, token_hash, expiry, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+	`
+
+	result, err := r.db.ExecContext(ctx, stmt,
+		token.UserID,
+		token.Hash,
+		token.Expiry,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, _ := result.LastInsertId()
+	return int(id), nil
+}
+
+// GetUserForToken fetches the owner of an unexpired token by the token's hash.
+func (r *tokenRepo) GetUserForToken(ctx context.Context, hash string) (models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var user models.User
+
+	stmt := `
+		SELECT u.id, u.first_name, u.last_name, u.email, u.password,
+		       u.created_at, u.updated_at
+		FROM tokens t
+		INNER JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = ? AND t.expiry > ?
+	`
+
+	row := r.db.QueryRowContext(ctx, stmt, hash, time.Now())
+	err := row.Scan(
+		&user.ID,
+		&user.FirstName,
+		&user.LastName,
+		&user.Email,
+		&user.Password,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err != nil {
+		return user, err
+	}
+
+	return user, nil
+}
+
+// DeleteToken removes the token with the given hash.
+func (r *tokenRepo) DeleteToken(ctx context.Context, hash string) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	stmt := `DELETE FROM tokens WHERE token_hash = ?`
+
+	_, err := r.db.ExecContext(ctx, stmt, hash)
+	return err
+}
+
+// DeleteTokensForUser removes every token issued to a user.
+func (r *tokenRepo) DeleteTokensForUser(ctx context.Context, userID int) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	stmt := `DELETE FROM tokens WHERE user_id = ?`
+
+	_, err := r.db.ExecContext(ctx, stmt, userID)
+	return err
+}
+
+// DeleteExpiredTokens removes tokens past their expiry.
+func (r *tokenRepo) DeleteExpiredTokens(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	stmt := `DELETE FROM tokens WHERE expiry <= ?`
+
+	_, err := r.db.ExecContext(ctx, stmt, time.Now())
+	return err
+}
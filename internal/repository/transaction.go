@@ -2,7 +2,6 @@ package repository
 
 import (
 	"context"
-	"database/sql"
 	"time"
 
 	"github.com/mlvieira/store/internal/models"
@@ -10,24 +9,20 @@ import (
 
 // transactionRepo handles database operations for transactions.
 type transactionRepo struct {
-	db *sql.DB
+	db DBTX
 }
 
 // NewTransactionRepository creates a new TransactionRepository
-func NewTransactionRepository(db *sql.DB) TransactionRepository {
+func NewTransactionRepository(db DBTX) TransactionRepository {
 	return &transactionRepo{db: db}
 }
 
-// InsertTransaction inserts a new transaction into the database.
+// InsertTransaction inserts a new transaction into the database. It returns
+// ErrDuplicate when a transaction was already recorded for the payment intent.
 func (r *transactionRepo) InsertTransaction(ctx context.Context, txn models.Transaction) (int, error) {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	tx, err := r.db.Begin()
-	if err != nil {
-		return 0, err
-	}
-
 	stmt := `
 		INSERT INTO transactions 
 		(amount, currency, last_four, bank_return_code, 
@@ -36,7 +31,7 @@ func (r *transactionRepo) InsertTransaction(ctx context.Context, txn models.Tran
 		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	result, err := tx.ExecContext(ctx, stmt,
+	result, err := r.db.ExecContext(ctx, stmt,
 		txn.Amount,
 		txn.Currency,
 		txn.LastFour,
@@ -50,14 +45,194 @@ func (r *transactionRepo) InsertTransaction(ctx context.Context, txn models.Tran
 		txn.PaymentMethod,
 	)
 	if err != nil {
-		tx.Rollback()
+		return 0, duplicateErr(err)
+	}
+
+	id, _ := result.LastInsertId()
+	return int(id), nil
+}
+
+// UpdateStatusByPaymentIntent sets the status of the transactions paid with the
+// given payment intent and returns the number of rows changed. Transactions
+// whose status may not move to statusID, see
+// models.TransactionStatusesBefore, are left alone.
+func (r *transactionRepo) UpdateStatusByPaymentIntent(ctx context.Context, paymentIntent string, statusID int) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	before := models.TransactionStatusesBefore(statusID)
+	if len(before) == 0 {
+		return 0, nil
+	}
+	in, args := inList(before)
+
+	stmt := `
+		UPDATE transactions
+		SET transaction_status_id = ?, updated_at = ?
+		WHERE payment_intent = ? AND transaction_status_id IN (` + in + `)
+	`
+
+	args = append([]any{statusID, time.Now(), paymentIntent}, args...)
+	result, err := r.db.ExecContext(ctx, stmt, args...)
+	if err != nil {
 		return 0, err
 	}
 
-	if err = tx.Commit(); err != nil {
+	rows, _ := result.RowsAffected()
+	return int(rows), nil
+}
+
+// GetTransactionByID fetches a transaction by its ID.
+func (r *transactionRepo) GetTransactionByID(ctx context.Context, id int) (models.Transaction, error) {
+	return r.getTransaction(ctx, id, "")
+}
+
+// LockTransactionByID fetches a transaction by its ID and locks its row until
+// the SQL transaction the repository is bound to ends, so concurrent refunds
+// of the same payment run one after the other.
+func (r *transactionRepo) LockTransactionByID(ctx context.Context, id int) (models.Transaction, error) {
+	return r.getTransaction(ctx, id, " FOR UPDATE")
+}
+
+// getTransaction fetches a transaction by its ID, appending suffix to the query.
+func (r *transactionRepo) getTransaction(ctx context.Context, id int, suffix string) (models.Transaction, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var txn models.Transaction
+
+	stmt := `
+		SELECT id, amount, currency, last_four, bank_return_code,
+		       transaction_status_id, expiry_month, expiry_year,
+		       payment_intent, payment_method, refunded_amount,
+		       created_at, updated_at
+		FROM transactions
+		WHERE id = ?` + suffix
+
+	row := r.db.QueryRowContext(ctx, stmt, id)
+	if err := row.Scan(
+		&txn.ID,
+		&txn.Amount,
+		&txn.Currency,
+		&txn.LastFour,
+		&txn.BankReturnCode,
+		&txn.TransactionStatusID,
+		&txn.ExpiryMonth,
+		&txn.ExpiryYear,
+		&txn.PaymentIntent,
+		&txn.PaymentMethod,
+		&txn.RefundedAmount,
+		&txn.CreatedAt,
+		&txn.UpdatedAt,
+	); err != nil {
+		return txn, err
+	}
+
+	return txn, nil
+}
+
+// UpdateRefund records the total refunded amount and status of the
+// transactions paid with the given payment intent. The refunded amount only
+// grows, so a refund reported late does not undo a later one, and
+// transactions whose status may not move to statusID are left alone.
+func (r *transactionRepo) UpdateRefund(ctx context.Context, paymentIntent string, refundedAmount int64, statusID int) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	before := models.TransactionStatusesBefore(statusID)
+	if len(before) == 0 {
+		return 0, nil
+	}
+	in, args := inList(before)
+
+	stmt := `
+		UPDATE transactions
+		SET refunded_amount = ?, transaction_status_id = ?, updated_at = ?
+		WHERE payment_intent = ? AND refunded_amount <= ?
+		  AND transaction_status_id IN (` + in + `)
+	`
+
+	args = append([]any{refundedAmount, statusID, time.Now(), paymentIntent, refundedAmount}, args...)
+	result, err := r.db.ExecContext(ctx, stmt, args...)
+	if err != nil {
 		return 0, err
 	}
 
-	id, _ := result.LastInsertId()
-	return int(id), nil
+	rows, _ := result.RowsAffected()
+	return int(rows), nil
+}
+
+// ListTransactions returns a page of transactions matching the filter,
+// newest first, with their status, and the total number of matches.
+func (r *transactionRepo) ListTransactions(ctx context.Context, filter TransactionFilter) ([]models.Transaction, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var c conditions
+	c.add(!filter.From.IsZero(), "t.created_at >= ?", filter.From)
+	c.add(!filter.To.IsZero(), "t.created_at < ?", filter.To)
+	c.add(filter.StatusID != 0, "t.transaction_status_id = ?", filter.StatusID)
+	c.add(filter.CustomerEmail != "", "c.email = ?", filter.CustomerEmail)
+	c.add(filter.WidgetID != 0, "o.widget_id = ?", filter.WidgetID)
+
+	from := `
+		FROM transactions t
+		JOIN transaction_statuses ts ON ts.id = t.transaction_status_id
+		LEFT JOIN orders o ON o.transaction_id = t.id
+		LEFT JOIN customers c ON c.id = o.customer_id
+		WHERE ` + c.sql()
+
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(DISTINCT t.id) `+from, c.args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	limit, offset := filter.limitOffset()
+	stmt := `
+		SELECT DISTINCT t.id, t.amount, t.currency, t.last_four, t.bank_return_code,
+		       t.transaction_status_id, t.expiry_month, t.expiry_year,
+		       t.payment_intent, t.payment_method, t.refunded_amount,
+		       t.created_at, t.updated_at, ts.id, ts.name
+		` + from + `
+		ORDER BY t.created_at DESC, t.id DESC
+		LIMIT ? OFFSET ?
+	`
+
+	rows, err := r.db.QueryContext(ctx, stmt, append(c.args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	txns := []models.Transaction{}
+	for rows.Next() {
+		var txn models.Transaction
+		err := rows.Scan(
+			&txn.ID,
+			&txn.Amount,
+			&txn.Currency,
+			&txn.LastFour,
+			&txn.BankReturnCode,
+			&txn.TransactionStatusID,
+			&txn.ExpiryMonth,
+			&txn.ExpiryYear,
+			&txn.PaymentIntent,
+			&txn.PaymentMethod,
+			&txn.RefundedAmount,
+			&txn.CreatedAt,
+			&txn.UpdatedAt,
+			&txn.Status.ID,
+			&txn.Status.Name,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		txns = append(txns, txn)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return txns, total, nil
 }
@@ -0,0 +1,28 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/mlvieira/store/internal/models"
+)
+
+func TestInsertTransactionDuplicatePaymentIntent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions")).
+		WillReturnError(&mysql.MySQLError{Number: mysqlErrDuplicateEntry, Message: "Duplicate entry 'pi_1'"})
+
+	_, err = NewTransactionRepository(db).InsertTransaction(context.Background(), models.Transaction{PaymentIntent: "pi_1"})
+	if !errors.Is(err, ErrDuplicate) {
+		t.Errorf("InsertTransaction error = %v, want ErrDuplicate", err)
+	}
+}
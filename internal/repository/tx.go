@@ -0,0 +1,35 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+)
+
+// DBTX is the subset of *sql.DB and *sql.Tx used by the repositories, so the
+// same repository code runs standalone or inside a transaction.
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// WithTx runs fn with repositories bound to a single SQL transaction. The
+// transaction is committed when fn returns nil and rolled back otherwise.
+// Calling WithTx on repositories already bound to a transaction joins it.
+func (r *Repositories) WithTx(ctx context.Context, fn func(repos *Repositories) error) error {
+	if r.conn == nil {
+		return fn(r)
+	}
+
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(newRepositories(tx)); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/mlvieira/store/internal/models"
+)
+
+// userRepo handles database operations for users.
+type userRepo struct {
+	db DBTX
+}
+
+// NewUserRepository creates a new userRepository
+func NewUserRepository(db DBTX) UserRepository {
+	return &userRepo{db: db}
+}
+
+// GetUserByID fetches a user by its ID.
+func (r *userRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	return r.getUser(ctx, "id = ?", id)
+}
+
+// GetUserByEmail fetches a user by email address.
+func (r *userRepo) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	return r.getUser(ctx, "email = ?", email)
+}
+
+// getUser fetches the user matching the where clause.
+func (r *userRepo) getUser(ctx context.Context, where string, arg any) (models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var user models.User
+
+	stmt := `
+		SELECT id, first_name, last_name, email, password, created_at, updated_at
+		FROM users
+		WHERE ` + where
+
+	row := r.db.QueryRowContext(ctx, stmt, arg)
+	err := row.Scan(
+		&user.ID,
+		&user.FirstName,
+		&user.LastName,
+		&user.Email,
+		&user.Password,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err != nil {
+		return user, err
+	}
+
+	return user, nil
+}
+
+// UpdatePassword replaces a user's bcrypt password hash.
+func (r *userRepo) UpdatePassword(ctx context.Context, id int, hash string) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	stmt := `
+		UPDATE users
+		SET password = ?, updated_at = ?
+		WHERE id = ?
+	`
+
+	_, err := r.db.ExecContext(ctx, stmt, hash, time.Now(), id)
+	return err
+}
@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+// webhookEventRepo handles database operations for webhook events.
+type webhookEventRepo struct {
+	db DBTX
+}
+
+// NewWebhookEventRepository creates a new WebhookEventRepository
+func NewWebhookEventRepository(db DBTX) WebhookEventRepository {
+	return &webhookEventRepo{db: db}
+}
+
+// InsertEvent records a webhook event. It reports false when the event was
+// already recorded, so replays can be skipped. Inside a transaction, a
+// concurrent delivery of the same event waits for it to commit or roll back.
+func (r *webhookEventRepo) InsertEvent(ctx context.Context, eventID, eventType string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	stmt := `
+		INSERT IGNORE INTO webhook_events
+		(event_id, type, created_at, updated_at)
+		VALUES (?, ?, ?, ?)
+	`
+
+	result, err := r.db.ExecContext(ctx, stmt, eventID, eventType, time.Now(), time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
@@ -2,7 +2,6 @@ package repository
 
 import (
 	"context"
-	"database/sql"
 	"time"
 
 	"github.com/mlvieira/store/internal/models"
@@ -10,31 +9,25 @@ import (
 
 // widgetRepo handles database operations for widgets.
 type widgetRepo struct {
-	db *sql.DB
+	db DBTX
 }
 
 // NewWidgetRepository creates a new WidgetRepository
-func NewWidgetRepository(db *sql.DB) WidgetRepository {
+func NewWidgetRepository(db DBTX) WidgetRepository {
 	return &widgetRepo{db: db}
 }
 
-// GetWidgetByID fetches a widget by its ID.
-func (r *widgetRepo) GetWidgetByID(ctx context.Context, id int) (models.Widget, error) {
-	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
-	defer cancel()
+// widgetColumns lists the widget columns in the order scanWidget expects.
+const widgetColumns = `
+	id, name, description, inventory_level, price,
+	COALESCE(image, '') AS image, is_recurring, plan_id, billing_interval,
+	slug, archived,
+	created_at, updated_at
+`
 
-	var widget models.Widget
-
-	stmt := `
-		SELECT id, name, description, inventory_level, price, 
-		       COALESCE(image, '') AS image, is_recurring, plan_id,
-			   created_at, updated_at
-		FROM widgets
-		WHERE id = ?
-	`
-
-	row := r.db.QueryRowContext(ctx, stmt, id)
-	if err := row.Scan(
+// scanWidget scans a row selected with widgetColumns.
+func scanWidget(row interface{ Scan(...any) error }, widget *models.Widget) error {
+	return row.Scan(
 		&widget.ID,
 		&widget.Name,
 		&widget.Description,
@@ -43,11 +36,254 @@ func (r *widgetRepo) GetWidgetByID(ctx context.Context, id int) (models.Widget,
 		&widget.Image,
 		&widget.IsRecurring,
 		&widget.PlanID,
+		&widget.BillingInterval,
+		&widget.Slug,
+		&widget.Archived,
 		&widget.CreatedAt,
 		&widget.UpdatedAt,
-	); err != nil {
+	)
+}
+
+// GetWidgetByID fetches a widget by its ID.
+func (r *widgetRepo) GetWidgetByID(ctx context.Context, id int) (models.Widget, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var widget models.Widget
+
+	stmt := `SELECT ` + widgetColumns + ` FROM widgets WHERE id = ?`
+
+	row := r.db.QueryRowContext(ctx, stmt, id)
+	if err := scanWidget(row, &widget); err != nil {
+		return widget, err
+	}
+
+	return widget, nil
+}
+
+// GetWidgetBySlug fetches a widget by its URL slug.
+func (r *widgetRepo) GetWidgetBySlug(ctx context.Context, slug string) (models.Widget, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var widget models.Widget
+
+	stmt := `SELECT ` + widgetColumns + ` FROM widgets WHERE slug = ?`
+
+	row := r.db.QueryRowContext(ctx, stmt, slug)
+	if err := scanWidget(row, &widget); err != nil {
 		return widget, err
 	}
 
 	return widget, nil
 }
+
+// ListWidgets returns a page of widgets matching the filter and the total number of matches.
+func (r *widgetRepo) ListWidgets(ctx context.Context, filter WidgetFilter) ([]models.Widget, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var c conditions
+	c.add(filter.Recurring != nil, "is_recurring = ?", filter.Recurring)
+	c.add(!filter.IncludeArchived, "archived = ?", false)
+
+	from := ` FROM widgets WHERE ` + c.sql()
+
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*)`+from, c.args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	limit, offset := filter.limitOffset()
+	stmt := `SELECT ` + widgetColumns + from + ` ORDER BY id LIMIT ? OFFSET ?`
+
+	rows, err := r.db.QueryContext(ctx, stmt, append(c.args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	widgets := []models.Widget{}
+	for rows.Next() {
+		var widget models.Widget
+		if err := scanWidget(rows, &widget); err != nil {
+			return nil, 0, err
+		}
+		widgets = append(widgets, widget)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return widgets, total, nil
+}
+
+// InsertWidget inserts a new widget into the database.
+func (r *widgetRepo) InsertWidget(ctx context.Context, widget models.Widget) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	stmt := `
+		INSERT INTO widgets
+		(name, description, inventory_level, price, image,
+		 is_recurring, plan_id, billing_interval, slug,
+		 created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	result, err := r.db.ExecContext(ctx, stmt,
+		widget.Name,
+		widget.Description,
+		widget.InventoryLevel,
+		widget.Price,
+		widget.Image,
+		widget.IsRecurring,
+		widget.PlanID,
+		widget.BillingInterval,
+		widget.Slug,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return 0, duplicateErr(err)
+	}
+
+	id, _ := result.LastInsertId()
+	return int(id), nil
+}
+
+// UpdateWidget saves the editable fields of a widget. The inventory level is
+// left alone so sales made since the widget was read are kept; change it with
+// AdjustInventory.
+func (r *widgetRepo) UpdateWidget(ctx context.Context, widget models.Widget) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	stmt := `
+		UPDATE widgets
+		SET name = ?, description = ?, price = ?,
+		    image = ?, is_recurring = ?, plan_id = ?, billing_interval = ?,
+		    slug = ?, updated_at = ?
+		WHERE id = ?
+	`
+
+	_, err := r.db.ExecContext(ctx, stmt,
+		widget.Name,
+		widget.Description,
+		widget.Price,
+		widget.Image,
+		widget.IsRecurring,
+		widget.PlanID,
+		widget.BillingInterval,
+		widget.Slug,
+		time.Now(),
+		widget.ID,
+	)
+	return duplicateErr(err)
+}
+
+// ArchiveWidget hides a widget from the catalog. Widgets are never deleted
+// because orders keep referencing them.
+func (r *widgetRepo) ArchiveWidget(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	stmt := `
+		UPDATE widgets
+		SET archived = 1, updated_at = ?
+		WHERE id = ?
+	`
+
+	_, err := r.db.ExecContext(ctx, stmt, time.Now(), id)
+	return err
+}
+
+// DecrementInventory takes quantity units of a widget out of stock, failing
+// with ErrInsufficientStock when not enough are left. Recurring plan widgets
+// are not stocked and are left untouched.
+func (r *widgetRepo) DecrementInventory(ctx context.Context, id, quantity int) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var isRecurring bool
+
+	row := r.db.QueryRowContext(ctx, `SELECT is_recurring FROM widgets WHERE id = ?`, id)
+	if err := row.Scan(&isRecurring); err != nil {
+		return err
+	}
+
+	if isRecurring {
+		return nil
+	}
+
+	// The stock check lives in the WHERE clause so concurrent checkouts
+	// cannot both take the last unit.
+	stmt := `
+		UPDATE widgets
+		SET inventory_level = inventory_level - ?, updated_at = ?
+		WHERE id = ? AND inventory_level >= ?
+	`
+
+	result, err := r.db.ExecContext(ctx, stmt, quantity, time.Now(), id, quantity)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrInsufficientStock
+	}
+
+	return nil
+}
+
+// AdjustInventory adds delta units to the stock of a widget, or removes them
+// when delta is negative. It fails with ErrInsufficientStock when that would
+// leave less than nothing in stock.
+func (r *widgetRepo) AdjustInventory(ctx context.Context, id, delta int) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	stmt := `
+		UPDATE widgets
+		SET inventory_level = inventory_level + ?, updated_at = ?
+		WHERE id = ? AND inventory_level + ? >= 0
+	`
+
+	result, err := r.db.ExecContext(ctx, stmt, delta, time.Now(), id, delta)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrInsufficientStock
+	}
+
+	return nil
+}
+
+// RestoreInventory puts quantity units of a widget back in stock.
+// Recurring plan widgets are left untouched.
+func (r *widgetRepo) RestoreInventory(ctx context.Context, id, quantity int) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	stmt := `
+		UPDATE widgets
+		SET inventory_level = inventory_level + ?, updated_at = ?
+		WHERE id = ? AND is_recurring = 0
+	`
+
+	_, err := r.db.ExecContext(ctx, stmt, quantity, time.Now(), id)
+	return err
+}
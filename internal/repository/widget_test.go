@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestListWidgetsFilters(t *testing.T) {
+	recurring := true
+
+	tests := []struct {
+		name   string
+		filter WidgetFilter
+		where  string
+		args   []driver.Value
+	}{
+		{"catalog", WidgetFilter{}, "WHERE archived = ?", []driver.Value{false}},
+		{"plans", WidgetFilter{Recurring: &recurring}, "WHERE is_recurring = ? AND archived = ?", []driver.Value{true, false}},
+		{"admin", WidgetFilter{IncludeArchived: true}, "WHERE 1 = 1", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			count := append([]driver.Value{}, tt.args...)
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM widgets " + tt.where)).
+				WithArgs(count...).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+			mock.ExpectQuery(regexp.QuoteMeta("FROM widgets " + tt.where + " ORDER BY id LIMIT ? OFFSET ?")).
+				WithArgs(append(count, defaultPageSize, 0)...).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+			if _, _, err := NewWidgetRepository(db).ListWidgets(context.Background(), tt.filter); err != nil {
+				t.Fatal(err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
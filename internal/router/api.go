@@ -6,18 +6,55 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/mlvieira/store/internal/handlers"
 	"github.com/mlvieira/store/internal/handlers/api"
+	"github.com/mlvieira/store/internal/middleware"
 )
 
 // InitAPIRoutes sets up the routes and handlers for the API.
 func InitAPIRoutes(baseHandlers *handlers.Handlers) http.Handler {
-	mux := InitBaseRouter(true)
+	mux := InitBaseRouter(baseHandlers, baseHandlers.APIReadyz, true)
 
 	apiHandlers := api.NewAPIHandlers(baseHandlers)
 
 	mux.Route("/api", func(r chi.Router) {
+		r.Post("/authenticate", apiHandlers.Authenticate)
 		r.Post("/payment-intent", apiHandlers.GetPaymentIntent)
 		r.Get("/widget/{id}", apiHandlers.GetWidgetByID)
 		r.Post("/create-subscription", apiHandlers.CreateSubscription)
+		r.Post("/webhooks/stripe", apiHandlers.StripeWebhook)
+
+		// The fake gateway has no Stripe.js to confirm payments in the
+		// browser, so the pages confirm them here instead.
+		if baseHandlers.App.FakeGateway != nil {
+			r.Post("/fake/payment-intents/{id}/confirm", apiHandlers.ConfirmFakePaymentIntent)
+		}
+
+		requireUser := middleware.RequireUser(baseHandlers.App.Services.AuthService, baseHandlers.App.Logger)
+
+		r.With(requireUser).Get("/orders/{id}/invoice.pdf", apiHandlers.OrderInvoice)
+
+		r.Route("/terminal", func(r chi.Router) {
+			r.Use(requireUser)
+			r.Post("/payment-intent", apiHandlers.TerminalPaymentIntent)
+		})
+
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(requireUser)
+			r.Get("/orders", apiHandlers.ListOrders)
+			r.Post("/orders/{id}/refund", apiHandlers.RefundOrder)
+			r.Get("/transactions", apiHandlers.ListTransactions)
+
+			r.Get("/widgets", apiHandlers.ListWidgets)
+			r.Post("/widgets", apiHandlers.CreateWidget)
+			r.Put("/widgets/{id}", apiHandlers.UpdateWidget)
+			r.Delete("/widgets/{id}", apiHandlers.ArchiveWidget)
+
+			r.Route("/subscriptions/{id}", func(r chi.Router) {
+				r.Post("/cancel", apiHandlers.CancelSubscription)
+				r.Post("/pause", apiHandlers.PauseSubscription)
+				r.Post("/resume", apiHandlers.ResumeSubscription)
+				r.Post("/change-plan", apiHandlers.ChangeSubscriptionPlan)
+			})
+		})
 	})
 
 	return mux
@@ -0,0 +1,235 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mlvieira/store/internal/application"
+	"github.com/mlvieira/store/internal/cards"
+	"github.com/mlvieira/store/internal/config"
+	"github.com/mlvieira/store/internal/handlers"
+	"github.com/mlvieira/store/internal/logging"
+	"github.com/mlvieira/store/internal/models"
+	"github.com/mlvieira/store/internal/repository"
+	"github.com/mlvieira/store/internal/services"
+)
+
+// fakeStack is the API server running with -gateway=fake, and the gateway
+// of a web server sharing its state file.
+type fakeStack struct {
+	api        *httptest.Server
+	apiGateway *cards.FakeGateway
+	mock       sqlmock.Sqlmock
+	web        *cards.FakeGateway
+}
+
+func newFakeStack(t *testing.T) *fakeStack {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	state := filepath.Join(t.TempDir(), "fake-gateway.json")
+
+	apiGateway, err := cards.NewFakeGatewayFile(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	webGateway, err := cards.NewFakeGatewayFile(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repos := repository.NewRepositories(db)
+	app := &application.Application{
+		Config:       &config.Config{Gateway: "fake"},
+		Logger:       logging.New(io.Discard, slog.LevelError),
+		DB:           db,
+		Repositories: repos,
+		Services:     services.NewServices(repos, apiGateway, nil, nil, ""),
+		Gateway:      apiGateway,
+		FakeGateway:  apiGateway,
+	}
+
+	server := httptest.NewServer(InitAPIRoutes(handlers.NewHandlers(app)))
+	t.Cleanup(server.Close)
+
+	return &fakeStack{api: server, apiGateway: apiGateway, mock: mock, web: webGateway}
+}
+
+// post sends a JSON request to the API and decodes the JSON response into out.
+func (s *fakeStack) post(t *testing.T, path, token string, body, out any) int {
+	t.Helper()
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.api.URL+path, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		t.Fatalf("decoding %s response: %v", path, err)
+	}
+
+	return resp.StatusCode
+}
+
+// expectWidget makes the next widget lookup return an in-stock widget.
+func (s *fakeStack) expectWidget(id int, price int64) {
+	s.mock.ExpectQuery(regexp.QuoteMeta("FROM widgets WHERE id = ?")).
+		WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "name", "description", "inventory_level", "price", "image",
+			"is_recurring", "plan_id", "billing_interval", "slug", "archived",
+			"created_at", "updated_at",
+		}).AddRow(id, "Widget", "", 10, price, "", false, "", "", "widget", false, time.Now(), time.Now()))
+}
+
+// confirm confirms a payment intent the way the pages' fake Stripe.js does.
+func (s *fakeStack) confirm(t *testing.T, id, pm string) (int, map[string]any) {
+	t.Helper()
+
+	var out map[string]any
+	status := s.post(t, "/api/fake/payment-intents/"+id+"/confirm", "", map[string]string{"payment_method": pm}, &out)
+
+	return status, out
+}
+
+func TestFakeGatewayBuyOnceAcrossServers(t *testing.T) {
+	s := newFakeStack(t)
+	s.expectWidget(1, 1500)
+
+	var pi cards.PaymentIntent
+	if status := s.post(t, "/api/payment-intent", "", map[string]string{"product_id": "1", "currency": "brl", "email": "ana@example.com"}, &pi); status != http.StatusOK {
+		t.Fatalf("payment intent status = %d", status)
+	}
+
+	status, out := s.confirm(t, pi.ID, "pm_"+cards.FakeCardSuccess)
+	if status != http.StatusOK || out["status"] != "succeeded" {
+		t.Fatalf("confirm = %d %v, want 200 succeeded", status, out)
+	}
+
+	// The web server handles the form post that follows.
+	if _, err := s.web.RetrieveChargeID(pi.ID); err != nil {
+		t.Fatalf("web RetrieveChargeID: %v", err)
+	}
+
+	settled, err := s.web.RetrievePaymentIntent(pi.ID)
+	if err != nil {
+		t.Fatalf("web RetrievePaymentIntent: %v", err)
+	}
+	if settled.Amount != 1500 || settled.Status != cards.PaymentIntentSucceeded {
+		t.Errorf("payment intent = %d %s, want 1500 succeeded", settled.Amount, settled.Status)
+	}
+	if settled.Metadata["widget_id"] != "1" || settled.Metadata["email"] != "ana@example.com" {
+		t.Errorf("metadata = %v, want the widget and customer of the purchase", settled.Metadata)
+	}
+
+	if err := s.mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFakeGatewayBuyOnceDeclined(t *testing.T) {
+	s := newFakeStack(t)
+	s.expectWidget(1, 1500)
+
+	var pi cards.PaymentIntent
+	s.post(t, "/api/payment-intent", "", map[string]string{"product_id": "1", "currency": "brl", "email": "ana@example.com"}, &pi)
+
+	status, out := s.confirm(t, pi.ID, "pm_"+cards.FakeCardBalanceInsufficient)
+	if status != http.StatusPaymentRequired || out["message"] != "Insufficient balance" {
+		t.Fatalf("confirm = %d %v, want 402 Insufficient balance", status, out)
+	}
+
+	if _, err := s.web.RetrieveChargeID(pi.ID); err == nil {
+		t.Error("web found a charge for a declined payment")
+	}
+}
+
+func TestFakeGatewayTerminalAcrossServers(t *testing.T) {
+	s := newFakeStack(t)
+	s.mock.ExpectQuery(regexp.QuoteMeta("FROM tokens t")).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "first_name", "last_name", "email", "password", "created_at", "updated_at",
+		}).AddRow(1, "Staff", "User", "staff@example.com", "", time.Now(), time.Now()))
+
+	var pi cards.PaymentIntent
+	status := s.post(t, "/api/terminal/payment-intent", "TOKEN", map[string]any{"amount": 4200, "currency": "usd"}, &pi)
+	if status != http.StatusOK {
+		t.Fatalf("terminal payment intent status = %d", status)
+	}
+	if pi.Currency != models.Currency {
+		t.Errorf("currency = %s, want the store's %s whatever the client sends", pi.Currency, models.Currency)
+	}
+
+	if status, out := s.confirm(t, pi.ID, "pm_"+cards.FakeCardSuccess); status != http.StatusOK {
+		t.Fatalf("confirm = %d %v", status, out)
+	}
+
+	if _, err := s.web.RetrieveChargeID(pi.ID); err != nil {
+		t.Fatalf("web RetrieveChargeID: %v", err)
+	}
+}
+
+func TestFakeGatewaySavedCardAcrossServers(t *testing.T) {
+	s := newFakeStack(t)
+
+	// The API creates the customer when a plan is bought.
+	cust, _, err := s.apiGateway.CreateCustomer("pm_"+cards.FakeCardAuthenticationRequired, "ana@example.com")
+	if err != nil {
+		t.Fatalf("CreateCustomer: %v", err)
+	}
+
+	// The web server updates the card from the account area.
+	if _, _, err := s.web.UpdateCustomerPaymentMethod(cust.ID, "pm_"+cards.FakeCardAuthenticationRequired); err != nil {
+		t.Fatalf("web UpdateCustomerPaymentMethod: %v", err)
+	}
+
+	// The terminal charges the saved card, which asks for authentication.
+	pi, _, err := s.web.ChargeSavedMethod(cust.ID, "pm_"+cards.FakeCardAuthenticationRequired, "brl", 1000)
+	if err != cards.ErrAuthenticationRequired {
+		t.Fatalf("ChargeSavedMethod error = %v, want ErrAuthenticationRequired", err)
+	}
+
+	// The customer authenticates on /pay/authenticate, which confirms
+	// through the API.
+	if status, out := s.confirm(t, pi.ID, "pm_"+cards.FakeCardAuthenticationRequired); status != http.StatusOK {
+		t.Fatalf("confirm = %d %v", status, out)
+	}
+
+	settled, err := s.web.RetrievePaymentIntent(pi.ID)
+	if err != nil {
+		t.Fatalf("web RetrievePaymentIntent: %v", err)
+	}
+	if settled.Status != cards.PaymentIntentSucceeded {
+		t.Errorf("status = %s, want succeeded", settled.Status)
+	}
+}
@@ -0,0 +1,95 @@
+package router
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mlvieira/store/internal/application"
+	"github.com/mlvieira/store/internal/config"
+	"github.com/mlvieira/store/internal/handlers"
+	"github.com/mlvieira/store/internal/logging"
+	"github.com/mlvieira/store/internal/render"
+)
+
+func TestReadyzHidesFailureDetails(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	mock.ExpectPing().WillReturnError(errors.New("dial tcp 10.0.0.7:3306: connection refused"))
+
+	var logs bytes.Buffer
+	logger := logging.New(&logs, slog.LevelError)
+	app := &application.Application{
+		Config:   &config.Config{Gateway: "stripe"},
+		Logger:   logger,
+		DB:       db,
+		Renderer: render.NewRenderer("production", "", "", "stripe", nil, logger),
+	}
+
+	rec := httptest.NewRecorder()
+	h := handlers.NewHandlers(app)
+	InitBaseRouter(h, h.Readyz, false).
+		ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503", rec.Code)
+	}
+	if got := strings.TrimSpace(rec.Body.String()); got != `{"status":"unavailable"}` {
+		t.Errorf("body = %s, want only the status", got)
+	}
+
+	for _, detail := range []string{"10.0.0.7:3306", "STRIPE_SECRET_KEY"} {
+		if !strings.Contains(logs.String(), detail) {
+			t.Errorf("logs do not mention %q:\n%s", detail, logs.String())
+		}
+	}
+}
+
+func TestReadyzWebhookSecretOnlyOnAPI(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var logs bytes.Buffer
+	logger := logging.New(&logs, slog.LevelError)
+	cfg := &config.Config{Gateway: "stripe"}
+	cfg.Stripe.Key = "pk_test_1"
+	cfg.Stripe.Secret = "sk_test_1"
+	h := handlers.NewHandlers(&application.Application{
+		Config:   cfg,
+		Logger:   logger,
+		DB:       db,
+		Renderer: render.NewRenderer("production", "", "", "stripe", nil, logger),
+	})
+
+	tests := []struct {
+		server string
+		readyz http.HandlerFunc
+		want   int
+	}{
+		{"web", h.Readyz, http.StatusOK},
+		{"api", h.APIReadyz, http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		mock.ExpectPing()
+
+		rec := httptest.NewRecorder()
+		InitBaseRouter(h, tt.readyz, false).
+			ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+		if rec.Code != tt.want {
+			t.Errorf("%s /readyz = %d, want %d without STRIPE_WEBHOOK_SECRET\n%s", tt.server, rec.Code, tt.want, logs.String())
+		}
+	}
+}
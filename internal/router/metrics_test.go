@@ -0,0 +1,44 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mlvieira/store/internal/application"
+	"github.com/mlvieira/store/internal/config"
+	"github.com/mlvieira/store/internal/handlers"
+)
+
+func TestMetricsRequiresToken(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+		auth  string
+		want  int
+	}{
+		{"not configured", "", "Bearer ", http.StatusNotFound},
+		{"no token", "scrape-secret", "", http.StatusUnauthorized},
+		{"wrong token", "scrape-secret", "Bearer guess", http.StatusUnauthorized},
+		{"right token", "scrape-secret", "Bearer scrape-secret", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &application.Application{Config: &config.Config{MetricsToken: tt.token}}
+
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+
+			rec := httptest.NewRecorder()
+			h := handlers.NewHandlers(app)
+			InitBaseRouter(h, h.Readyz, false).ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
@@ -6,32 +6,51 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/cors"
 	"github.com/mlvieira/store/internal/application"
+	"github.com/mlvieira/store/internal/handlers"
+	"github.com/mlvieira/store/internal/logging"
+	"github.com/mlvieira/store/internal/metrics"
+	"github.com/mlvieira/store/internal/middleware"
 	"github.com/mlvieira/store/internal/shared"
 )
 
-// InitBaseRouter initializes a base router with common middleware.
-func InitBaseRouter(enableCORS bool) *chi.Mux {
+// InitBaseRouter initializes a base router with the middleware common to
+// both servers, which tags every request with an ID and records its
+// metrics, the health, readiness and version endpoints probed by the load
+// balancer and the Prometheus metrics endpoint, which needs the configured
+// metrics token. readyz serves the readiness endpoint of the server.
+func InitBaseRouter(baseHandlers *handlers.Handlers, readyz http.HandlerFunc, enableCORS bool) *chi.Mux {
 	mux := chi.NewRouter()
+	mux.Use(middleware.RequestID)
+	mux.Use(middleware.Metrics)
 
 	if enableCORS {
 		mux.Use(cors.Handler(cors.Options{
 			AllowedOrigins:   []string{"https://*", "http://*"},
 			AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-			AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
+			AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", logging.RequestIDHeader},
+			ExposedHeaders:   []string{logging.RequestIDHeader},
 			AllowCredentials: false,
 			MaxAge:           300,
 		}))
 	}
 
+	mux.Get("/healthz", baseHandlers.Healthz)
+	mux.Get("/readyz", readyz)
+	mux.Get("/version", baseHandlers.Version)
+	mux.With(middleware.RequireToken(baseHandlers.App.Config.MetricsToken)).Handle("/metrics", metrics.Handler())
+
 	return mux
 }
 
-// Serve initializes and starts the HTTP server using the shared Serve logic.
+// Serve initializes and starts the HTTP server using the shared Serve logic,
+// running the application's shutdown hooks once it stops.
 func Serve(app *application.Application, router http.Handler) error {
 	return shared.Serve(
 		app.Config.Port,
 		app.Config.Env,
 		router,
-		app.InfoLog,
+		app.Logger,
+		app.Config.ShutdownTimeout,
+		app.ShutdownHooks...,
 	)
 }
@@ -0,0 +1,104 @@
+package router
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mlvieira/store/internal/application"
+	"github.com/mlvieira/store/internal/cards"
+	"github.com/mlvieira/store/internal/config"
+	"github.com/mlvieira/store/internal/handlers"
+	"github.com/mlvieira/store/internal/logging"
+	"github.com/mlvieira/store/internal/repository"
+	"github.com/mlvieira/store/internal/services"
+)
+
+// subscribingGateway is the fake gateway recording the subscriptions it
+// creates.
+type subscribingGateway struct {
+	*cards.FakeGateway
+	subscriptions []string
+}
+
+func (g *subscribingGateway) SubscribeToPlan(customerID, plan, email, last4, cardType string) (*cards.Subscription, error) {
+	sub, err := g.FakeGateway.SubscribeToPlan(customerID, plan, email, last4, cardType)
+	if err == nil {
+		g.subscriptions = append(g.subscriptions, sub.ID)
+	}
+	return sub, err
+}
+
+func TestCreateSubscriptionCancelsUnsavedSubscription(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	gateway := &subscribingGateway{FakeGateway: cards.NewFakeGateway()}
+	repos := repository.NewRepositories(db)
+	app := &application.Application{
+		Config:       &config.Config{Gateway: "fake"},
+		Logger:       logging.New(io.Discard, slog.LevelError),
+		DB:           db,
+		Repositories: repos,
+		Services:     services.NewServices(repos, gateway, nil, nil, ""),
+		Gateway:      gateway,
+	}
+	server := httptest.NewServer(InitAPIRoutes(handlers.NewHandlers(app)))
+	defer server.Close()
+	s := &fakeStack{api: server, mock: mock}
+
+	// The subscription is created, then saving the checkout fails. The
+	// transaction is recorded in the store's currency, not the client's.
+	mock.ExpectQuery(regexp.QuoteMeta("FROM widgets WHERE id = ?")).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "name", "description", "inventory_level", "price", "image",
+			"is_recurring", "plan_id", "billing_interval", "slug", "archived",
+			"created_at", "updated_at",
+		}).AddRow(2, "Bronze Plan", "", 0, 2000, "", true, "price_bronze", "month", "bronze", false, time.Now(), time.Now()))
+	mock.ExpectQuery(regexp.QuoteMeta("FROM customers WHERE email = ?")).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT is_recurring FROM widgets WHERE id = ?")).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"is_recurring"}).AddRow(true))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO customers")).
+		WillReturnResult(sqlmock.NewResult(7, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions")).
+		WithArgs(int64(2000), "brl", "4242", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnError(errors.New("connection lost"))
+	mock.ExpectRollback()
+
+	var out map[string]any
+	status := s.post(t, "/api/create-subscription", "", map[string]any{
+		"product_id":     "2",
+		"currency":       "usd",
+		"payment_method": "pm_" + cards.FakeCardSuccess,
+		"email":          "ana@example.com",
+		"last_four":      "4242",
+	}, &out)
+	if status != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", status)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(gateway.subscriptions) != 1 {
+		t.Fatalf("created %d subscriptions, want 1", len(gateway.subscriptions))
+	}
+	if _, err := gateway.PauseSubscription(gateway.subscriptions[0]); err == nil {
+		t.Error("the subscription of the unsaved checkout is still active")
+	}
+}
@@ -11,33 +11,93 @@ import (
 )
 
 // InitWebRoutes sets up the routes and handlers for the web application.
+// The pages are mounted under the base router so the probes it serves do
+// not go through the session middleware.
 func InitWebRoutes(baseHandlers *handlers.Handlers, scs *scs.SessionManager) http.Handler {
-	mux := InitBaseRouter(false)
+	base := InitBaseRouter(baseHandlers, baseHandlers.Readyz, false)
 
+	mux := chi.NewRouter()
 	mux.Use(middleware.MiddlewareSession(scs))
 
 	webHandlers := web.NewWebHandlers(baseHandlers)
+	staffAuth := middleware.Auth(scs, baseHandlers.App.Services.AuthService, baseHandlers.App.Logger)
 
 	mux.Get("/", webHandlers.Homepage)
 	mux.Get("/widget/{id}", webHandlers.ChargeOnce)
 
 	mux.Route("/plans", func(r chi.Router) {
-		r.Get("/bronze", webHandlers.BronzePlan)
+		r.Get("/{slug}", webHandlers.Plan)
 	})
+	mux.Handle("/bronze-plan", http.RedirectHandler("/plans/bronze-plan", http.StatusMovedPermanently))
 
 	mux.Route("/payment", func(r chi.Router) {
 		r.Post("/", webHandlers.PaymentSucceeded)
+		r.Post("/subscription", webHandlers.SubscriptionSucceeded)
 		r.Get("/receipt", webHandlers.Receipt)
 	})
 
+	mux.Get("/pay/authenticate", webHandlers.AuthenticatePayment)
+	mux.Post("/pay/authenticate", webHandlers.PostAuthenticatePayment)
+
+	mux.Get("/login", webHandlers.LoginPage)
+	mux.Post("/login", webHandlers.PostLoginPage)
+	mux.Post("/logout", webHandlers.Logout)
+	mux.Get("/forgot-password", webHandlers.ForgotPassword)
+	mux.Post("/forgot-password", webHandlers.PostForgotPassword)
+	mux.Get("/reset-password", webHandlers.ResetPassword)
+	mux.Post("/reset-password", webHandlers.PostResetPassword)
+
+	mux.Route("/account", func(r chi.Router) {
+		r.Get("/sign-in", webHandlers.AccountSignIn)
+		r.Post("/sign-in", webHandlers.PostAccountSignIn)
+		r.Get("/verify", webHandlers.AccountVerify)
+
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.CustomerAuth(scs))
+			r.Get("/", webHandlers.Account)
+			r.Post("/sign-out", webHandlers.AccountSignOut)
+			r.Get("/orders/{id}/invoice.pdf", webHandlers.AccountInvoice)
+			r.Post("/subscriptions/{id}/cancel", webHandlers.AccountCancelSubscription)
+			r.Get("/card", webHandlers.AccountCard)
+			r.Post("/card", webHandlers.PostAccountCard)
+		})
+	})
+
 	mux.Route("/terminal", func(r chi.Router) {
+		r.Use(staffAuth)
 		r.Get("/", webHandlers.VirtualTerminal)
 		r.Post("/payment", webHandlers.PaymentVirtualTerminal)
+		r.Post("/saved-card", webHandlers.ChargeSavedCard)
 		r.Get("/receipt", webHandlers.ReceiptVirtualTerminal)
 	})
 
+	mux.Route("/admin", func(r chi.Router) {
+		r.Use(staffAuth)
+		r.Get("/", webHandlers.AdminDashboard)
+
+		r.Get("/orders", webHandlers.AdminOrders)
+		r.Get("/orders/{id}", webHandlers.AdminOrder)
+		r.Post("/orders/{id}/refund", webHandlers.AdminRefundOrder)
+
+		r.Get("/subscriptions", webHandlers.AdminSubscriptions)
+		r.Get("/subscriptions/{id}", webHandlers.AdminSubscription)
+		r.Post("/subscriptions/{id}/cancel", webHandlers.AdminCancelSubscription)
+
+		r.Get("/customers", webHandlers.AdminCustomers)
+		r.Get("/customers/{id}", webHandlers.AdminCustomer)
+
+		r.Get("/widgets", webHandlers.AdminWidgets)
+		r.Get("/widgets/new", webHandlers.AdminNewWidget)
+		r.Post("/widgets", webHandlers.AdminSaveWidget)
+		r.Get("/widgets/{id}", webHandlers.AdminEditWidget)
+		r.Post("/widgets/{id}", webHandlers.AdminSaveWidget)
+		r.Post("/widgets/{id}/archive", webHandlers.AdminArchiveWidget)
+	})
+
 	fileServer := http.FileServer(http.Dir("./static"))
 	mux.Handle("/static/*", http.StripPrefix("/static/", fileServer))
 
-	return mux
+	base.Mount("/", mux)
+
+	return base
 }
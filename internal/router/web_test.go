@@ -0,0 +1,60 @@
+package router
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alexedwards/scs/v2"
+	"github.com/mlvieira/store/internal/application"
+	"github.com/mlvieira/store/internal/cards"
+	"github.com/mlvieira/store/internal/config"
+	"github.com/mlvieira/store/internal/handlers"
+	"github.com/mlvieira/store/internal/logging"
+	"github.com/mlvieira/store/internal/repository"
+	"github.com/mlvieira/store/internal/services"
+)
+
+func TestLegacyPlanLinksRedirect(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	gateway := cards.NewFakeGateway()
+	repos := repository.NewRepositories(db)
+	session := scs.New()
+	app := &application.Application{
+		Config:       &config.Config{Gateway: "fake"},
+		Logger:       logging.New(io.Discard, slog.LevelError),
+		DB:           db,
+		Repositories: repos,
+		Session:      session,
+		Services:     services.NewServices(repos, gateway, nil, nil, ""),
+		Gateway:      gateway,
+	}
+	mux := InitWebRoutes(handlers.NewHandlers(app), session)
+
+	// No widget has the old bronze slug any more.
+	mock.ExpectQuery(regexp.QuoteMeta("FROM widgets WHERE slug = ?")).
+		WithArgs("bronze").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	for _, path := range []string{"/bronze-plan", "/plans/bronze"} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+		if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != "/plans/bronze-plan" {
+			t.Errorf("GET %s = %d to %q, want 301 to /plans/bronze-plan", path, rec.Code, rec.Header().Get("Location"))
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,24 @@
+package router
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestStripeWebhookRejectedWithoutSecret(t *testing.T) {
+	s := newFakeStack(t)
+
+	// An unset secret must not verify an unsigned delivery.
+	var out map[string]any
+	status := s.post(t, "/api/webhooks/stripe", "", map[string]any{
+		"id":   "evt_1",
+		"type": "payment_intent.succeeded",
+	}, &out)
+	if status != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503", status)
+	}
+
+	if err := s.mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,39 @@
+package router
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestGetWidgetHidesArchived(t *testing.T) {
+	for _, tt := range []struct {
+		archived bool
+		want     int
+	}{
+		{false, http.StatusOK},
+		{true, http.StatusNotFound},
+	} {
+		s := newFakeStack(t)
+		s.mock.ExpectQuery(regexp.QuoteMeta("FROM widgets WHERE id = ?")).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{
+				"id", "name", "description", "inventory_level", "price", "image",
+				"is_recurring", "plan_id", "billing_interval", "slug", "archived",
+				"created_at", "updated_at",
+			}).AddRow(1, "Widget", "", 10, 1500, "", false, "", "", "widget", tt.archived, time.Now(), time.Now()))
+
+		resp, err := http.Get(s.api.URL + "/api/widget/1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != tt.want {
+			t.Errorf("archived %v: status = %d, want %d", tt.archived, resp.StatusCode, tt.want)
+		}
+	}
+}
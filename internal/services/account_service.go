@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mlvieira/store/internal/cards"
+	"github.com/mlvieira/store/internal/invoice"
+	"github.com/mlvieira/store/internal/mailer"
+	"github.com/mlvieira/store/internal/models"
+	"github.com/mlvieira/store/internal/repository"
+	"github.com/mlvieira/store/internal/urlsigner"
+)
+
+// ErrNoStripeCustomer is returned when managing the card of a customer who
+// never saved one with Stripe.
+var ErrNoStripeCustomer = errors.New("customer has no saved card")
+
+// signInLinkTTL is how long a customer sign-in link stays valid.
+const signInLinkTTL = 15 * time.Minute
+
+// AccountService serves the customer account area: sign-in links, order
+// history, invoices, subscriptions and the saved card.
+type AccountService struct {
+	customers      repository.CustomerRepository
+	orders         *OrderService
+	subscriptions  *SubscriptionService
+	paymentMethods *PaymentMethodService
+	gateway        cards.PaymentGateway
+	signer         *urlsigner.Signer
+	mail           *mailer.Mailer
+	frontEnd       string
+}
+
+// NewAccountService initializes a new AccountService instance.
+func NewAccountService(customers repository.CustomerRepository, orders *OrderService, subscriptions *SubscriptionService, paymentMethods *PaymentMethodService, gateway cards.PaymentGateway, signer *urlsigner.Signer, mail *mailer.Mailer, frontEnd string) *AccountService {
+	return &AccountService{
+		customers:      customers,
+		orders:         orders,
+		subscriptions:  subscriptions,
+		paymentMethods: paymentMethods,
+		gateway:        gateway,
+		signer:         signer,
+		mail:           mail,
+		frontEnd:       strings.TrimRight(frontEnd, "/"),
+	}
+}
+
+// SendSignInLink emails a signed sign-in link to the customer with the given
+// email. Unknown emails are ignored so the form does not reveal who bought
+// from the store.
+func (s *AccountService) SendSignInLink(ctx context.Context, email string) error {
+	customer, err := s.customers.GetCustomerByEmail(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	link, err := s.signer.Sign(fmt.Sprintf("%s/account/verify?customer=%d", s.frontEnd, customer.ID), signInLinkTTL)
+	if err != nil {
+		return err
+	}
+
+	return s.mail.Send(ctx, customer.Email, mailer.TemplateAccountSignIn, mailer.AccountSignIn{
+		FirstName: customer.FirstName,
+		Link:      link,
+	})
+}
+
+// VerifySignInLink checks the signature and expiry of a sign-in link and
+// returns the ID of the customer it was issued to.
+func (s *AccountService) VerifySignInLink(link string) (int, error) {
+	if err := s.signer.Verify(link); err != nil {
+		return 0, err
+	}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		return 0, urlsigner.ErrInvalidSignature
+	}
+
+	id, err := strconv.Atoi(u.Query().Get("customer"))
+	if err != nil {
+		return 0, urlsigner.ErrInvalidSignature
+	}
+
+	return id, nil
+}
+
+// Invoice returns the invoice of one of the customer's orders. Orders of
+// other customers are reported as sql.ErrNoRows.
+func (s *AccountService) Invoice(ctx context.Context, customerID, orderID int) (invoice.Invoice, error) {
+	inv, err := s.orders.Invoice(ctx, orderID)
+	if err != nil {
+		return inv, err
+	}
+
+	if inv.Order.CustomerID != customerID {
+		return invoice.Invoice{}, sql.ErrNoRows
+	}
+
+	return inv, nil
+}
+
+// CancelSubscription cancels one of the customer's subscriptions at the end
+// of the period already paid for. Subscriptions of other customers are
+// reported as sql.ErrNoRows.
+func (s *AccountService) CancelSubscription(ctx context.Context, customerID, subscriptionID int) (models.Subscription, error) {
+	sub, err := s.subscriptions.GetSubscription(ctx, subscriptionID)
+	if err != nil {
+		return sub, err
+	}
+
+	if sub.CustomerID != customerID {
+		return models.Subscription{}, sql.ErrNoRows
+	}
+
+	return s.subscriptions.Cancel(ctx, sub.ID, true)
+}
+
+// SavedCards returns the cards saved for the customer, the one their
+// subscriptions are billed to first.
+func (s *AccountService) SavedCards(ctx context.Context, customer models.Customer) ([]models.PaymentMethod, error) {
+	return s.paymentMethods.ListPaymentMethods(ctx, customer.ID)
+}
+
+// NewCardSetup starts a SetupIntent to collect a replacement card for the customer.
+func (s *AccountService) NewCardSetup(customer models.Customer) (*cards.SetupIntent, string, error) {
+	if customer.StripeCustomerID == "" {
+		return nil, "", ErrNoStripeCustomer
+	}
+
+	return s.gateway.CreateSetupIntent(customer.StripeCustomerID, "")
+}
+
+// UpdateCard makes a card confirmed through NewCardSetup the one the
+// customer's subscriptions are billed to and saves it as their default card.
+// It returns a user-facing message when the gateway rejects the card.
+func (s *AccountService) UpdateCard(ctx context.Context, customer models.Customer, pm string) (string, error) {
+	if customer.StripeCustomerID == "" {
+		return "", ErrNoStripeCustomer
+	}
+
+	if _, msg, err := s.gateway.UpdateCustomerPaymentMethod(customer.StripeCustomerID, pm); err != nil {
+		return msg, err
+	}
+
+	card, err := s.gateway.GetPaymentMethod(pm)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = s.paymentMethods.SaveDefault(ctx, paymentMethodFromGateway(customer.ID, card))
+	return "", err
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/mlvieira/store/internal/models"
+	"github.com/mlvieira/store/internal/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrInvalidCredentials is returned when an email and password do not match a user.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrInvalidToken is returned when a token is unknown or expired.
+	ErrInvalidToken = errors.New("invalid or expired token")
+	// ErrSessionExpired is returned when a staff session was opened with a
+	// password that has since changed, or by a user that no longer exists.
+	ErrSessionExpired = errors.New("session expired")
+)
+
+// tokenTTL is how long an issued API token stays valid.
+const tokenTTL = 24 * time.Hour
+
+// dummyPasswordHash is compared against when the email is unknown, so a
+// failed login takes as long whether or not the user exists.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+
+// AuthService checks staff credentials and issues, validates and revokes
+// the API tokens of the back office.
+type AuthService struct {
+	users  repository.UserRepository
+	tokens repository.TokenRepository
+}
+
+// NewAuthService initializes a new AuthService instance.
+func NewAuthService(users repository.UserRepository, tokens repository.TokenRepository) *AuthService {
+	return &AuthService{users: users, tokens: tokens}
+}
+
+// Authenticate checks an email and password and returns the matching user.
+func (s *AuthService) Authenticate(ctx context.Context, email, password string) (models.User, error) {
+	user, err := s.users.GetUserByEmail(ctx, strings.TrimSpace(email))
+	if errors.Is(err, sql.ErrNoRows) {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return user, ErrInvalidCredentials
+	}
+	if err != nil {
+		return user, err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return models.User{}, ErrInvalidCredentials
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+
+	return user, nil
+}
+
+// IssueToken creates a new API token for the user. The plain text token is
+// only available on the returned value.
+func (s *AuthService) IssueToken(ctx context.Context, userID int) (models.Token, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return models.Token{}, err
+	}
+
+	plainText := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(random)
+
+	token := models.Token{
+		UserID:    userID,
+		PlainText: plainText,
+		Hash:      hashToken(plainText),
+		Expiry:    time.Now().Add(tokenTTL),
+	}
+
+	if err := s.tokens.DeleteExpiredTokens(ctx); err != nil {
+		return models.Token{}, err
+	}
+
+	id, err := s.tokens.InsertToken(ctx, token)
+	if err != nil {
+		return models.Token{}, err
+	}
+	token.ID = id
+
+	return token, nil
+}
+
+// ValidateToken returns the user owning an unexpired token.
+func (s *AuthService) ValidateToken(ctx context.Context, plainText string) (models.User, error) {
+	user, err := s.tokens.GetUserForToken(ctx, hashToken(plainText))
+	if errors.Is(err, sql.ErrNoRows) {
+		return user, ErrInvalidToken
+	}
+
+	return user, err
+}
+
+// RevokeToken deletes an API token so it no longer authenticates requests.
+func (s *AuthService) RevokeToken(ctx context.Context, plainText string) error {
+	return s.tokens.DeleteToken(ctx, hashToken(plainText))
+}
+
+// SessionStamp returns the value a staff session stores at login so
+// ValidateSession can end it once the user's password changes.
+func (s *AuthService) SessionStamp(user models.User) string {
+	return passwordStamp(user.Password)
+}
+
+// ValidateSession checks that the password of the user a session was opened
+// for still matches the stamp stored at login.
+func (s *AuthService) ValidateSession(ctx context.Context, userID int, stamp string) error {
+	user, err := s.users.GetUserByID(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrSessionExpired
+	}
+	if err != nil {
+		return err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(stamp), []byte(passwordStamp(user.Password))) != 1 {
+		return ErrSessionExpired
+	}
+
+	return nil
+}
+
+// hashToken returns the hex encoded SHA-256 of a plain text token.
+func hashToken(plainText string) string {
+	sum := sha256.Sum256([]byte(plainText))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,277 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/mlvieira/store/internal/cards"
+	"github.com/mlvieira/store/internal/metrics"
+	"github.com/mlvieira/store/internal/models"
+	"github.com/mlvieira/store/internal/repository"
+)
+
+// Purchase holds everything recorded for a completed payment.
+// Subscription is only set when the purchased widget is a recurring plan,
+// and PaymentMethod when the card was saved for future charges.
+type Purchase struct {
+	Customer      models.Customer
+	Transaction   models.Transaction
+	Order         models.Order
+	Subscription  *cards.Subscription
+	PaymentMethod *models.PaymentMethod
+}
+
+var (
+	// ErrPaymentNotSucceeded is returned when recording a purchase paid with
+	// a payment intent that has not succeeded.
+	ErrPaymentNotSucceeded = errors.New("payment has not succeeded")
+	// ErrPaymentMismatch is returned when a payment intent was not for the
+	// price of the widget it buys.
+	ErrPaymentMismatch = errors.New("payment does not match the widget price")
+)
+
+// Metadata keys of the payment intent of a one-off purchase. They carry what
+// is needed to record the purchase from the payment intent alone, so the
+// order is saved by the payment_intent.succeeded webhook even when the
+// customer's browser never comes back.
+const (
+	metadataWidgetID  = "widget_id"
+	metadataFirstName = "first_name"
+	metadataLastName  = "last_name"
+	metadataEmail     = "email"
+)
+
+// PurchaseMetadata returns the payment intent metadata of a purchase of one
+// unit of a widget by a customer.
+func PurchaseMetadata(widgetID int, customer models.Customer) map[string]string {
+	return map[string]string{
+		metadataWidgetID:  strconv.Itoa(widgetID),
+		metadataFirstName: customer.FirstName,
+		metadataLastName:  customer.LastName,
+		metadataEmail:     customer.Email,
+	}
+}
+
+// CheckoutService records completed purchases: the customer, transaction,
+// order, subscription and saved card of each.
+type CheckoutService struct {
+	repos   *repository.Repositories
+	gateway cards.PaymentGateway
+}
+
+// NewCheckoutService initializes a new CheckoutService instance.
+func NewCheckoutService(repos *repository.Repositories, gateway cards.PaymentGateway) *CheckoutService {
+	return &CheckoutService{repos: repos, gateway: gateway}
+}
+
+// Checkout takes the purchased widgets out of stock and writes the customer,
+// transaction, order, subscription and saved card of a purchase in one SQL
+// transaction, so either all rows are saved or none are. A returning
+// customer is matched by email and the saved card becomes their default. It
+// returns the saved order, or repository.ErrInsufficientStock when the widget
+// sold out.
+func (s *CheckoutService) Checkout(ctx context.Context, p Purchase) (models.Order, error) {
+	var order models.Order
+
+	err := s.repos.WithTx(ctx, func(repos *repository.Repositories) error {
+		var err error
+		order, err = checkout(ctx, repos, p)
+		return err
+	})
+	if err != nil {
+		return models.Order{}, err
+	}
+
+	recordPurchase(p)
+
+	return order, nil
+}
+
+// CheckoutPaymentIntent records the purchase paid with a succeeded payment
+// intent, reading the widget and customer from its metadata, see
+// PurchaseMetadata. It reports false, and writes nothing, when the purchase
+// was already recorded, e.g. by the webhook. A payment that cannot be
+// recorded, because the widget sold out or the payment does not match its
+// price, is refunded and repository.ErrInsufficientStock or
+// ErrPaymentMismatch returned.
+func (s *CheckoutService) CheckoutPaymentIntent(ctx context.Context, pi *cards.PaymentIntent) (Purchase, bool, error) {
+	var (
+		p       Purchase
+		created bool
+	)
+
+	err := s.repos.WithTx(ctx, func(repos *repository.Repositories) error {
+		var err error
+		p, created, err = checkoutPaymentIntent(ctx, repos, s.gateway, pi)
+		return err
+	})
+	if errors.Is(err, repository.ErrDuplicate) {
+		// The webhook recorded the purchase while this checkout ran.
+		p.Order, err = s.repos.Order.GetOrderByPaymentIntent(ctx, pi.ID)
+		return p, false, err
+	}
+	if unrecordable(err) {
+		if _, _, refundErr := s.gateway.Refund(pi.ID, 0); refundErr != nil {
+			return p, false, errors.Join(err, refundErr)
+		}
+		return p, false, err
+	}
+	if err != nil || !created {
+		return p, false, err
+	}
+
+	recordPurchase(p)
+
+	return p, true, nil
+}
+
+// checkout writes a purchase with repos, which must be bound to a transaction.
+func checkout(ctx context.Context, repos *repository.Repositories, p Purchase) (models.Order, error) {
+	order := p.Order
+
+	if err := repos.Widget.DecrementInventory(ctx, order.WidgetID, order.Quantity); err != nil {
+		return order, err
+	}
+
+	customerID, err := repos.Customer.UpsertCustomer(ctx, p.Customer)
+	if err != nil {
+		return order, err
+	}
+
+	txnID, err := repos.Transaction.InsertTransaction(ctx, p.Transaction)
+	if err != nil {
+		return order, err
+	}
+
+	if p.PaymentMethod != nil {
+		pm := *p.PaymentMethod
+		pm.CustomerID = customerID
+		if _, err := saveDefaultPaymentMethod(ctx, repos, pm); err != nil {
+			return order, err
+		}
+	}
+
+	order.CustomerID = customerID
+	order.TransactionID = txnID
+
+	order.ID, err = repos.Order.InsertOrder(ctx, order)
+	if err != nil {
+		return order, err
+	}
+
+	if p.Subscription == nil {
+		return order, nil
+	}
+
+	sub := models.Subscription{
+		CustomerID:           customerID,
+		WidgetID:             order.WidgetID,
+		StripeSubscriptionID: p.Subscription.ID,
+	}
+	applyGatewaySubscription(&sub, p.Subscription)
+
+	_, err = repos.Subscription.InsertSubscription(ctx, sub)
+	return order, err
+}
+
+// checkoutPaymentIntent writes the purchase paid with pi with repos, which
+// must be bound to a transaction, unless its order already exists. The
+// payment must be for the price of the widget in the store's currency.
+func checkoutPaymentIntent(ctx context.Context, repos *repository.Repositories, gateway cards.PaymentGateway, pi *cards.PaymentIntent) (Purchase, bool, error) {
+	p, err := purchaseFromPaymentIntent(gateway, pi)
+	if err != nil {
+		return p, false, err
+	}
+
+	existing, err := repos.Order.GetOrderByPaymentIntent(ctx, pi.ID)
+	if err == nil {
+		p.Order = existing
+		return p, false, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return p, false, err
+	}
+
+	widget, err := repos.Widget.GetWidgetByID(ctx, p.Order.WidgetID)
+	if err != nil {
+		return p, false, err
+	}
+
+	if pi.Amount != widget.Price || pi.Currency != models.Currency {
+		return p, false, fmt.Errorf("%w: paid %d %s for widget %d", ErrPaymentMismatch, pi.Amount, pi.Currency, widget.ID)
+	}
+
+	p.Order, err = checkout(ctx, repos, p)
+	if err != nil {
+		return p, false, err
+	}
+
+	return p, true, nil
+}
+
+// purchaseFromPaymentIntent builds the purchase of one widget paid with a
+// succeeded payment intent carrying PurchaseMetadata.
+func purchaseFromPaymentIntent(gateway cards.PaymentGateway, pi *cards.PaymentIntent) (Purchase, error) {
+	if pi.Status != cards.PaymentIntentSucceeded {
+		return Purchase{}, ErrPaymentNotSucceeded
+	}
+
+	widgetID, err := strconv.Atoi(pi.Metadata[metadataWidgetID])
+	if err != nil {
+		return Purchase{}, fmt.Errorf("payment intent %s has no widget: %w", pi.ID, err)
+	}
+
+	if pi.PaymentMethodID == "" {
+		return Purchase{}, fmt.Errorf("payment intent %s has no payment method", pi.ID)
+	}
+
+	pm, err := gateway.GetPaymentMethod(pi.PaymentMethodID)
+	if err != nil {
+		return Purchase{}, err
+	}
+
+	return Purchase{
+		Customer: models.Customer{
+			FirstName: pi.Metadata[metadataFirstName],
+			LastName:  pi.Metadata[metadataLastName],
+			Email:     pi.Metadata[metadataEmail],
+		},
+		Transaction: models.Transaction{
+			Amount:              pi.Amount,
+			Currency:            pi.Currency,
+			LastFour:            pm.LastFour,
+			ExpiryMonth:         pm.ExpiryMonth,
+			ExpiryYear:          pm.ExpiryYear,
+			BankReturnCode:      pi.LatestChargeID,
+			TransactionStatusID: models.TransactionStatusCleared,
+			PaymentIntent:       pi.ID,
+			PaymentMethod:       pm.ID,
+		},
+		Order: models.Order{
+			WidgetID: widgetID,
+			StatusID: models.OrderStatusCleared,
+			Quantity: 1,
+			Amount:   pi.Amount,
+		},
+	}, nil
+}
+
+// unrecordable reports whether err means the purchase of a payment can never
+// be recorded, so the payment must be refunded.
+func unrecordable(err error) bool {
+	return errors.Is(err, repository.ErrInsufficientStock) || errors.Is(err, ErrPaymentMismatch)
+}
+
+// recordPurchase updates the sales metrics once a purchase is committed.
+func recordPurchase(p Purchase) {
+	metrics.OrderPlaced()
+	if p.Transaction.TransactionStatusID == models.TransactionStatusCleared {
+		metrics.PaymentCleared(p.Transaction.Currency, p.Transaction.Amount)
+	}
+	if p.Subscription != nil {
+		metrics.SubscriptionCreated()
+	}
+}
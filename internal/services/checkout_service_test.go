@@ -0,0 +1,154 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mlvieira/store/internal/cards"
+	"github.com/mlvieira/store/internal/repository"
+)
+
+// newCheckoutService returns a CheckoutService on a mocked database and the
+// fake gateway.
+func newCheckoutService(t *testing.T) (*CheckoutService, sqlmock.Sqlmock, *cards.FakeGateway) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	gateway := cards.NewFakeGateway()
+
+	return NewCheckoutService(repository.NewRepositories(db), gateway), mock, gateway
+}
+
+// widgetRows returns the columns of a widget selected by the widget repository.
+func widgetRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{
+		"id", "name", "description", "inventory_level", "price", "image",
+		"is_recurring", "plan_id", "billing_interval", "slug", "archived",
+		"created_at", "updated_at",
+	})
+}
+
+// expectWidget expects widget 3 to be read, an in-stock widget priced at price.
+func expectWidget(mock sqlmock.Sqlmock, price int64) {
+	mock.ExpectQuery(regexp.QuoteMeta("FROM widgets WHERE id = ?")).
+		WithArgs(3).
+		WillReturnRows(widgetRows().
+			AddRow(3, "Widget", "", 10, price, "", false, "", "", "widget", false, time.Now(), time.Now()))
+}
+
+func TestCheckoutPaymentIntentRejectsUnpaid(t *testing.T) {
+	s, mock, gateway := newCheckoutService(t)
+
+	pi, _, err := gateway.CreatePaymentIntent("brl", 1500, PurchaseMetadata(3, paidCustomer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	if _, _, err := s.CheckoutPaymentIntent(context.Background(), pi); !errors.Is(err, ErrPaymentNotSucceeded) {
+		t.Fatalf("CheckoutPaymentIntent error = %v, want ErrPaymentNotSucceeded", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCheckoutPaymentIntentRefundsMismatchedPayment(t *testing.T) {
+	s, mock, gateway := newCheckoutService(t)
+	pi := paidIntent(t, gateway)
+
+	// The posted payment intent paid less than the widget costs.
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("WHERE t.payment_intent = ?")).
+		WithArgs(pi.ID).
+		WillReturnError(sql.ErrNoRows)
+	expectWidget(mock, 9900)
+	mock.ExpectRollback()
+
+	p, created, err := s.CheckoutPaymentIntent(context.Background(), pi)
+	if !errors.Is(err, ErrPaymentMismatch) || created {
+		t.Fatalf("CheckoutPaymentIntent = %v, %v, want ErrPaymentMismatch", created, err)
+	}
+	if p.Order.WidgetID != 3 {
+		t.Errorf("widget = %d, want 3 to send the customer back to", p.Order.WidgetID)
+	}
+
+	if _, _, err := gateway.Refund(pi.ID, 1); err == nil {
+		t.Error("mismatched payment was not refunded")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCheckoutPaymentIntentRecordedConcurrently(t *testing.T) {
+	s, mock, gateway := newCheckoutService(t)
+	pi := paidIntent(t, gateway)
+
+	// The webhook commits the purchase while this checkout runs, so the
+	// unique payment intent key rejects the second transaction.
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("WHERE t.payment_intent = ?")).
+		WithArgs(pi.ID).
+		WillReturnError(sql.ErrNoRows)
+	expectWidget(mock, 1500)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT is_recurring FROM widgets")).
+		WillReturnRows(sqlmock.NewRows([]string{"is_recurring"}).AddRow(false))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE widgets")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO customers")).
+		WillReturnResult(sqlmock.NewResult(7, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions")).
+		WillReturnError(repository.ErrDuplicate)
+	mock.ExpectRollback()
+	mock.ExpectQuery(regexp.QuoteMeta("WHERE t.payment_intent = ?")).
+		WithArgs(pi.ID).
+		WillReturnRows(orderRows().AddRow(orderRow(11)...))
+
+	p, created, err := s.CheckoutPaymentIntent(context.Background(), pi)
+	if err != nil || created {
+		t.Fatalf("CheckoutPaymentIntent = %v, %v, want the existing order", created, err)
+	}
+	if p.Order.ID != 11 {
+		t.Errorf("order = %d, want 11", p.Order.ID)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPurchaseFromPaymentIntent(t *testing.T) {
+	gateway := cards.NewFakeGateway()
+	pi := paidIntent(t, gateway)
+
+	p, err := purchaseFromPaymentIntent(gateway, pi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Transaction.Amount != 1500 || p.Order.Amount != 1500 || p.Order.WidgetID != 3 {
+		t.Errorf("purchase = %d/%d for widget %d, want 1500 for widget 3",
+			p.Transaction.Amount, p.Order.Amount, p.Order.WidgetID)
+	}
+	if p.Transaction.Currency != "brl" || p.Transaction.LastFour != "4242" {
+		t.Errorf("transaction = %s %s, want brl 4242", p.Transaction.Currency, p.Transaction.LastFour)
+	}
+	if p.Customer != paidCustomer {
+		t.Errorf("customer = %+v, want %+v", p.Customer, paidCustomer)
+	}
+}
@@ -2,21 +2,65 @@ package services
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"strings"
 
+	"github.com/mlvieira/store/internal/cards"
 	"github.com/mlvieira/store/internal/models"
 	"github.com/mlvieira/store/internal/repository"
 )
 
+// CustomerService looks up and saves customers.
 type CustomerService struct {
-	repo repository.CustomerRepository
+	repo    repository.CustomerRepository
+	gateway cards.PaymentGateway
 }
 
 // NewCustomerService initializes a new CustomerService instance.
-func NewCustomerService(repo repository.CustomerRepository) *CustomerService {
-	return &CustomerService{repo: repo}
+func NewCustomerService(repo repository.CustomerRepository, gateway cards.PaymentGateway) *CustomerService {
+	return &CustomerService{repo: repo, gateway: gateway}
 }
 
-// SaveCustomer saves a customer and returns the ID.
+// SaveCustomer saves a customer, updating the one with the same email if
+// any, and returns the ID.
 func (s *CustomerService) SaveCustomer(ctx context.Context, customer models.Customer) (int, error) {
-	return s.repo.InsertCustomer(ctx, customer)
+	return s.repo.UpsertCustomer(ctx, customer)
+}
+
+// GetCustomer fetches a customer by ID.
+func (s *CustomerService) GetCustomer(ctx context.Context, id int) (models.Customer, error) {
+	return s.repo.GetCustomerByID(ctx, id)
+}
+
+// GetCustomerByEmail fetches the customer with the given email.
+func (s *CustomerService) GetCustomerByEmail(ctx context.Context, email string) (models.Customer, error) {
+	return s.repo.GetCustomerByEmail(ctx, email)
+}
+
+// ListCustomers returns a page of customers and the total number of matching customers.
+func (s *CustomerService) ListCustomers(ctx context.Context, filter repository.CustomerFilter) ([]models.Customer, int, error) {
+	filter.Search = strings.TrimSpace(filter.Search)
+	return s.repo.ListCustomers(ctx, filter)
+}
+
+// StripeCustomer returns the Stripe customer to bill for email, paying with
+// the payment method pm. A returning customer keeps their Stripe customer,
+// with pm as the new default; anyone else, or a customer Stripe no longer
+// knows, gets a new one. It also returns a user-facing message when the
+// gateway rejects the card.
+func (s *CustomerService) StripeCustomer(ctx context.Context, email, pm string) (*cards.Customer, string, error) {
+	customer, err := s.repo.GetCustomerByEmail(ctx, email)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, "", err
+	}
+
+	if customer.StripeCustomerID != "" {
+		cust, msg, err := s.gateway.UpdateCustomerPaymentMethod(customer.StripeCustomerID, pm)
+		if !cards.IsNotFound(err) {
+			return cust, msg, err
+		}
+	}
+
+	return s.gateway.CreateCustomer(pm, email)
 }
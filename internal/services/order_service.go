@@ -2,21 +2,145 @@ package services
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"time"
 
+	"github.com/mlvieira/store/internal/cards"
+	"github.com/mlvieira/store/internal/invoice"
 	"github.com/mlvieira/store/internal/models"
 	"github.com/mlvieira/store/internal/repository"
 )
 
+var (
+	// ErrNotRefundable is returned when an order has no payment that can be refunded.
+	ErrNotRefundable = errors.New("order is not refundable")
+	// ErrRefundTooLarge is returned when a refund exceeds what is left of the payment.
+	ErrRefundTooLarge = errors.New("refund exceeds the remaining balance")
+)
+
+// OrderService reads orders, sales figures and invoices, and refunds orders
+// through the payment gateway.
 type OrderService struct {
-	order repository.OrderRepository
+	repos   *repository.Repositories
+	gateway cards.PaymentGateway
 }
 
 // NewOrderService initializes a new OrderService instance.
-func NewOrderService(order repository.OrderRepository) *OrderService {
-	return &OrderService{order: order}
+func NewOrderService(repos *repository.Repositories, gateway cards.PaymentGateway) *OrderService {
+	return &OrderService{repos: repos, gateway: gateway}
 }
 
 // PlaceOrder processes an order and returns the order ID.
 func (s *OrderService) PlaceOrder(ctx context.Context, order models.Order) (int, error) {
-	return s.order.InsertOrder(ctx, order)
+	return s.repos.Order.InsertOrder(ctx, order)
+}
+
+// GetOrder fetches an order with its widget, transaction, customer and status.
+func (s *OrderService) GetOrder(ctx context.Context, id int) (models.Order, error) {
+	return s.repos.Order.GetOrderByID(ctx, id)
+}
+
+// SalesSince returns the number of orders placed since the given time and
+// their revenue net of refunds, in cents.
+func (s *OrderService) SalesSince(ctx context.Context, since time.Time) (int, int64, error) {
+	return s.repos.Order.SalesSince(ctx, since)
+}
+
+// ListOrders returns a page of orders and the total number of matching orders.
+func (s *OrderService) ListOrders(ctx context.Context, filter repository.OrderFilter) ([]models.Order, int, error) {
+	return s.repos.Order.ListOrders(ctx, filter)
+}
+
+// RefundOrder refunds amount (in cents) of an order's payment, or the whole
+// remaining balance when amount is zero. Once nothing is left to refund the
+// order moves to the refunded status and its widgets go back in stock. It
+// returns the refunded amount and a user-facing message when the gateway
+// rejects the refund.
+//
+// The transaction row stays locked from the balance check until the refund
+// is recorded, so two concurrent refunds cannot both spend the same balance.
+func (s *OrderService) RefundOrder(ctx context.Context, orderID int, amount int64) (int64, string, error) {
+	var msg string
+
+	err := s.repos.WithTx(ctx, func(repos *repository.Repositories) error {
+		order, err := repos.Order.GetOrderByID(ctx, orderID)
+		if err != nil {
+			return err
+		}
+
+		txn, err := repos.Transaction.LockTransactionByID(ctx, order.TransactionID)
+		if err != nil {
+			return err
+		}
+
+		remaining := txn.Amount - txn.RefundedAmount
+		if txn.PaymentIntent == "" || order.StatusID != models.OrderStatusCleared || remaining <= 0 {
+			return ErrNotRefundable
+		}
+
+		if amount == 0 {
+			amount = remaining
+		}
+
+		if amount < 0 || amount > remaining {
+			return fmt.Errorf("%w: %d left", ErrRefundTooLarge, remaining)
+		}
+
+		// Should recording the refund fail after the gateway took it, the
+		// charge.refunded webhook records it instead.
+		if _, msg, err = s.gateway.Refund(txn.PaymentIntent, amount); err != nil {
+			return err
+		}
+
+		refunded := txn.RefundedAmount + amount
+		statusID := models.TransactionStatusPartiallyRefunded
+		if refunded == txn.Amount {
+			statusID = models.TransactionStatusRefunded
+		}
+
+		if _, err := repos.Transaction.UpdateRefund(ctx, txn.PaymentIntent, refunded, statusID); err != nil {
+			return err
+		}
+
+		if statusID != models.TransactionStatusRefunded {
+			return nil
+		}
+
+		if err := repos.Order.UpdateStatus(ctx, order.ID, models.OrderStatusRefunded); err != nil {
+			return err
+		}
+
+		return repos.Widget.RestoreInventory(ctx, order.WidgetID, order.Quantity)
+	})
+	if err != nil {
+		return 0, msg, err
+	}
+
+	return amount, "", nil
+}
+
+// Invoice gathers the order, customer, widget and transaction printed on an order's invoice.
+func (s *OrderService) Invoice(ctx context.Context, orderID int) (invoice.Invoice, error) {
+	var inv invoice.Invoice
+
+	order, err := s.repos.Order.GetOrderByID(ctx, orderID)
+	if err != nil {
+		return inv, err
+	}
+	inv.Order = order
+
+	if inv.Customer, err = s.repos.Customer.GetCustomerByID(ctx, order.CustomerID); err != nil {
+		return inv, err
+	}
+
+	if inv.Widget, err = s.repos.Widget.GetWidgetByID(ctx, order.WidgetID); err != nil {
+		return inv, err
+	}
+
+	if inv.Transaction, err = s.repos.Transaction.GetTransactionByID(ctx, order.TransactionID); err != nil {
+		return inv, err
+	}
+
+	return inv, nil
 }
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mlvieira/store/internal/cards"
+	"github.com/mlvieira/store/internal/models"
+	"github.com/mlvieira/store/internal/repository"
+)
+
+// newOrderService returns an OrderService on a mocked database and the fake
+// gateway.
+func newOrderService(t *testing.T) (*OrderService, sqlmock.Sqlmock, *cards.FakeGateway) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	gateway := cards.NewFakeGateway()
+
+	return NewOrderService(repository.NewRepositories(db), gateway), mock, gateway
+}
+
+// expectLockedTransaction expects order 11 to be read and its transaction
+// row locked, with refunded already refunded out of 1500.
+func expectLockedTransaction(mock sqlmock.Sqlmock, paymentIntent string, refunded int64) {
+	now := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("WHERE o.id = ?")).
+		WithArgs(11).
+		WillReturnRows(orderRows().AddRow(orderRow(11)...))
+	mock.ExpectQuery(regexp.QuoteMeta("WHERE id = ? FOR UPDATE")).
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "amount", "currency", "last_four", "bank_return_code",
+			"transaction_status_id", "expiry_month", "expiry_year",
+			"payment_intent", "payment_method", "refunded_amount",
+			"created_at", "updated_at",
+		}).AddRow(9, 1500, "brl", "4242", "ch_1", models.TransactionStatusCleared, 12, 2030,
+			paymentIntent, "pm_1", refunded, now, now))
+}
+
+func TestRefundOrderLocksTransaction(t *testing.T) {
+	s, mock, gateway := newOrderService(t)
+	pi := paidIntent(t, gateway)
+
+	expectLockedTransaction(mock, pi.ID, 500)
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE transactions")).
+		WithArgs(int64(1500), models.TransactionStatusRefunded, sqlmock.AnyArg(), pi.ID, int64(1500),
+			models.TransactionStatusCleared, models.TransactionStatusPartiallyRefunded).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE orders")).
+		WithArgs(models.OrderStatusRefunded, sqlmock.AnyArg(), 11).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("inventory_level + ?")).
+		WithArgs(1, sqlmock.AnyArg(), 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	refunded, _, err := s.RefundOrder(context.Background(), 11, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refunded != 1000 {
+		t.Errorf("refunded = %d, want the remaining 1000", refunded)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRefundOrderRejectsSpentBalance(t *testing.T) {
+	s, mock, gateway := newOrderService(t)
+	pi := paidIntent(t, gateway)
+
+	// A concurrent refund took the whole balance while this one waited for
+	// the lock.
+	expectLockedTransaction(mock, pi.ID, 1500)
+	mock.ExpectRollback()
+
+	if _, _, err := s.RefundOrder(context.Background(), 11, 500); !errors.Is(err, ErrNotRefundable) {
+		t.Fatalf("RefundOrder error = %v, want ErrNotRefundable", err)
+	}
+
+	if _, _, err := gateway.Refund(pi.ID, 1500); err != nil {
+		t.Errorf("gateway was refunded although the balance was spent: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mlvieira/store/internal/mailer"
+	"github.com/mlvieira/store/internal/models"
+	"github.com/mlvieira/store/internal/repository"
+	"github.com/mlvieira/store/internal/urlsigner"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrWeakPassword is returned when a new password is too short.
+	ErrWeakPassword = errors.New("password must be at least 8 characters")
+	// ErrLinkUsed is returned when a reset link was issued before the
+	// password last changed, e.g. because it was already used.
+	ErrLinkUsed = errors.New("reset link was already used")
+)
+
+const (
+	// resetLinkTTL is how long a password reset link stays valid.
+	resetLinkTTL = time.Hour
+	// minPasswordLength is the shortest password accepted on reset.
+	minPasswordLength = 8
+	// stampParam is the reset link parameter binding it to the password
+	// hash it was issued for, see passwordStamp.
+	stampParam = "stamp"
+)
+
+// PasswordResetService emails staff users signed links to choose a new
+// password, and applies the new password.
+type PasswordResetService struct {
+	repos    *repository.Repositories
+	signer   *urlsigner.Signer
+	mail     *mailer.Mailer
+	frontEnd string
+}
+
+// NewPasswordResetService initializes a new PasswordResetService instance.
+func NewPasswordResetService(repos *repository.Repositories, signer *urlsigner.Signer, mail *mailer.Mailer, frontEnd string) *PasswordResetService {
+	return &PasswordResetService{repos: repos, signer: signer, mail: mail, frontEnd: strings.TrimRight(frontEnd, "/")}
+}
+
+// SendResetLink emails a signed password reset link to the user with the
+// given email. Unknown emails are ignored so the form does not reveal which
+// accounts exist. The link only works until the password changes, so it can
+// be used once.
+func (s *PasswordResetService) SendResetLink(ctx context.Context, email string) error {
+	user, err := s.repos.User.GetUserByEmail(ctx, strings.TrimSpace(email))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	query.Set("email", user.Email)
+	query.Set(stampParam, passwordStamp(user.Password))
+
+	link, err := s.signer.Sign(fmt.Sprintf("%s/reset-password?%s", s.frontEnd, query.Encode()), resetLinkTTL)
+	if err != nil {
+		return err
+	}
+
+	return s.mail.Send(ctx, user.Email, mailer.TemplatePasswordReset, mailer.PasswordReset{
+		FirstName: user.FirstName,
+		Link:      link,
+	})
+}
+
+// VerifyLink checks the signature and expiry of a reset link, and that the
+// password did not change since it was issued, and returns the email it was
+// issued for.
+func (s *PasswordResetService) VerifyLink(ctx context.Context, link string) (string, error) {
+	user, err := s.verifyLink(ctx, link)
+	return user.Email, err
+}
+
+// verifyLink verifies a reset link and returns the user it was issued to.
+func (s *PasswordResetService) verifyLink(ctx context.Context, link string) (models.User, error) {
+	if err := s.signer.Verify(link); err != nil {
+		return models.User{}, err
+	}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		return models.User{}, urlsigner.ErrInvalidSignature
+	}
+
+	user, err := s.repos.User.GetUserByEmail(ctx, u.Query().Get("email"))
+	if err != nil {
+		return user, err
+	}
+
+	stamp := u.Query().Get(stampParam)
+	if subtle.ConstantTimeCompare([]byte(stamp), []byte(passwordStamp(user.Password))) != 1 {
+		return user, ErrLinkUsed
+	}
+
+	return user, nil
+}
+
+// ResetPassword stores a new password for the user a verified reset link was
+// issued to, and revokes their API tokens. Web sessions opened with the old
+// password end too, as their stamp no longer matches, see
+// AuthService.ValidateSession.
+func (s *PasswordResetService) ResetPassword(ctx context.Context, link, password string) error {
+	user, err := s.verifyLink(ctx, link)
+	if err != nil {
+		return err
+	}
+
+	if len(password) < minPasswordLength {
+		return ErrWeakPassword
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	return s.repos.WithTx(ctx, func(repos *repository.Repositories) error {
+		if err := repos.User.UpdatePassword(ctx, user.ID, string(hash)); err != nil {
+			return err
+		}
+
+		return repos.Token.DeleteTokensForUser(ctx, user.ID)
+	})
+}
+
+// passwordStamp returns a short digest of a password hash. Reset links and
+// staff sessions carry it so they stop working once the password changes.
+func passwordStamp(hash string) string {
+	sum := sha256.Sum256([]byte(hash))
+	return hex.EncodeToString(sum[:8])
+}
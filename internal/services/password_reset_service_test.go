@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mlvieira/store/internal/mailer"
+	"github.com/mlvieira/store/internal/repository"
+	"github.com/mlvieira/store/internal/urlsigner"
+)
+
+// sentMail records the messages a Mailer delivers.
+type sentMail struct {
+	msgs []mailer.Message
+}
+
+func (t *sentMail) Send(_ context.Context, msg mailer.Message) error {
+	t.msgs = append(t.msgs, msg)
+	return nil
+}
+
+// resetLinkPattern finds the reset link in the text of a reset email.
+var resetLinkPattern = regexp.MustCompile(`/reset-password\?\S+`)
+
+// expectUser expects the staff user to be read with the given password hash.
+func expectUser(mock sqlmock.Sqlmock, hash string) {
+	mock.ExpectQuery(regexp.QuoteMeta("FROM users")).
+		WithArgs("staff@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "first_name", "last_name", "email", "password", "created_at", "updated_at",
+		}).AddRow(4, "Staff", "User", "staff@example.com", hash, time.Now(), time.Now()))
+}
+
+func TestResetLinkWorksOnce(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	sent := &sentMail{}
+	mail, err := mailer.New(sent, "store@example.com", 0, 0, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewPasswordResetService(repository.NewRepositories(db), urlsigner.New([]byte("secret")), mail, "")
+	ctx := context.Background()
+
+	expectUser(mock, "old-hash")
+	if err := s.SendResetLink(ctx, "staff@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent.msgs) != 1 {
+		t.Fatalf("sent %d emails, want 1", len(sent.msgs))
+	}
+	link := resetLinkPattern.FindString(sent.msgs[0].Text)
+
+	// The password changes and the user's API tokens are revoked with it.
+	expectUser(mock, "old-hash")
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users")).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM tokens WHERE user_id = ?")).
+		WithArgs(4).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	if err := s.ResetPassword(ctx, link, "new password"); err != nil {
+		t.Fatalf("ResetPassword: %v", err)
+	}
+
+	// The same link no longer matches the new password hash.
+	expectUser(mock, "new-hash")
+	if _, err := s.VerifyLink(ctx, link); !errors.Is(err, ErrLinkUsed) {
+		t.Errorf("VerifyLink after reset = %v, want ErrLinkUsed", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,276 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mlvieira/store/internal/cards"
+	"github.com/mlvieira/store/internal/mailer"
+	"github.com/mlvieira/store/internal/metrics"
+	"github.com/mlvieira/store/internal/models"
+	"github.com/mlvieira/store/internal/repository"
+	"github.com/mlvieira/store/internal/urlsigner"
+)
+
+// ErrPaymentIncomplete is returned when a customer has not finished
+// authenticating a payment yet.
+var ErrPaymentIncomplete = errors.New("payment is not complete")
+
+// paymentLinkTTL is how long the link to authenticate a saved card charge
+// stays valid.
+const paymentLinkTTL = 7 * 24 * time.Hour
+
+// SavedCardCharge is the result of charging a saved card.
+type SavedCardCharge struct {
+	Customer    models.Customer
+	Transaction models.Transaction
+}
+
+// PaymentMethodService manages the cards customers saved with the gateway
+// and charges them off session from the virtual terminal.
+type PaymentMethodService struct {
+	repos    *repository.Repositories
+	gateway  cards.PaymentGateway
+	signer   *urlsigner.Signer
+	mail     *mailer.Mailer
+	frontEnd string
+}
+
+// NewPaymentMethodService initializes a new PaymentMethodService instance.
+func NewPaymentMethodService(repos *repository.Repositories, gateway cards.PaymentGateway, signer *urlsigner.Signer, mail *mailer.Mailer, frontEnd string) *PaymentMethodService {
+	return &PaymentMethodService{
+		repos:    repos,
+		gateway:  gateway,
+		signer:   signer,
+		mail:     mail,
+		frontEnd: strings.TrimRight(frontEnd, "/"),
+	}
+}
+
+// ListPaymentMethods returns the saved cards of a customer, the default one first.
+func (s *PaymentMethodService) ListPaymentMethods(ctx context.Context, customerID int) ([]models.PaymentMethod, error) {
+	return s.repos.PaymentMethod.ListPaymentMethods(ctx, customerID)
+}
+
+// SaveDefault saves a card of the customer and makes it their default.
+func (s *PaymentMethodService) SaveDefault(ctx context.Context, pm models.PaymentMethod) (models.PaymentMethod, error) {
+	err := s.repos.WithTx(ctx, func(repos *repository.Repositories) error {
+		var err error
+		pm, err = saveDefaultPaymentMethod(ctx, repos, pm)
+		return err
+	})
+	if err != nil {
+		return models.PaymentMethod{}, err
+	}
+
+	return pm, nil
+}
+
+// ChargeSavedMethod charges a saved card without the customer being present
+// and records the transaction. When the bank asks for authentication the
+// transaction is saved as pending, the customer is emailed a link to
+// confirm the payment and cards.ErrAuthenticationRequired is returned. A
+// charge whose transaction cannot be recorded is refunded. It also returns a
+// user-facing message when the gateway rejects the card.
+func (s *PaymentMethodService) ChargeSavedMethod(ctx context.Context, id int, currency string, amount int64) (SavedCardCharge, string, error) {
+	var charge SavedCardCharge
+
+	pm, err := s.repos.PaymentMethod.GetPaymentMethodByID(ctx, id)
+	if err != nil {
+		return charge, "", err
+	}
+
+	charge.Customer, err = s.repos.Customer.GetCustomerByID(ctx, pm.CustomerID)
+	if err != nil {
+		return charge, "", err
+	}
+
+	if charge.Customer.StripeCustomerID == "" {
+		return charge, "", ErrNoStripeCustomer
+	}
+
+	pi, msg, err := s.gateway.ChargeSavedMethod(charge.Customer.StripeCustomerID, pm.StripePaymentMethodID, currency, amount)
+	authRequired := errors.Is(err, cards.ErrAuthenticationRequired)
+	if err != nil && !authRequired {
+		return charge, msg, err
+	}
+
+	txn := models.Transaction{
+		Amount:              amount,
+		Currency:            currency,
+		LastFour:            pm.LastFour,
+		ExpiryMonth:         pm.ExpiryMonth,
+		ExpiryYear:          pm.ExpiryYear,
+		TransactionStatusID: models.TransactionStatusCleared,
+		BankReturnCode:      pi.LatestChargeID,
+		PaymentIntent:       pi.ID,
+		PaymentMethod:       pm.StripePaymentMethodID,
+	}
+	if authRequired {
+		txn.TransactionStatusID = models.TransactionStatusPending
+	}
+
+	txn.ID, err = s.repos.Transaction.InsertTransaction(ctx, txn)
+	if err != nil {
+		return charge, "", s.refundUnrecorded(pi.ID, authRequired, err)
+	}
+	charge.Transaction = txn
+
+	if !authRequired {
+		metrics.PaymentCleared(txn.Currency, txn.Amount)
+		return charge, "", nil
+	}
+
+	if err := s.sendAuthenticationLink(ctx, charge.Customer, txn); err != nil {
+		return charge, "", fmt.Errorf("emailing authentication link for %s: %w", pi.ID, err)
+	}
+
+	return charge, "", cards.ErrAuthenticationRequired
+}
+
+// refundUnrecorded refunds a saved card charge whose transaction could not
+// be recorded, so the customer is not charged for a payment the store has no
+// record of. A payment waiting for authentication took no money, and as no
+// link was emailed for it nobody can complete it, so it is left alone.
+func (s *PaymentMethodService) refundUnrecorded(pi string, authRequired bool, err error) error {
+	err = fmt.Errorf("recording charge %s: %w", pi, err)
+	if authRequired {
+		return err
+	}
+
+	if _, _, refundErr := s.gateway.Refund(pi, 0); refundErr != nil {
+		return errors.Join(err, fmt.Errorf("refunding unrecorded charge %s: %w", pi, refundErr))
+	}
+
+	return err
+}
+
+// sendAuthenticationLink emails the customer a signed link to confirm the
+// payment of a pending transaction.
+func (s *PaymentMethodService) sendAuthenticationLink(ctx context.Context, customer models.Customer, txn models.Transaction) error {
+	link, err := s.signer.Sign(fmt.Sprintf("%s/pay/authenticate?payment_intent=%s", s.frontEnd, url.QueryEscape(txn.PaymentIntent)), paymentLinkTTL)
+	if err != nil {
+		return err
+	}
+
+	return s.mail.Send(ctx, customer.Email, mailer.TemplatePaymentAuthentication, mailer.PaymentAuthentication{
+		FirstName: customer.FirstName,
+		Amount:    txn.Amount,
+		LastFour:  txn.LastFour,
+		Link:      link,
+	})
+}
+
+// AuthenticationPayment checks the signature and expiry of an
+// authentication link and returns the payment intent it was issued for and
+// the ID of the saved card to confirm it with.
+func (s *PaymentMethodService) AuthenticationPayment(link string) (*cards.PaymentIntent, string, error) {
+	id, err := s.verifyAuthenticationLink(link)
+	if err != nil {
+		return nil, "", err
+	}
+
+	pi, err := s.gateway.RetrievePaymentIntent(id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// A failed off-session confirmation detaches the card from the intent
+	// and reports it in the last payment error instead.
+	pm := pi.PaymentMethodID
+	if pm == "" && pi.LastPaymentError != nil {
+		pm = pi.LastPaymentError.PaymentMethodID
+	}
+
+	return pi, pm, nil
+}
+
+// CompleteAuthentication clears the transaction of a payment the customer
+// confirmed through an authentication link. It returns ErrPaymentIncomplete
+// when the payment has not succeeded.
+func (s *PaymentMethodService) CompleteAuthentication(ctx context.Context, link string) error {
+	id, err := s.verifyAuthenticationLink(link)
+	if err != nil {
+		return err
+	}
+
+	pi, err := s.gateway.RetrievePaymentIntent(id)
+	if err != nil {
+		return err
+	}
+
+	// A payment still waiting for the customer has no charge yet.
+	if pi.Status != cards.PaymentIntentSucceeded {
+		return ErrPaymentIncomplete
+	}
+
+	if _, err := s.gateway.RetrieveChargeID(id); err != nil {
+		return err
+	}
+
+	changed, err := s.repos.Transaction.UpdateStatusByPaymentIntent(ctx, id, models.TransactionStatusCleared)
+	if err != nil {
+		return err
+	}
+
+	// A reload of the page, or the webhook, may have cleared it already.
+	if changed > 0 {
+		metrics.PaymentCleared(pi.Currency, pi.Amount)
+	}
+
+	return nil
+}
+
+// verifyAuthenticationLink checks the signature and expiry of an
+// authentication link and returns the payment intent ID it carries.
+func (s *PaymentMethodService) verifyAuthenticationLink(link string) (string, error) {
+	if err := s.signer.Verify(link); err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", urlsigner.ErrInvalidSignature
+	}
+
+	id := u.Query().Get("payment_intent")
+	if id == "" {
+		return "", urlsigner.ErrInvalidSignature
+	}
+
+	return id, nil
+}
+
+// saveDefaultPaymentMethod saves a card and makes it the customer's default.
+// The repositories must be bound to a transaction.
+func saveDefaultPaymentMethod(ctx context.Context, repos *repository.Repositories, pm models.PaymentMethod) (models.PaymentMethod, error) {
+	id, err := repos.PaymentMethod.SavePaymentMethod(ctx, pm)
+	if err != nil {
+		return pm, err
+	}
+
+	if err := repos.PaymentMethod.SetDefaultPaymentMethod(ctx, pm.CustomerID, id); err != nil {
+		return pm, err
+	}
+
+	pm.ID = id
+	pm.IsDefault = true
+
+	return pm, nil
+}
+
+// paymentMethodFromGateway copies the card details of a gateway payment method.
+func paymentMethodFromGateway(customerID int, pm *cards.PaymentMethod) models.PaymentMethod {
+	return models.PaymentMethod{
+		CustomerID:            customerID,
+		StripePaymentMethodID: pm.ID,
+		Brand:                 pm.Brand,
+		LastFour:              pm.LastFour,
+		ExpiryMonth:           pm.ExpiryMonth,
+		ExpiryYear:            pm.ExpiryYear,
+	}
+}
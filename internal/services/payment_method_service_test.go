@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mlvieira/store/internal/cards"
+	"github.com/mlvieira/store/internal/repository"
+	"github.com/mlvieira/store/internal/urlsigner"
+)
+
+// chargingGateway is the fake gateway recording the saved card charges it
+// makes.
+type chargingGateway struct {
+	*cards.FakeGateway
+	charged []string
+}
+
+func (g *chargingGateway) ChargeSavedMethod(customerID, pm, currency string, amount int64) (*cards.PaymentIntent, string, error) {
+	pi, msg, err := g.FakeGateway.ChargeSavedMethod(customerID, pm, currency, amount)
+	if pi != nil {
+		g.charged = append(g.charged, pi.ID)
+	}
+	return pi, msg, err
+}
+
+func TestChargeSavedMethodRefundsUnrecordedCharge(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	gateway := &chargingGateway{FakeGateway: cards.NewFakeGateway()}
+	pm := "pm_" + cards.FakeCardSuccess
+	cust, _, err := gateway.CreateCustomer(pm, "ana@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("FROM payment_methods WHERE id = ?")).
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "customer_id", "stripe_payment_method_id", "brand", "last_four",
+			"expiry_month", "expiry_year", "is_default", "created_at", "updated_at",
+		}).AddRow(5, 7, pm, "visa", "4242", 12, 2030, true, time.Now(), time.Now()))
+	mock.ExpectQuery(regexp.QuoteMeta("FROM customers WHERE id = ?")).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "first_name", "last_name", "email", "stripe_customer_id", "created_at", "updated_at",
+		}).AddRow(7, "Ana", "Souza", "ana@example.com", cust.ID, time.Now(), time.Now()))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions")).
+		WillReturnError(errors.New("connection lost"))
+
+	s := NewPaymentMethodService(repository.NewRepositories(db), gateway, nil, nil, "")
+	if _, _, err := s.ChargeSavedMethod(context.Background(), 5, "brl", 1000); err == nil {
+		t.Fatal("ChargeSavedMethod succeeded without recording the transaction")
+	}
+
+	if len(gateway.charged) != 1 {
+		t.Fatalf("charged %d times, want 1", len(gateway.charged))
+	}
+	if _, _, err := gateway.Refund(gateway.charged[0], 1); err == nil {
+		t.Error("the unrecorded charge was not refunded")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompleteAuthenticationWaitsForPayment(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	gateway := cards.NewFakeGateway()
+	pm := "pm_" + cards.FakeCardAuthenticationRequired
+	cust, _, err := gateway.CreateCustomer(pm, "ana@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pi, _, err := gateway.ChargeSavedMethod(cust.ID, pm, "brl", 1000)
+	if !errors.Is(err, cards.ErrAuthenticationRequired) {
+		t.Fatalf("ChargeSavedMethod: %v, want ErrAuthenticationRequired", err)
+	}
+
+	signer := urlsigner.New([]byte("secret"))
+	link, err := signer.Sign("http://store.test/pay/authenticate?payment_intent="+pi.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewPaymentMethodService(repository.NewRepositories(db), gateway, signer, nil, "")
+	ctx := context.Background()
+
+	// The customer has not authenticated yet, so there is no charge to find.
+	if err := s.CompleteAuthentication(ctx, link); !errors.Is(err, ErrPaymentIncomplete) {
+		t.Fatalf("CompleteAuthentication before confirming: %v, want ErrPaymentIncomplete", err)
+	}
+
+	if _, _, err := gateway.ConfirmPaymentIntent(pi.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE transactions")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := s.CompleteAuthentication(ctx, link); err != nil {
+		t.Fatalf("CompleteAuthentication after confirming: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
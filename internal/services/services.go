@@ -1,21 +1,44 @@
 package services
 
 import (
+	"github.com/mlvieira/store/internal/cards"
+	"github.com/mlvieira/store/internal/mailer"
 	"github.com/mlvieira/store/internal/repository"
+	"github.com/mlvieira/store/internal/urlsigner"
 )
 
 // Services contains all application service instances.
 type Services struct {
-	CustomerService    *CustomerService
-	OrderService       *OrderService
-	TransactionService *TransactionService
+	CustomerService      *CustomerService
+	OrderService         *OrderService
+	TransactionService   *TransactionService
+	WebhookService       *WebhookService
+	SubscriptionService  *SubscriptionService
+	CheckoutService      *CheckoutService
+	WidgetService        *WidgetService
+	AuthService          *AuthService
+	PasswordResetService *PasswordResetService
+	AccountService       *AccountService
+	PaymentMethodService *PaymentMethodService
 }
 
 // NewServices initializes and returns all application services.
-func NewServices(repos *repository.Repositories) *Services {
+func NewServices(repos *repository.Repositories, gateway cards.PaymentGateway, mail *mailer.Mailer, signer *urlsigner.Signer, frontEnd string) *Services {
+	subscriptionService := NewSubscriptionService(repos.Subscription, repos.Widget, gateway)
+	orderService := NewOrderService(repos, gateway)
+	paymentMethodService := NewPaymentMethodService(repos, gateway, signer, mail, frontEnd)
+
 	return &Services{
-		CustomerService:    NewCustomerService(repos.Customer),
-		OrderService:       NewOrderService(repos.Order),
-		TransactionService: NewTransactionService(repos.Transaction),
+		CustomerService:      NewCustomerService(repos.Customer, gateway),
+		OrderService:         orderService,
+		TransactionService:   NewTransactionService(repos.Transaction),
+		WebhookService:       NewWebhookService(repos, gateway),
+		SubscriptionService:  subscriptionService,
+		CheckoutService:      NewCheckoutService(repos, gateway),
+		WidgetService:        NewWidgetService(repos),
+		AuthService:          NewAuthService(repos.User, repos.Token),
+		PasswordResetService: NewPasswordResetService(repos, signer, mail, frontEnd),
+		AccountService:       NewAccountService(repos.Customer, orderService, subscriptionService, paymentMethodService, gateway, signer, mail, frontEnd),
+		PaymentMethodService: paymentMethodService,
 	}
 }
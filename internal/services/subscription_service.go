@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/mlvieira/store/internal/cards"
+	"github.com/mlvieira/store/internal/models"
+	"github.com/mlvieira/store/internal/repository"
+)
+
+var (
+	// ErrSubscriptionEnded is returned when changing a subscription that was already canceled.
+	ErrSubscriptionEnded = errors.New("subscription has already ended")
+	// ErrNotAPlan is returned when switching a subscription to a widget that is not a recurring plan.
+	ErrNotAPlan = errors.New("widget is not a recurring plan")
+	// ErrPlanArchived is returned when switching a subscription to a plan that was archived.
+	ErrPlanArchived = errors.New("plan is no longer offered")
+)
+
+// subscriptionStatusPaused is stored while payment collection is paused.
+// Stripe keeps such subscriptions "active" and flags them with pause_collection.
+const subscriptionStatusPaused = "paused"
+
+// SubscriptionService changes recurring plans on the gateway and mirrors
+// their state into the subscriptions table.
+type SubscriptionService struct {
+	repo    repository.SubscriptionRepository
+	widget  repository.WidgetRepository
+	gateway cards.PaymentGateway
+}
+
+// NewSubscriptionService initializes a new SubscriptionService instance.
+func NewSubscriptionService(repo repository.SubscriptionRepository, widget repository.WidgetRepository, gateway cards.PaymentGateway) *SubscriptionService {
+	return &SubscriptionService{repo: repo, widget: widget, gateway: gateway}
+}
+
+// GetSubscription fetches a subscription with its widget and customer.
+func (s *SubscriptionService) GetSubscription(ctx context.Context, id int) (models.Subscription, error) {
+	return s.repo.GetSubscriptionByID(ctx, id)
+}
+
+// ListSubscriptions returns a page of subscriptions and the total number of matching subscriptions.
+func (s *SubscriptionService) ListSubscriptions(ctx context.Context, filter repository.SubscriptionFilter) ([]models.Subscription, int, error) {
+	return s.repo.ListSubscriptions(ctx, filter)
+}
+
+// Cancel cancels a subscription immediately or at the end of the current period.
+func (s *SubscriptionService) Cancel(ctx context.Context, id int, atPeriodEnd bool) (models.Subscription, error) {
+	return s.update(ctx, id, func(sub *models.Subscription) (*cards.Subscription, error) {
+		return s.gateway.CancelSubscription(sub.StripeSubscriptionID, atPeriodEnd)
+	})
+}
+
+// Pause pauses payment collection on a subscription.
+func (s *SubscriptionService) Pause(ctx context.Context, id int) (models.Subscription, error) {
+	return s.update(ctx, id, func(sub *models.Subscription) (*cards.Subscription, error) {
+		return s.gateway.PauseSubscription(sub.StripeSubscriptionID)
+	})
+}
+
+// Resume resumes payment collection on a paused subscription.
+func (s *SubscriptionService) Resume(ctx context.Context, id int) (models.Subscription, error) {
+	return s.update(ctx, id, func(sub *models.Subscription) (*cards.Subscription, error) {
+		return s.gateway.ResumeSubscription(sub.StripeSubscriptionID)
+	})
+}
+
+// ChangePlan switches a subscription to another recurring widget, prorating
+// the difference. Archived plans are no longer offered and cannot be chosen.
+func (s *SubscriptionService) ChangePlan(ctx context.Context, id, widgetID int) (models.Subscription, error) {
+	widget, err := s.widget.GetWidgetByID(ctx, widgetID)
+	if err != nil {
+		return models.Subscription{}, err
+	}
+
+	if !widget.IsRecurring || widget.PlanID == "" {
+		return models.Subscription{}, ErrNotAPlan
+	}
+
+	if widget.Archived {
+		return models.Subscription{}, ErrPlanArchived
+	}
+
+	return s.update(ctx, id, func(sub *models.Subscription) (*cards.Subscription, error) {
+		sub.WidgetID = widget.ID
+		sub.Widget = widget
+		return s.gateway.ChangeSubscriptionPlan(sub.StripeSubscriptionID, widget.PlanID)
+	})
+}
+
+// syncSubscription mirrors a subscription reported by Stripe into the
+// matching row, if any.
+func syncSubscription(ctx context.Context, repo repository.SubscriptionRepository, gatewaySub *cards.Subscription) error {
+	sub, err := repo.GetSubscriptionByStripeID(ctx, gatewaySub.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	applyGatewaySubscription(&sub, gatewaySub)
+
+	return repo.UpdateSubscription(ctx, sub)
+}
+
+// update applies a gateway change to a live subscription and stores the result.
+func (s *SubscriptionService) update(ctx context.Context, id int, change func(sub *models.Subscription) (*cards.Subscription, error)) (models.Subscription, error) {
+	sub, err := s.repo.GetSubscriptionByID(ctx, id)
+	if err != nil {
+		return sub, err
+	}
+
+	if sub.Status == string(cards.SubscriptionCanceled) {
+		return sub, ErrSubscriptionEnded
+	}
+
+	gatewaySub, err := change(&sub)
+	if err != nil {
+		return sub, err
+	}
+
+	applyGatewaySubscription(&sub, gatewaySub)
+
+	if err := s.repo.UpdateSubscription(ctx, sub); err != nil {
+		return sub, err
+	}
+
+	return sub, nil
+}
+
+// applyGatewaySubscription copies status and billing period from the gateway.
+func applyGatewaySubscription(sub *models.Subscription, gatewaySub *cards.Subscription) {
+	sub.Status = string(gatewaySub.Status)
+	if gatewaySub.Paused && gatewaySub.Status == cards.SubscriptionActive {
+		sub.Status = subscriptionStatusPaused
+	}
+	sub.CancelAtPeriodEnd = gatewaySub.CancelAtPeriodEnd
+	sub.CurrentPeriodEnd = gatewaySub.CurrentPeriodEnd
+}
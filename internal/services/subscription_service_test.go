@@ -0,0 +1,43 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mlvieira/store/internal/cards"
+	"github.com/mlvieira/store/internal/repository"
+)
+
+// expectPlan expects widget id to be read as a recurring plan.
+func expectPlan(mock sqlmock.Sqlmock, id int, archived bool) {
+	mock.ExpectQuery(regexp.QuoteMeta("FROM widgets WHERE id = ?")).
+		WithArgs(id).
+		WillReturnRows(widgetRows().
+			AddRow(id, "Plan", "", 0, 1000, "", true, "price_1", "month", "plan", archived, time.Now(), time.Now()))
+}
+
+func TestChangePlanRejectsArchivedPlan(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	repos := repository.NewRepositories(db)
+	s := NewSubscriptionService(repos.Subscription, repos.Widget, cards.NewFakeGateway())
+
+	expectPlan(mock, 5, true)
+
+	if _, err := s.ChangePlan(context.Background(), 1, 5); !errors.Is(err, ErrPlanArchived) {
+		t.Fatalf("ChangePlan error = %v, want ErrPlanArchived", err)
+	}
+
+	// The subscription is neither read nor changed.
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -3,10 +3,12 @@ package services
 import (
 	"context"
 
+	"github.com/mlvieira/store/internal/metrics"
 	"github.com/mlvieira/store/internal/models"
 	"github.com/mlvieira/store/internal/repository"
 )
 
+// TransactionService saves and lists payment transactions.
 type TransactionService struct {
 	repo repository.TransactionRepository
 }
@@ -18,5 +20,19 @@ func NewTransactionService(repo repository.TransactionRepository) *TransactionSe
 
 // SaveTransaction saves a transaction and returns the ID.
 func (s *TransactionService) SaveTransaction(ctx context.Context, txn models.Transaction) (int, error) {
-	return s.repo.InsertTransaction(ctx, txn)
+	id, err := s.repo.InsertTransaction(ctx, txn)
+	if err != nil {
+		return 0, err
+	}
+
+	if txn.TransactionStatusID == models.TransactionStatusCleared {
+		metrics.PaymentCleared(txn.Currency, txn.Amount)
+	}
+
+	return id, nil
+}
+
+// ListTransactions returns a page of transactions and the total number of matching transactions.
+func (s *TransactionService) ListTransactions(ctx context.Context, filter repository.TransactionFilter) ([]models.Transaction, int, error) {
+	return s.repo.ListTransactions(ctx, filter)
 }
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/mlvieira/store/internal/cards"
+	"github.com/mlvieira/store/internal/models"
+	"github.com/mlvieira/store/internal/repository"
+	"github.com/stripe/stripe-go/v81"
+)
+
+// WebhookService applies verified Stripe webhook events to the store.
+type WebhookService struct {
+	repos   *repository.Repositories
+	gateway cards.PaymentGateway
+}
+
+// NewWebhookService initializes a new WebhookService instance.
+func NewWebhookService(repos *repository.Repositories, gateway cards.PaymentGateway) *WebhookService {
+	return &WebhookService{repos: repos, gateway: gateway}
+}
+
+// HandleEvent applies a verified Stripe event to the matching transactions
+// and orders. The event is recorded as processed in the same SQL transaction
+// as its changes, so a failure leaves nothing behind for Stripe's retry. It
+// reports false when the event was already processed.
+func (s *WebhookService) HandleEvent(ctx context.Context, event stripe.Event) (bool, error) {
+	var (
+		isNew  bool
+		placed *Purchase
+	)
+
+	err := s.repos.WithTx(ctx, func(repos *repository.Repositories) error {
+		var err error
+		isNew, err = repos.WebhookEvent.InsertEvent(ctx, event.ID, string(event.Type))
+		if err != nil || !isNew {
+			return err
+		}
+
+		placed, err = s.dispatch(ctx, repos, event)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if placed != nil {
+		recordPurchase(*placed)
+	}
+
+	return isNew, nil
+}
+
+// dispatch routes an event to its handler based on the event type. It
+// returns the purchase it recorded, if any.
+func (s *WebhookService) dispatch(ctx context.Context, repos *repository.Repositories, event stripe.Event) (*Purchase, error) {
+	switch event.Type {
+	case stripe.EventTypePaymentIntentSucceeded:
+		var pi stripe.PaymentIntent
+		if err := json.Unmarshal(event.Data.Raw, &pi); err != nil {
+			return nil, err
+		}
+		return s.paymentSucceeded(ctx, repos, cards.PaymentIntentFromStripe(&pi))
+
+	case stripe.EventTypePaymentIntentPaymentFailed:
+		var pi stripe.PaymentIntent
+		if err := json.Unmarshal(event.Data.Raw, &pi); err != nil {
+			return nil, err
+		}
+		return nil, setStatus(ctx, repos, pi.ID, models.TransactionStatusDeclined, models.OrderStatusCancelled)
+
+	case stripe.EventTypeChargeRefunded:
+		var ch stripe.Charge
+		if err := json.Unmarshal(event.Data.Raw, &ch); err != nil {
+			return nil, err
+		}
+		if ch.PaymentIntent == nil {
+			return nil, nil
+		}
+		return nil, setRefund(ctx, repos, ch.PaymentIntent.ID, ch.AmountRefunded, ch.Amount)
+
+	case stripe.EventTypeInvoicePaid:
+		var inv stripe.Invoice
+		if err := json.Unmarshal(event.Data.Raw, &inv); err != nil {
+			return nil, err
+		}
+		if inv.PaymentIntent == nil {
+			return nil, nil
+		}
+		return nil, setStatus(ctx, repos, inv.PaymentIntent.ID, models.TransactionStatusCleared, models.OrderStatusCleared)
+
+	case stripe.EventTypeCustomerSubscriptionUpdated, stripe.EventTypeCustomerSubscriptionDeleted:
+		var sub stripe.Subscription
+		if err := json.Unmarshal(event.Data.Raw, &sub); err != nil {
+			return nil, err
+		}
+		return nil, syncSubscription(ctx, repos.Subscription, cards.SubscriptionFromStripe(&sub))
+
+	default:
+		return nil, nil
+	}
+}
+
+// paymentSucceeded clears the transaction and order paid with a payment
+// intent. When the payment intent is a one-off purchase that was never
+// recorded, because the customer's browser did not come back after paying,
+// the purchase is recorded from its metadata instead. A purchase whose widget
+// sold out in the meantime, or whose payment does not match the widget
+// price, is refunded.
+func (s *WebhookService) paymentSucceeded(ctx context.Context, repos *repository.Repositories, pi *cards.PaymentIntent) (*Purchase, error) {
+	if pi.Metadata[metadataWidgetID] == "" {
+		return nil, setStatus(ctx, repos, pi.ID, models.TransactionStatusCleared, models.OrderStatusCleared)
+	}
+
+	p, created, err := checkoutPaymentIntent(ctx, repos, s.gateway, pi)
+	if unrecordable(err) {
+		_, _, err = s.gateway.Refund(pi.ID, 0)
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !created {
+		return nil, setStatus(ctx, repos, pi.ID, models.TransactionStatusCleared, models.OrderStatusCleared)
+	}
+
+	return &p, nil
+}
+
+// setStatus updates the transaction and order paid with the given payment
+// intent, where their current status allows it.
+func setStatus(ctx context.Context, repos *repository.Repositories, paymentIntent string, txnStatusID, orderStatusID int) error {
+	if _, err := repos.Transaction.UpdateStatusByPaymentIntent(ctx, paymentIntent, txnStatusID); err != nil {
+		return err
+	}
+
+	_, err := repos.Order.UpdateStatusByPaymentIntent(ctx, paymentIntent, orderStatusID)
+	return err
+}
+
+// setRefund records a refund reported by Stripe. The order only moves to the
+// refunded status, and its widgets back in stock, once the whole charge has
+// been refunded.
+func setRefund(ctx context.Context, repos *repository.Repositories, paymentIntent string, refunded, amount int64) error {
+	if refunded < amount {
+		_, err := repos.Transaction.UpdateRefund(ctx, paymentIntent, refunded, models.TransactionStatusPartiallyRefunded)
+		return err
+	}
+
+	if _, err := repos.Transaction.UpdateRefund(ctx, paymentIntent, refunded, models.TransactionStatusRefunded); err != nil {
+		return err
+	}
+
+	changed, err := repos.Order.UpdateStatusByPaymentIntent(ctx, paymentIntent, models.OrderStatusRefunded)
+	if err != nil || changed == 0 {
+		// Refunds issued through OrderService already restored the stock.
+		return err
+	}
+
+	order, err := repos.Order.GetOrderByPaymentIntent(ctx, paymentIntent)
+	if err != nil {
+		return err
+	}
+
+	return repos.Widget.RestoreInventory(ctx, order.WidgetID, order.Quantity)
+}
@@ -0,0 +1,346 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mlvieira/store/internal/cards"
+	"github.com/mlvieira/store/internal/models"
+	"github.com/mlvieira/store/internal/repository"
+	"github.com/stripe/stripe-go/v81"
+)
+
+// newWebhookService returns a WebhookService on a mocked database and the
+// fake gateway.
+func newWebhookService(t *testing.T) (*WebhookService, sqlmock.Sqlmock, *cards.FakeGateway) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	gateway := cards.NewFakeGateway()
+
+	return NewWebhookService(repository.NewRepositories(db), gateway), mock, gateway
+}
+
+// newEvent returns a Stripe event carrying obj.
+func newEvent(t *testing.T, id string, typ stripe.EventType, obj any) stripe.Event {
+	t.Helper()
+
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return stripe.Event{ID: id, Type: typ, Data: &stripe.EventData{Raw: raw}}
+}
+
+// paidCustomer is the customer of the purchases in the tests.
+var paidCustomer = models.Customer{
+	FirstName: "Ana",
+	LastName:  "Silva",
+	Email:     "ana@example.com",
+}
+
+// paidIntent returns a payment intent for one unit of widget 3 paid on the
+// fake gateway.
+func paidIntent(t *testing.T, gateway *cards.FakeGateway) *cards.PaymentIntent {
+	t.Helper()
+
+	pi, _, err := gateway.CreatePaymentIntent("brl", 1500, PurchaseMetadata(3, paidCustomer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pi, _, err = gateway.ConfirmPaymentIntent(pi.ID, "pm_"+cards.FakeCardSuccess)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pi
+}
+
+// expectEvent expects a transaction recording the event, and reports it new
+// or already recorded.
+func expectEvent(mock sqlmock.Sqlmock, id string, isNew bool) {
+	var rows int64
+	if isNew {
+		rows = 1
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT IGNORE INTO webhook_events")).
+		WithArgs(id, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, rows))
+}
+
+func TestHandleEventSkipsReplays(t *testing.T) {
+	s, mock, _ := newWebhookService(t)
+
+	expectEvent(mock, "evt_1", false)
+	mock.ExpectCommit()
+
+	processed, err := s.HandleEvent(context.Background(), newEvent(t, "evt_1",
+		stripe.EventTypePaymentIntentSucceeded, stripe.PaymentIntent{ID: "pi_1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if processed {
+		t.Error("replayed event was processed again")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandleEventRollsBackEventWithItsChanges(t *testing.T) {
+	s, mock, _ := newWebhookService(t)
+
+	expectEvent(mock, "evt_1", true)
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE transactions")).
+		WillReturnError(errors.New("connection lost"))
+	mock.ExpectRollback()
+
+	_, err := s.HandleEvent(context.Background(), newEvent(t, "evt_1",
+		stripe.EventTypePaymentIntentSucceeded, stripe.PaymentIntent{ID: "pi_1"}))
+	if err == nil {
+		t.Fatal("HandleEvent succeeded although the update failed")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandleEventGuardsStatusTransitions(t *testing.T) {
+	s, mock, _ := newWebhookService(t)
+
+	// A late payment_intent.succeeded only clears pending or declined
+	// transactions and cancelled orders, never refunded ones.
+	expectEvent(mock, "evt_1", true)
+	mock.ExpectExec(regexp.QuoteMeta("WHERE payment_intent = ? AND transaction_status_id IN (?, ?)")).
+		WithArgs(models.TransactionStatusCleared, sqlmock.AnyArg(), "pi_1",
+			models.TransactionStatusPending, models.TransactionStatusDeclined).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("WHERE t.payment_intent = ? AND o.status_id IN (?)")).
+		WithArgs(models.OrderStatusCleared, sqlmock.AnyArg(), "pi_1", models.OrderStatusCancelled).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	processed, err := s.HandleEvent(context.Background(), newEvent(t, "evt_1",
+		stripe.EventTypePaymentIntentSucceeded, stripe.PaymentIntent{ID: "pi_1"}))
+	if err != nil || !processed {
+		t.Fatalf("HandleEvent = %v, %v", processed, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandleEventFailedPaymentKeepsOrder(t *testing.T) {
+	s, mock, _ := newWebhookService(t)
+
+	// Only the transaction is declined: no order status may move to
+	// cancelled, so the order is not touched.
+	expectEvent(mock, "evt_1", true)
+	mock.ExpectExec(regexp.QuoteMeta("WHERE payment_intent = ? AND transaction_status_id IN (?)")).
+		WithArgs(models.TransactionStatusDeclined, sqlmock.AnyArg(), "pi_1", models.TransactionStatusPending).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	if _, err := s.HandleEvent(context.Background(), newEvent(t, "evt_1",
+		stripe.EventTypePaymentIntentPaymentFailed, stripe.PaymentIntent{ID: "pi_1"})); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandleEventRefundAlreadyApplied(t *testing.T) {
+	s, mock, _ := newWebhookService(t)
+
+	// The order was refunded through OrderService, so it is not refunded,
+	// nor its stock restored, a second time.
+	expectEvent(mock, "evt_1", true)
+	mock.ExpectExec(regexp.QuoteMeta("WHERE payment_intent = ? AND refunded_amount <= ?")).
+		WithArgs(int64(1500), models.TransactionStatusRefunded, sqlmock.AnyArg(), "pi_1", int64(1500),
+			models.TransactionStatusCleared, models.TransactionStatusPartiallyRefunded).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE orders o")).
+		WithArgs(models.OrderStatusRefunded, sqlmock.AnyArg(), "pi_1", models.OrderStatusCleared).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	if _, err := s.HandleEvent(context.Background(), newEvent(t, "evt_1", stripe.EventTypeChargeRefunded, stripe.Charge{
+		ID:             "ch_1",
+		Amount:         1500,
+		AmountRefunded: 1500,
+		PaymentIntent:  &stripe.PaymentIntent{ID: "pi_1"},
+	})); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandleEventRecordsOrderFromMetadata(t *testing.T) {
+	s, mock, gateway := newWebhookService(t)
+	pi := paidIntent(t, gateway)
+
+	expectEvent(mock, "evt_1", true)
+	mock.ExpectQuery(regexp.QuoteMeta("WHERE t.payment_intent = ?")).
+		WithArgs(pi.ID).
+		WillReturnError(sql.ErrNoRows)
+	expectWidget(mock, 1500)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT is_recurring FROM widgets")).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"is_recurring"}).AddRow(false))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE widgets")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO customers")).
+		WithArgs("Ana", "Silva", "ana@example.com", "", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(7, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions")).
+		WithArgs(int64(1500), "brl", "4242", sqlmock.AnyArg(), models.TransactionStatusCleared,
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), pi.ID, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(9, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO orders")).
+		WithArgs(3, 9, models.OrderStatusCleared, 1, int64(1500), sqlmock.AnyArg(), sqlmock.AnyArg(), 7).
+		WillReturnResult(sqlmock.NewResult(11, 1))
+	mock.ExpectCommit()
+
+	processed, err := s.HandleEvent(context.Background(), newEvent(t, "evt_1", stripe.EventTypePaymentIntentSucceeded, pi))
+	if err != nil || !processed {
+		t.Fatalf("HandleEvent = %v, %v", processed, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandleEventRefundsSoldOutPurchase(t *testing.T) {
+	s, mock, gateway := newWebhookService(t)
+	pi := paidIntent(t, gateway)
+
+	expectEvent(mock, "evt_1", true)
+	mock.ExpectQuery(regexp.QuoteMeta("WHERE t.payment_intent = ?")).
+		WithArgs(pi.ID).
+		WillReturnError(sql.ErrNoRows)
+	expectWidget(mock, 1500)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT is_recurring FROM widgets")).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"is_recurring"}).AddRow(false))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE widgets")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	if _, err := s.HandleEvent(context.Background(), newEvent(t, "evt_1", stripe.EventTypePaymentIntentSucceeded, pi)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := gateway.Refund(pi.ID, 1); err == nil {
+		t.Error("sold out purchase was not refunded")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandleEventRefundsMismatchedPayment(t *testing.T) {
+	s, mock, gateway := newWebhookService(t)
+	pi := paidIntent(t, gateway)
+
+	// The widget costs more than was paid: nothing is written.
+	expectEvent(mock, "evt_1", true)
+	mock.ExpectQuery(regexp.QuoteMeta("WHERE t.payment_intent = ?")).
+		WithArgs(pi.ID).
+		WillReturnError(sql.ErrNoRows)
+	expectWidget(mock, 9900)
+	mock.ExpectCommit()
+
+	if _, err := s.HandleEvent(context.Background(), newEvent(t, "evt_1", stripe.EventTypePaymentIntentSucceeded, pi)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := gateway.Refund(pi.ID, 1); err == nil {
+		t.Error("mismatched payment was not refunded")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandleEventSkipsRecordedPurchase(t *testing.T) {
+	s, mock, gateway := newWebhookService(t)
+	pi := paidIntent(t, gateway)
+
+	// The browser came back first and recorded the order: the event only
+	// clears it.
+	expectEvent(mock, "evt_1", true)
+	mock.ExpectQuery(regexp.QuoteMeta("WHERE t.payment_intent = ?")).
+		WithArgs(pi.ID).
+		WillReturnRows(orderRows().AddRow(orderRow(11)...))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE transactions")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE orders o")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	if _, err := s.HandleEvent(context.Background(), newEvent(t, "evt_1", stripe.EventTypePaymentIntentSucceeded, pi)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// orderRows returns the columns of an order selected by the order repository.
+func orderRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{
+		"o.id", "o.widget_id", "o.transaction_id", "o.customer_id", "o.status_id",
+		"o.quantity", "o.amount", "o.created_at", "o.updated_at",
+		"w.id", "w.name", "w.description", "w.price", "w.is_recurring", "w.slug",
+		"t.id", "t.amount", "t.currency", "t.last_four", "t.bank_return_code",
+		"t.transaction_status_id", "t.expiry_month", "t.expiry_year",
+		"t.payment_intent", "t.payment_method", "t.refunded_amount",
+		"t.created_at", "t.updated_at",
+		"c.id", "c.first_name", "c.last_name", "c.email",
+		"s.id", "s.name",
+	})
+}
+
+// orderRow returns a cleared order of one unit of widget 3.
+func orderRow(id int) []driver.Value {
+	now := time.Now()
+	return []driver.Value{
+		id, 3, 9, 7, models.OrderStatusCleared,
+		1, 1500, now, now,
+		3, "Widget", "", 1500, false, "widget",
+		9, 1500, "brl", "4242", "ch_1",
+		models.TransactionStatusCleared, 12, 2030,
+		"pi_1", "pm_1", 0,
+		now, now,
+		7, "Ana", "Silva", "ana@example.com",
+		models.OrderStatusCleared, "Cleared",
+	}
+}
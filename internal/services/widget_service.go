@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/mlvieira/store/internal/models"
+	"github.com/mlvieira/store/internal/repository"
+)
+
+// ErrInvalidWidget is returned when a widget fails validation.
+var ErrInvalidWidget = errors.New("invalid widget")
+
+// widgetSlugPattern matches lowercase, dash separated URL slugs.
+var widgetSlugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// billingIntervals lists the intervals a recurring widget can bill on.
+var billingIntervals = map[string]bool{
+	models.BillingIntervalDay:   true,
+	models.BillingIntervalWeek:  true,
+	models.BillingIntervalMonth: true,
+	models.BillingIntervalYear:  true,
+}
+
+// widgetImageExtensions lists the image types served from static/images.
+var widgetImageExtensions = map[string]bool{
+	".png":  true,
+	".jpg":  true,
+	".jpeg": true,
+	".gif":  true,
+	".webp": true,
+}
+
+// WidgetService validates and manages the widgets of the catalog.
+type WidgetService struct {
+	repos *repository.Repositories
+}
+
+// NewWidgetService initializes a new WidgetService instance.
+func NewWidgetService(repos *repository.Repositories) *WidgetService {
+	return &WidgetService{repos: repos}
+}
+
+// ListWidgets returns a page of the catalog and the total number of matching widgets.
+func (s *WidgetService) ListWidgets(ctx context.Context, filter repository.WidgetFilter) ([]models.Widget, int, error) {
+	return s.repos.Widget.ListWidgets(ctx, filter)
+}
+
+// CreateWidget validates and saves a new widget, returning its ID.
+func (s *WidgetService) CreateWidget(ctx context.Context, widget models.Widget) (int, error) {
+	if err := validateWidget(&widget); err != nil {
+		return 0, err
+	}
+
+	id, err := s.repos.Widget.InsertWidget(ctx, widget)
+	return id, slugTakenErr(err)
+}
+
+// UpdateWidget validates and saves changes to an existing widget, adding
+// inventoryDelta units to its stock. The inventory level of widget is ignored
+// so an edit never undoes the sales made while it was open.
+func (s *WidgetService) UpdateWidget(ctx context.Context, widget models.Widget, inventoryDelta int) (models.Widget, error) {
+	err := s.repos.WithTx(ctx, func(repos *repository.Repositories) error {
+		existing, err := repos.Widget.GetWidgetByID(ctx, widget.ID)
+		if err != nil {
+			return err
+		}
+
+		widget.InventoryLevel = existing.InventoryLevel
+		if err := validateWidget(&widget); err != nil {
+			return err
+		}
+
+		if err := repos.Widget.UpdateWidget(ctx, widget); err != nil {
+			return slugTakenErr(err)
+		}
+
+		if inventoryDelta == 0 {
+			return nil
+		}
+
+		err = repos.Widget.AdjustInventory(ctx, widget.ID, inventoryDelta)
+		if errors.Is(err, repository.ErrInsufficientStock) {
+			return fmt.Errorf("%w: inventory cannot go below zero", ErrInvalidWidget)
+		}
+		return err
+	})
+	if err != nil {
+		return widget, err
+	}
+
+	return s.repos.Widget.GetWidgetByID(ctx, widget.ID)
+}
+
+// ArchiveWidget removes a widget from the catalog. Past orders keep pointing at it.
+func (s *WidgetService) ArchiveWidget(ctx context.Context, id int) error {
+	if _, err := s.repos.Widget.GetWidgetByID(ctx, id); err != nil {
+		return err
+	}
+
+	return s.repos.Widget.ArchiveWidget(ctx, id)
+}
+
+// validateWidget trims the widget's text fields and checks them.
+func validateWidget(widget *models.Widget) error {
+	widget.Name = strings.TrimSpace(widget.Name)
+	widget.Description = strings.TrimSpace(widget.Description)
+	widget.Image = strings.TrimSpace(widget.Image)
+	widget.PlanID = strings.TrimSpace(widget.PlanID)
+	widget.Slug = strings.ToLower(strings.TrimSpace(widget.Slug))
+	widget.BillingInterval = strings.TrimSpace(widget.BillingInterval)
+
+	if widget.IsRecurring && widget.BillingInterval == "" {
+		widget.BillingInterval = models.BillingIntervalMonth
+	}
+
+	switch {
+	case widget.Name == "":
+		return fmt.Errorf("%w: name is required", ErrInvalidWidget)
+	case widget.Price <= 0:
+		return fmt.Errorf("%w: price must be greater than zero", ErrInvalidWidget)
+	case widget.InventoryLevel < 0:
+		return fmt.Errorf("%w: inventory_level cannot be negative", ErrInvalidWidget)
+	case widget.IsRecurring && widget.PlanID == "":
+		return fmt.Errorf("%w: plan_id is required for recurring widgets", ErrInvalidWidget)
+	case !widget.IsRecurring && widget.PlanID != "":
+		return fmt.Errorf("%w: plan_id is only allowed on recurring widgets", ErrInvalidWidget)
+	case widget.IsRecurring && !billingIntervals[widget.BillingInterval]:
+		return fmt.Errorf("%w: billing_interval must be day, week, month or year", ErrInvalidWidget)
+	case !widget.IsRecurring && widget.BillingInterval != "":
+		return fmt.Errorf("%w: billing_interval is only allowed on recurring widgets", ErrInvalidWidget)
+	case !widgetSlugPattern.MatchString(widget.Slug):
+		return fmt.Errorf("%w: slug must be lowercase letters, digits and dashes", ErrInvalidWidget)
+	}
+
+	return validateWidgetImage(widget.Image)
+}
+
+// slugTakenErr reports a unique key violation as a validation error, since
+// the slug is the only unique column a caller controls.
+func slugTakenErr(err error) error {
+	if errors.Is(err, repository.ErrDuplicate) {
+		return fmt.Errorf("%w: slug is already in use", ErrInvalidWidget)
+	}
+	return err
+}
+
+// validateWidgetImage checks that image is a plain file name inside
+// static/images. An empty image is allowed.
+func validateWidgetImage(image string) error {
+	if image == "" {
+		return nil
+	}
+
+	if image != filepath.Base(image) || strings.ContainsAny(image, `/\`) || strings.HasPrefix(image, ".") {
+		return fmt.Errorf("%w: image must be a file name under static/images", ErrInvalidWidget)
+	}
+
+	if !widgetImageExtensions[strings.ToLower(filepath.Ext(image))] {
+		return fmt.Errorf("%w: image must be a png, jpg, gif or webp file", ErrInvalidWidget)
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mlvieira/store/internal/models"
+	"github.com/mlvieira/store/internal/repository"
+)
+
+func TestUpdateWidgetAdjustsInventory(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	s := NewWidgetService(repository.NewRepositories(db))
+	edit := models.Widget{ID: 3, Name: "Widget", Price: 1500, Slug: "widget", InventoryLevel: 10}
+
+	// The edit was opened with 10 in stock and two more are added. The level
+	// in the edit is not written back, so a sale made meanwhile is kept.
+	mock.ExpectBegin()
+	expectWidget(mock, 1500)
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE widgets SET name = ?, description = ?, price = ?,")).
+		WithArgs("Widget", "", int64(1500), "", false, "", "", "widget", sqlmock.AnyArg(), 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("SET inventory_level = inventory_level + ?")).
+		WithArgs(2, sqlmock.AnyArg(), 3, 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(regexp.QuoteMeta("FROM widgets WHERE id = ?")).
+		WithArgs(3).
+		WillReturnRows(widgetRows().
+			AddRow(3, "Widget", "", 11, 1500, "", false, "", "", "widget", false, time.Now(), time.Now()))
+
+	widget, err := s.UpdateWidget(context.Background(), edit, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if widget.InventoryLevel != 11 {
+		t.Errorf("InventoryLevel = %d, want the stored 11", widget.InventoryLevel)
+	}
+
+	// Taking out more than is left is rejected along with the rest of the edit.
+	mock.ExpectBegin()
+	expectWidget(mock, 1500)
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE widgets SET name = ?")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("SET inventory_level = inventory_level + ?")).
+		WithArgs(-12, sqlmock.AnyArg(), 3, -12).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	if _, err := s.UpdateWidget(context.Background(), edit, -12); !errors.Is(err, ErrInvalidWidget) {
+		t.Errorf("UpdateWidget error = %v, want ErrInvalidWidget", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,14 +1,29 @@
 package shared
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
+// ShutdownHook is a named cleanup step run after the server has stopped,
+// such as closing the database or flushing the mail queue.
+type ShutdownHook struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
 // Serve starts an HTTP server with the specified configuration and handler.
-func Serve(port int, env string, handler http.Handler, infoLog *log.Logger) error {
+// On SIGINT or SIGTERM it stops accepting connections and waits up to
+// drainTimeout for in-flight requests to finish. The hooks then run in
+// order, sharing a deadline of the same length, whether the server was
+// stopped by a signal or failed.
+func Serve(port int, env string, handler http.Handler, logger *slog.Logger, drainTimeout time.Duration, hooks ...ShutdownHook) error {
 	srv := &http.Server{
 		Addr:              fmt.Sprintf(":%d", port),
 		Handler:           handler,
@@ -18,6 +33,55 @@ func Serve(port int, env string, handler http.Handler, infoLog *log.Logger) erro
 		WriteTimeout:      5 * time.Second,
 	}
 
-	infoLog.Printf("Starting HTTP server in %s mode on port %d", env, port)
-	return srv.ListenAndServe()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		logger.Info("starting HTTP server", "env", env, "port", port)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	var err error
+	select {
+	case err = <-serveErr:
+	case <-ctx.Done():
+		// Restore the default handling so a second signal kills the process.
+		stop()
+		logger.Info("shutting down, waiting for open requests", "timeout", drainTimeout.String())
+		err = drain(srv, drainTimeout)
+	}
+
+	return errors.Join(err, runHooks(hooks, drainTimeout, logger))
+}
+
+// drain stops the server from accepting connections and waits for the open
+// ones to finish, closing whatever is left when the timeout expires.
+func drain(srv *http.Server, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		srv.Close()
+		return fmt.Errorf("draining connections: %w", err)
+	}
+
+	return nil
+}
+
+// runHooks runs the shutdown hooks in order. A failing hook does not stop
+// the ones after it; all errors are returned together.
+func runHooks(hooks []ShutdownHook, timeout time.Duration, logger *slog.Logger) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var errs []error
+	for _, hook := range hooks {
+		logger.Info("running shutdown hook", "hook", hook.Name)
+		if err := hook.Run(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("shutdown hook %s: %w", hook.Name, err))
+		}
+	}
+
+	return errors.Join(errs...)
 }
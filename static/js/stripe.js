@@ -1,5 +1,8 @@
 let card;
 let apiUrl = '';
+// ID of the request that rendered the page, forwarded to the API so its
+// log lines can be correlated with the web server's.
+let requestId = '';
 
 document.addEventListener('DOMContentLoaded', () => {
     const stripe = initializeStripe();
@@ -8,6 +11,10 @@ document.addEventListener('DOMContentLoaded', () => {
 });
 
 const initializeStripe = () => {
+    if (document.getElementById('payment_gateway')?.innerText === 'fake') {
+        return fakeStripe();
+    }
+
     const pkey = document.getElementById('stripe_public_key')?.innerText;
     if (!pkey) {
         showCardError('Failed to load payment processor. Please try again.');
@@ -16,8 +23,85 @@ const initializeStripe = () => {
     return Stripe(pkey);
 };
 
+// fakeStripe mimics the parts of Stripe.js used here so the pages work
+// against the server's fake gateway. The card element is a plain input and
+// the typed card number becomes the payment method ID (pm_<number>).
+const fakeStripe = () => {
+    const fakeCard = () => {
+        const input = document.createElement('input');
+        input.type = 'text';
+        input.className = 'border-0 w-100';
+        input.placeholder = '4242424242424242';
+        input.autocomplete = 'off';
+
+        return {
+            mount: (selector) => document.querySelector(selector).appendChild(input),
+            addEventListener: () => {},
+            number: () => input.value.replace(/\D/g, '') || '4242424242424242',
+        };
+    };
+
+    const intentID = (clientSecret) => clientSecret.split('_secret_')[0];
+
+    return {
+        elements: () => ({ create: () => fakeCard() }),
+        createPaymentMethod: async ({ card }) => {
+            const number = card.number();
+            return {
+                paymentMethod: {
+                    id: `pm_${number}`,
+                    card: {
+                        last4: number.slice(-4),
+                        brand: 'visa',
+                        exp_month: 12,
+                        exp_year: new Date().getFullYear() + 5,
+                    },
+                },
+            };
+        },
+        // The API confirms the payment against the fake gateway, which
+        // declines the magic decline cards like Stripe would.
+        confirmCardPayment: async (clientSecret, { payment_method }) => {
+            const response = await fetch(
+                `${apiUrl}/api/fake/payment-intents/${intentID(clientSecret)}/confirm`,
+                {
+                    method: 'POST',
+                    headers: apiHeaders(),
+                    body: JSON.stringify({ payment_method: payment_method }),
+                }
+            );
+            const data = await response.json();
+            if (!response.ok) {
+                return { error: { message: data.message || 'Your card was declined.' } };
+            }
+            return { paymentIntent: { ...data, object: 'payment_intent' } };
+        },
+        confirmCardSetup: async (clientSecret, { payment_method }) => ({
+            setupIntent: {
+                id: intentID(clientSecret),
+                object: 'setup_intent',
+                status: 'succeeded',
+                payment_method: payment_method,
+            },
+        }),
+    };
+};
+
+// apiHeaders returns the headers of a JSON request to the API.
+const apiHeaders = () => {
+    const headers = {
+        Accept: 'application/json',
+        'Content-Type': 'application/json',
+    };
+    if (requestId) {
+        headers['X-Request-ID'] = requestId;
+    }
+    return headers;
+};
+
 const initGlobalConfig = () => {
     apiUrl = document.getElementById('api_url')?.innerText;
+    requestId = document.querySelector('meta[name="request-id"]')?.content || '';
     if (!apiUrl) {
         showCardError('Failed to load API URL. Please try again.');
         throw new Error('API URL missing');
@@ -28,30 +112,35 @@ const setupFormListeners = (stripe) => {
     const form = document.getElementById('charge_form');
     const paymentMode = document.getElementById('payment_mode').value;
     const amountInput = document.getElementById('amount');
+    // Only one-time charges have an amount typed by the user.
+    const hasAmount = paymentMode === 'onetime';
+    // Authenticating a saved card charge needs neither an email nor a card.
+    const savedCard = paymentMode === 'authenticate';
 
-    if (paymentMode !== 'subscription') {
+    if (hasAmount) {
         setupInputValidation(amountInput);
     }
 
     form.addEventListener('submit', async (event) => {
         event.preventDefault();
-        const email = document.getElementById('email').value.trim();
+        const email = savedCard
+            ? ''
+            : document.getElementById('email').value.trim();
 
-        if (!validateEmail(email)) {
+        if (!savedCard && !validateEmail(email)) {
             showCardError('Please provide a valid email.');
             return;
         }
 
-        if (
-            paymentMode !== 'subscription' &&
-            !validateAmountInput(amountInput.value)
-        ) {
+        if (hasAmount && !validateAmountInput(amountInput.value)) {
             return;
         }
 
         toggleProcessingState(form, true);
         try {
-            const paymentMethod = await createPaymentMethod(stripe, email);
+            const paymentMethod = savedCard
+                ? { id: document.getElementById('payment_method').value }
+                : await createPaymentMethod(stripe, email);
             let clientSecret;
 
             if (paymentMode === 'subscription') {
@@ -65,6 +154,20 @@ const setupFormListeners = (stripe) => {
                     planId,
                     amountInput
                 );
+            } else if (paymentMode === 'setup') {
+                // Replacing a saved card: the server already created the SetupIntent.
+                clientSecret =
+                    document.getElementById('setup_client_secret')?.innerText;
+                if (!clientSecret) {
+                    throw new Error('Card setup is missing. Please reload the page.');
+                }
+            } else if (savedCard) {
+                // The server created the PaymentIntent when charging the saved card.
+                clientSecret =
+                    document.getElementById('payment_client_secret')?.innerText;
+                if (!clientSecret) {
+                    throw new Error('Payment is missing. Please reload the page.');
+                }
             } else {
                 const amountInCents = Math.round(
                     parseFloat(amountInput.value) * 100
@@ -92,7 +195,9 @@ const setupFormListeners = (stripe) => {
         }
     });
 
-    setupCardElements(stripe);
+    if (!savedCard) {
+        setupCardElements(stripe);
+    }
 };
 
 const createPaymentMethod = async (stripe, email) => {
@@ -128,17 +233,13 @@ const createSubscription = async (
         first_name: document.querySelector('#first-name').value,
         last_name: document.querySelector('#last-name').value,
         product_id: document.querySelector('input[name="widget_id"]').value,
-        currency: 'brl',
         amount: Math.round(parseFloat(amountInput.value) * 100),
     };
 
     console.log('Sending payload to create subscription:', payload);
     const response = await fetch(`${apiUrl}/api/create-subscription`, {
         method: 'POST',
-        headers: {
-            Accept: 'application/json',
-            'Content-Type': 'application/json',
-        },
+        headers: apiHeaders(),
         body: JSON.stringify(payload),
     });
 
@@ -146,7 +247,7 @@ const createSubscription = async (
     if (!response.ok) {
         const errorText = await response.text();
         console.error('Create Subscription Error Response:', errorText);
-        throw new Error(`HTTP Error: ${response.status} - ${errorText}`);
+        throw new Error(apiErrorMessage(response.status, errorText));
     }
 
     const data = await response.json();
@@ -162,20 +263,36 @@ const createSubscription = async (
     return data.content;
 };
 
+// createPaymentIntent charges a widget's price on product pages, sending the
+// customer's details so the order is recorded even if the page is closed
+// right after paying. Without a widget it is a virtual terminal charge,
+// which needs the staff's API token.
 const createPaymentIntent = async (amount, paymentMethodId) => {
     const payload = {
         amount: amount,
-        currency: 'brl',
         payment_method: paymentMethodId,
     };
+    const headers = apiHeaders();
+    let endpoint = `${apiUrl}/api/payment-intent`;
+
+    const widgetInput = document.querySelector('input[name="widget_id"]');
+    if (widgetInput) {
+        payload.product_id = widgetInput.value;
+        payload.first_name = document.querySelector('#first-name').value;
+        payload.last_name = document.querySelector('#last-name').value;
+        payload.email = document.getElementById('email').value.trim();
+    } else {
+        endpoint = `${apiUrl}/api/terminal/payment-intent`;
+        const token = document.getElementById('api_token')?.innerText;
+        if (token) {
+            headers.Authorization = `Bearer ${token}`;
+        }
+    }
 
     console.log('Sending payload to create PI:', payload);
-    const response = await fetch(`${apiUrl}/api/payment-intent`, {
+    const response = await fetch(endpoint, {
         method: 'POST',
-        headers: {
-            Accept: 'application/json',
-            'Content-Type': 'application/json',
-        },
+        headers: headers,
         body: JSON.stringify(payload),
     });
 
@@ -183,7 +300,7 @@ const createPaymentIntent = async (amount, paymentMethodId) => {
     if (!response.ok) {
         const errorText = await response.text();
         console.error('Create PI Error Response:', errorText);
-        throw new Error(`HTTP Error: ${response.status} - ${errorText}`);
+        throw new Error(apiErrorMessage(response.status, errorText));
     }
 
     const data = await response.json();
@@ -199,6 +316,19 @@ const createPaymentIntent = async (amount, paymentMethodId) => {
     return data.client_secret;
 };
 
+// apiErrorMessage prefers the message of a JSON error response over the raw body.
+const apiErrorMessage = (status, body) => {
+    try {
+        const data = JSON.parse(body);
+        if (data.message) {
+            return data.message;
+        }
+    } catch (e) {
+        // Not JSON, fall through to the raw body.
+    }
+    return `HTTP Error: ${status} - ${body}`;
+};
+
 const confirmIntent = async (
     stripe,
     clientSecret,
@@ -208,7 +338,7 @@ const confirmIntent = async (
 ) => {
     let confirmationPromise;
 
-    if (paymentMode !== 'subscription') {
+    if (paymentMode === 'onetime' || paymentMode === 'authenticate') {
         console.log(
             'Attempting confirmCardPayment with clientSecret:',
             clientSecret,
@@ -263,16 +393,19 @@ const confirmIntent = async (
         );
     }
 
-    processPaymentSuccess(intent);
+    processPaymentSuccess(
+        intent,
+        paymentMode === 'setup' ? 'Card saved!' : 'Payment successful!'
+    );
     setTimeout(() => {
         console.log('Submitting form after success.');
         form.submit();
     }, 1000);
 };
 
-const processPaymentSuccess = (intent) => {
+const processPaymentSuccess = (intent, message) => {
     console.log('Processing success for intent:', intent);
-    showCardSuccess();
+    showCardSuccess(message);
 
     const paymentMethodInput = document.getElementById('payment_method');
     if (paymentMethodInput && intent.payment_method) {
@@ -282,7 +415,7 @@ const processPaymentSuccess = (intent) => {
                 : intent.payment_method.id;
     }
 
-    const intentIdInput = document.getElementById('intent_id');
+    const intentIdInput = document.getElementById('payment_intent');
     if (intentIdInput) {
         intentIdInput.value = intent.id;
     }
@@ -380,10 +513,10 @@ const showCardError = (message) => {
     cardMessages.innerText = message || 'An unexpected error occurred.';
 };
 
-const showCardSuccess = () => {
+const showCardSuccess = (message) => {
     const cardMessages = document.getElementById('card-messages');
     if (!cardMessages) return;
     cardMessages.classList.add('alert-success');
     cardMessages.classList.remove('alert-danger', 'd-none');
-    cardMessages.innerText = 'Payment successful!';
+    cardMessages.innerText = message || 'Payment successful!';
 };